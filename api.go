@@ -10,53 +10,129 @@ This module implements the Napping API.
 */
 
 import (
+	"context"
 	"net/url"
 )
 
 // Send composes and sends and HTTP request.
 func Send(r *Request) (*Response, error) {
+	return SendWithContext(context.Background(), r)
+}
+
+// SendWithContext composes and sends an HTTP request, canceled when ctx is
+// done.
+func SendWithContext(ctx context.Context, r *Request) (*Response, error) {
+	s := Session{}
+	return s.SendWithContext(ctx, r)
+}
+
+// Exists reports whether the resource at url exists; see Session.Exists.
+func Exists(url string, p *url.Values) (bool, *Response, error) {
+	return ExistsCtx(context.Background(), url, p)
+}
+
+// ExistsCtx is Exists, canceled when ctx is done.
+func ExistsCtx(ctx context.Context, url string, p *url.Values) (bool, *Response, error) {
 	s := Session{}
-	return s.Send(r)
+	return s.ExistsCtx(ctx, url, p)
 }
 
 // Get sends a GET request.
 func Get(url string, p *url.Values) (*Response, error) {
+	return GetCtx(context.Background(), url, p)
+}
+
+// GetCtx sends a GET request, canceled when ctx is done.
+func GetCtx(ctx context.Context, url string, p *url.Values) (*Response, error) {
 	s := Session{}
-	return s.Get(url, p)
+	return s.GetCtx(ctx, url, p)
 }
 
 // Options sends an OPTIONS request.
 func Options(url string) (*Response, error) {
+	return OptionsCtx(context.Background(), url)
+}
+
+// OptionsCtx sends an OPTIONS request, canceled when ctx is done.
+func OptionsCtx(ctx context.Context, url string) (*Response, error) {
 	s := Session{}
-	return s.Options(url)
+	return s.OptionsCtx(ctx, url)
 }
 
 // Head sends a HEAD request.
 func Head(url string) (*Response, error) {
+	return HeadCtx(context.Background(), url)
+}
+
+// HeadCtx sends a HEAD request, canceled when ctx is done.
+func HeadCtx(ctx context.Context, url string) (*Response, error) {
 	s := Session{}
-	return s.Head(url)
+	return s.HeadCtx(ctx, url)
 }
 
 // Post sends a POST request.
 func Post(url string, payload interface{}) (*Response, error) {
+	return PostCtx(context.Background(), url, payload)
+}
+
+// PostCtx sends a POST request, canceled when ctx is done.
+func PostCtx(ctx context.Context, url string, payload interface{}) (*Response, error) {
 	s := Session{}
-	return s.Post(url, payload)
+	return s.PostCtx(ctx, url, payload)
+}
+
+// PostForm sends data as an application/x-www-form-urlencoded POST request.
+func PostForm(url string, data url.Values, result, errMsg interface{}) (*Response, error) {
+	return PostFormCtx(context.Background(), url, data, result, errMsg)
+}
+
+// PostFormCtx sends a form POST request, canceled when ctx is done.
+func PostFormCtx(ctx context.Context, url string, data url.Values, result, errMsg interface{}) (*Response, error) {
+	s := Session{}
+	return s.PostFormCtx(ctx, url, data, result, errMsg)
+}
+
+// PostAndFollow POSTs payload and fetches the created resource; see
+// Session.PostAndFollow.
+func PostAndFollow(url string, payload, result, errMsg interface{}) (*Response, error) {
+	return PostAndFollowCtx(context.Background(), url, payload, result, errMsg)
+}
+
+// PostAndFollowCtx is PostAndFollow, canceled when ctx is done.
+func PostAndFollowCtx(ctx context.Context, url string, payload, result, errMsg interface{}) (*Response, error) {
+	s := Session{}
+	return s.PostAndFollowCtx(ctx, url, payload, result, errMsg)
 }
 
 // Put sends a PUT request.
 func Put(url string, payload interface{}) (*Response, error) {
+	return PutCtx(context.Background(), url, payload)
+}
+
+// PutCtx sends a PUT request, canceled when ctx is done.
+func PutCtx(ctx context.Context, url string, payload interface{}) (*Response, error) {
 	s := Session{}
-	return s.Put(url, payload)
+	return s.PutCtx(ctx, url, payload)
 }
 
 // Patch sends a PATCH request.
 func Patch(url string, payload interface{}) (*Response, error) {
+	return PatchCtx(context.Background(), url, payload)
+}
+
+// PatchCtx sends a PATCH request, canceled when ctx is done.
+func PatchCtx(ctx context.Context, url string, payload interface{}) (*Response, error) {
 	s := Session{}
-	return s.Patch(url, payload)
+	return s.PatchCtx(ctx, url, payload)
 }
 
 // Delete sends a DELETE request.
 func Delete(url string, p *url.Values) (*Response, error) {
+	return DeleteCtx(context.Background(), url, p)
+}
+
+// DeleteCtx sends a DELETE request, canceled when ctx is done.
+func DeleteCtx(ctx context.Context, url string, p *url.Values) (*Response, error) {
 	s := Session{}
-	return s.Delete(url, p)
+	return s.DeleteCtx(ctx, url, p)
 }
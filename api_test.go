@@ -0,0 +1,94 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTopLevelCtxCancel(t *testing.T) {
+	started := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		close(started)
+		select {
+		case <-req.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	done := make(chan error, 1)
+	go func() {
+		_, err := PostCtx(ctx, srv.URL, map[string]string{"foo": "bar"})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected context.Canceled but got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Request was not canceled")
+	}
+}
+
+func TestTopLevelCtx(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleEmptyOK))
+	defer srv.Close()
+	resp, err := GetCtx(context.Background(), srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status() != 200 {
+		t.Fatalf("Expected status 200 but got %d", resp.Status())
+	}
+}
+
+func TestPostForm(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+			w.WriteHeader(415)
+			return
+		}
+		if err := req.ParseForm(); err != nil || req.PostForm.Get("name") == "" {
+			w.WriteHeader(400)
+			w.Write([]byte(`{"Foo":"name required"}`))
+			return
+		}
+		w.Write([]byte(`{"Foo":"` + req.PostForm.Get("name") + `/` + strings.Join(req.PostForm["tag"], ",") + `"}`))
+	}))
+	defer srv.Close()
+
+	var res, e payload
+	resp, err := PostForm(srv.URL, url.Values{"name": {"kirk & spock"}, "tag": {"a", "b"}}, &res, &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status() != 200 || res.Foo != "kirk & spock/a,b" {
+		t.Fatalf("Unexpected response %d %+v", resp.Status(), res)
+	}
+
+	resp, err = (&Session{}).PostForm(srv.URL, url.Values{}, &res, &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status() != 400 || e.Foo != "name required" {
+		t.Fatalf("Unexpected response %d %+v", resp.Status(), e)
+	}
+}
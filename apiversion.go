@@ -0,0 +1,148 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module supports APIs versioned by media type, such as
+application/vnd.myapi.v3+json, negotiated with the Accept header.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"regexp"
+	"strings"
+)
+
+// apiMediaType returns the API media type for r, if any.
+func (s *Session) apiMediaType(r *Request) string {
+	if r.bare {
+		return ""
+	}
+	if r.APIMediaType != "" {
+		return r.APIMediaType
+	}
+	return s.APIMediaType
+}
+
+// marshalsPayload reports whether the Payload of r is a value encoded by
+// napping, rather than raw bytes, a string or a reader sent as they are.
+func (r *Request) marshalsPayload() bool {
+	switch r.Payload.(type) {
+	case []byte, string, io.Reader:
+		return false
+	}
+	return true
+}
+
+// vendorVersion matches a version segment ending a vendor subtype, as in
+// vnd.myapi.v3 or vnd.myapi.v2.1.
+var vendorVersion = regexp.MustCompile(`(?:^|\.)v(\d+(?:\.\d+)*)$`)
+
+// APIVersion returns the API version of the response, taken from the
+// version parameter of its Content-Type, as in
+// "application/vnd.myapi+json; version=3", or else from a trailing version
+// segment of a vendor media type, as in "application/vnd.myapi.v3+json".
+// Both give "3".  It returns "" if the Content-Type names no version.
+func (r *Response) APIVersion() string {
+	if r.response == nil {
+		return ""
+	}
+	mt, params, err := mime.ParseMediaType(r.response.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	if v := params["version"]; v != "" {
+		return strings.TrimPrefix(v, "v")
+	}
+	sub := mt[strings.IndexByte(mt, '/')+1:]
+	if i := strings.IndexByte(sub, '+'); i >= 0 {
+		sub = sub[:i]
+	}
+	if m := vendorVersion.FindStringSubmatch(sub); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// A NotAcceptableError reports that the server answered 406 Not Acceptable
+// to a request for an API media type.  Supported lists the media types the
+// server offered instead, if it named any.
+type NotAcceptableError struct {
+	Requested string
+	Supported []string
+}
+
+func (e *NotAcceptableError) Error() string {
+	if len(e.Supported) == 0 {
+		return fmt.Sprintf("napping: server does not accept %s", e.Requested)
+	}
+	return fmt.Sprintf("napping: server does not accept %s; supported: %s",
+		e.Requested, strings.Join(e.Supported, ", "))
+}
+
+// notAcceptable returns the error for a 406 response to a request for
+// mediaType.
+func notAcceptable(mediaType string, resp *Response) *NotAcceptableError {
+	return &NotAcceptableError{
+		Requested: mediaType,
+		Supported: supportedTypes(resp),
+	}
+}
+
+// alternatesType matches the type attribute of an Alternates header
+// variant, as in {"v2" 1.0 {type application/vnd.myapi.v2+json}}.
+var alternatesType = regexp.MustCompile(`\{type\s+([^\s{}]+)\s*\}`)
+
+// supportedTypes returns the media types a 406 response says the server
+// supports, from its Accept-Post or Alternates header, or else from its
+// body: a JSON array of media types, a JSON object listing them under
+// "supported" or "types", or plain text with one per line.
+func supportedTypes(resp *Response) []string {
+	var types []string
+	h := resp.response.Header
+	for _, v := range h.Values("Accept-Post") {
+		for _, t := range strings.Split(v, ",") {
+			types = appendMediaType(types, t)
+		}
+	}
+	for _, v := range h.Values("Alternates") {
+		for _, m := range alternatesType.FindAllStringSubmatch(v, -1) {
+			types = appendMediaType(types, m[1])
+		}
+	}
+	if len(types) > 0 {
+		return types
+	}
+	body := resp.body
+	var list []string
+	if json.Unmarshal(body, &list) != nil {
+		var obj struct {
+			Supported []string `json:"supported"`
+			Types     []string `json:"types"`
+		}
+		if json.Unmarshal(body, &obj) == nil {
+			list = append(obj.Supported, obj.Types...)
+		} else if mt, _, _ := mime.ParseMediaType(resp.response.Header.Get("Content-Type")); mt == "text/plain" {
+			list = strings.Split(string(body), "\n")
+		}
+	}
+	for _, t := range list {
+		types = appendMediaType(types, t)
+	}
+	return types
+}
+
+// appendMediaType appends t to types if it is a media type.
+func appendMediaType(types []string, t string) []string {
+	t = strings.TrimSpace(t)
+	if mt, _, err := mime.ParseMediaType(t); err != nil || !strings.Contains(mt, "/") {
+		return types
+	}
+	return append(types, t)
+}
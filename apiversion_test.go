@@ -0,0 +1,124 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIMediaType(t *testing.T) {
+	const v3 = "application/vnd.myapi.v3+json"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Accept", req.Header.Get("Accept"))
+		w.Header().Set("X-Content-Type", req.Header.Get("Content-Type"))
+		w.Header().Set("Content-Type", req.Header.Get("Accept"))
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+
+	s := Session{APIMediaType: v3}
+	var res struct{ Ok bool }
+	resp, err := s.Send(&Request{Method: "POST", Url: srv.URL, Payload: map[string]int{"a": 1}, Result: &res})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, v3, resp.HttpResponse().Header.Get("X-Accept"))
+	assert.Equal(t, v3, resp.HttpResponse().Header.Get("X-Content-Type"))
+	assert.True(t, res.Ok)
+	assert.Equal(t, "3", resp.APIVersion())
+
+	// A per-request override, and explicit headers, win.
+	h := &http.Header{}
+	h.Set("Content-Type", "application/json")
+	resp, err = s.Send(&Request{Method: "POST", Url: srv.URL, Payload: []int{1}, Header: h,
+		APIMediaType: "application/vnd.myapi+json; version=2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "application/vnd.myapi+json; version=2", resp.HttpResponse().Header.Get("X-Accept"))
+	assert.Equal(t, "application/json", resp.HttpResponse().Header.Get("X-Content-Type"))
+	assert.Equal(t, "2", resp.APIVersion())
+
+	// Raw payloads are not relabelled.
+	for _, payload := range []interface{}{
+		strings.NewReader("\x00\x01binary"),
+		[]byte(`{"raw":true}`),
+		"plain text",
+	} {
+		resp, err = s.Send(&Request{Method: "PUT", Url: srv.URL, Payload: payload})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.NotEqual(t, v3, resp.HttpResponse().Header.Get("X-Content-Type"), "%T", payload)
+	}
+	resp, err = s.Send(&Request{Method: "PUT", Url: srv.URL, Payload: `{"a":1}`, NoAutoContentType: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "", resp.HttpResponse().Header.Get("X-Content-Type"))
+
+	// Without an API media type the defaults are unchanged.
+	resp, err = (&Session{}).Get(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "*/*", resp.HttpResponse().Header.Get("X-Accept"))
+	assert.Equal(t, "", resp.APIVersion())
+}
+
+func TestNotAcceptable(t *testing.T) {
+	const v3 = "application/vnd.myapi.v3+json"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/header":
+			w.Header().Set("Accept-Post", "application/vnd.myapi.v1+json, application/vnd.myapi.v2+json")
+		case "/alternates":
+			w.Header().Set("Alternates", `{"v2" 1.0 {type application/vnd.myapi.v2+json}}`)
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotAcceptable)
+			w.Write([]byte(`{"supported":["application/vnd.myapi.v2+json"]}`))
+			return
+		case "/text":
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusNotAcceptable)
+			w.Write([]byte("application/vnd.myapi.v1+json\napplication/vnd.myapi.v2+json\n"))
+			return
+		}
+		w.WriteHeader(http.StatusNotAcceptable)
+	}))
+	defer srv.Close()
+
+	s := Session{APIMediaType: v3}
+	for path, want := range map[string][]string{
+		"/header":     {"application/vnd.myapi.v1+json", "application/vnd.myapi.v2+json"},
+		"/alternates": {"application/vnd.myapi.v2+json"},
+		"/json":       {"application/vnd.myapi.v2+json"},
+		"/text":       {"application/vnd.myapi.v1+json", "application/vnd.myapi.v2+json"},
+		"/none":       nil,
+	} {
+		resp, err := s.Get(srv.URL+path, nil)
+		var nae *NotAcceptableError
+		if assert.True(t, errors.As(err, &nae), "%s: %v", path, err) {
+			assert.Equal(t, v3, nae.Requested, path)
+			assert.Equal(t, want, nae.Supported, path)
+		}
+		if assert.NotNil(t, resp, path) {
+			assert.Equal(t, 406, resp.Status(), path)
+		}
+	}
+
+	// A 406 is not an error without an API media type.
+	resp, err := (&Session{}).Get(srv.URL+"/header", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 406, resp.Status())
+}
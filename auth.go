@@ -0,0 +1,160 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module decides which of the configured credentials authorize a request.
+*/
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// An AuthSource identifies where the credentials of a request came from.
+// The sources are listed in order of precedence: when several are
+// configured, the first one wins, unless Session.StrictAuth is set.
+type AuthSource int
+
+const (
+	AuthNone            AuthSource = iota
+	AuthRequestHeader              // Authorization in Request.Header or Request.RawHeaders
+	AuthRequestUserinfo            // Request.Userinfo
+	AuthURLUserinfo                // Userinfo in the request URL
+	AuthSessionUserinfo            // Session.Userinfo
+	AuthTokenSource                // Session.TokenSource
+	AuthSessionHeader              // Authorization in Session.Header
+
+	// Session.Authorizer runs after the others and is reported as the
+	// source only if it changed the Authorization header.
+	AuthAuthorizer
+)
+
+var authSourceNames = [...]string{
+	AuthNone:            "none",
+	AuthRequestHeader:   "Request.Header",
+	AuthRequestUserinfo: "Request.Userinfo",
+	AuthURLUserinfo:     "URL userinfo",
+	AuthSessionUserinfo: "Session.Userinfo",
+	AuthTokenSource:     "Session.TokenSource",
+	AuthSessionHeader:   "Session.Header",
+	AuthAuthorizer:      "Session.Authorizer",
+}
+
+func (a AuthSource) String() string {
+	if a >= 0 && int(a) < len(authSourceNames) {
+		return authSourceNames[a]
+	}
+	return fmt.Sprintf("AuthSource(%d)", int(a))
+}
+
+// An AuthConflictError is returned when Session.StrictAuth is set and a
+// request has more than one source of credentials.
+type AuthConflictError struct {
+	Sources []AuthSource
+}
+
+func (e *AuthConflictError) Error() string {
+	names := make([]string, len(e.Sources))
+	for i, src := range e.Sources {
+		names[i] = src.String()
+	}
+	return "napping: conflicting credentials from " + strings.Join(names, ", ")
+}
+
+// authPlan is the outcome of resolving the credentials of a request.
+type authPlan struct {
+	source     AuthSource
+	candidates []AuthSource // All configured sources, in precedence order
+	userinfo   *url.Userinfo
+}
+
+// resolveAuth lists the credentials configured for r, whose URL is u, and
+// picks the one with the highest precedence.
+func (s *Session) resolveAuth(r *Request, u *url.URL) (plan authPlan, err error) {
+	add := func(src AuthSource, ok bool) {
+		if ok {
+			plan.candidates = append(plan.candidates, src)
+		}
+	}
+	rawAuth := false
+	for k := range r.RawHeaders {
+		rawAuth = rawAuth || strings.EqualFold(k, "Authorization")
+	}
+	add(AuthRequestHeader, rawAuth || r.Header != nil && r.Header.Get("Authorization") != "")
+	add(AuthRequestUserinfo, r.Userinfo != nil)
+	add(AuthURLUserinfo, u != nil && u.User != nil)
+	add(AuthSessionUserinfo, s.Userinfo != nil)
+	add(AuthTokenSource, s.TokenSource != nil)
+	add(AuthSessionHeader, s.Header != nil && !r.NoSessionHeaders && s.Header.Get("Authorization") != "")
+	add(AuthAuthorizer, s.Authorizer != nil)
+
+	if s.StrictAuth && len(plan.candidates) > 1 {
+		return plan, &AuthConflictError{Sources: plan.candidates}
+	}
+	if len(plan.candidates) == 0 {
+		return
+	}
+	plan.source = plan.candidates[0]
+	switch plan.source {
+	case AuthRequestUserinfo:
+		plan.userinfo = r.Userinfo
+	case AuthURLUserinfo:
+		plan.userinfo = u.User
+	case AuthSessionUserinfo:
+		plan.userinfo = s.Userinfo
+	case AuthAuthorizer:
+		plan.source = AuthNone // Until the Authorizer sets a header
+	}
+	return
+}
+
+// explain describes the plan in words.
+func (p authPlan) explain() string {
+	if len(p.candidates) == 0 {
+		return "no credentials configured"
+	}
+	var b strings.Builder
+	if p.source == AuthNone {
+		b.WriteString("no credentials")
+	} else {
+		fmt.Fprintf(&b, "using %s", p.source)
+	}
+	var ignored []string
+	for _, src := range p.candidates {
+		if src != p.source && src != AuthAuthorizer {
+			ignored = append(ignored, src.String())
+		}
+	}
+	if len(ignored) > 0 {
+		fmt.Fprintf(&b, "; ignoring %s", strings.Join(ignored, ", "))
+	}
+	for _, src := range p.candidates {
+		if src == AuthAuthorizer {
+			b.WriteString("; Session.Authorizer may replace the Authorization header")
+		}
+	}
+	return b.String()
+}
+
+// ExplainAuth describes which credentials authorize the request and which
+// were ignored, e.g. "using Request.Userinfo; ignoring Session.TokenSource".
+// After Send the explanation covers the session's credentials too; before,
+// it covers only those of the request itself.
+func (r *Request) ExplainAuth() string {
+	if r.auth.candidates != nil {
+		return r.auth.explain()
+	}
+	u, _ := url.Parse(r.Url)
+	plan, _ := (&Session{}).resolveAuth(r, u)
+	return plan.explain()
+}
+
+// AuthSource returns where the credentials sent with the request came from.
+func (r *Response) AuthSource() AuthSource {
+	return r.auth.source
+}
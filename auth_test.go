@@ -0,0 +1,115 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthPrecedence(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		auth := req.Header.Get("Authorization")
+		if strings.HasPrefix(auth, "Basic ") {
+			b, _ := base64.StdEncoding.DecodeString(auth[len("Basic "):])
+			auth = "Basic " + string(b)
+		}
+		w.Write([]byte(auth))
+	}))
+	defer srv.Close()
+	withUser := strings.Replace(srv.URL, "http://", "http://url:pw@", 1)
+	sessionHeader := http.Header{"Authorization": {"Session header"}}
+	requestHeader := http.Header{"Authorization": {"Request header"}}
+	full := Session{
+		Userinfo:    url.UserPassword("session", "pw"),
+		TokenSource: &fakeTokenSource{tokens: []string{"token"}},
+		Header:      &sessionHeader,
+	}
+
+	cases := []struct {
+		s      Session
+		r      Request
+		source AuthSource
+		sent   string
+	}{
+		{Session{}, Request{Url: srv.URL}, AuthNone, ""},
+		{full, Request{Url: withUser, Header: &requestHeader, Userinfo: url.UserPassword("request", "pw")}, AuthRequestHeader, "Request header"},
+		{full, Request{Url: withUser, RawHeaders: map[string][]string{"authorization": {"raw"}}}, AuthRequestHeader, "raw"},
+		{full, Request{Url: withUser, Userinfo: url.UserPassword("request", "pw")}, AuthRequestUserinfo, "Basic request:pw"},
+		{full, Request{Url: withUser}, AuthURLUserinfo, "Basic url:pw"},
+		{full, Request{Url: srv.URL}, AuthSessionUserinfo, "Basic session:pw"},
+		{Session{TokenSource: full.TokenSource, Header: &sessionHeader}, Request{Url: srv.URL}, AuthTokenSource, "Bearer token"},
+		{Session{Header: &sessionHeader}, Request{Url: srv.URL}, AuthSessionHeader, "Session header"},
+		{Session{Header: &sessionHeader}, Request{Url: srv.URL, NoSessionHeaders: true}, AuthNone, ""},
+	}
+	for i, c := range cases {
+		resp, err := c.s.Send(&c.r)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		assert.Equal(t, c.source, resp.AuthSource(), "case %d", i)
+		assert.Equal(t, c.sent, resp.RawText(), "case %d", i)
+	}
+}
+
+func TestExplainAuth(t *testing.T) {
+	r := Request{Url: "http://url:pw@example.com/", Userinfo: url.UserPassword("request", "pw")}
+	assert.Equal(t, "using Request.Userinfo; ignoring URL userinfo", r.ExplainAuth())
+	assert.Equal(t, "no credentials configured", (&Request{Url: "http://example.com/"}).ExplainAuth())
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer srv.Close()
+	s := Session{
+		TokenSource: &fakeTokenSource{tokens: []string{"token"}},
+		Authorizer:  func(req *http.Request) error { return nil },
+	}
+	r = Request{Url: srv.URL, Userinfo: url.UserPassword("request", "pw")}
+	resp, err := s.Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, AuthRequestUserinfo, resp.AuthSource())
+	assert.Equal(t, "using Request.Userinfo; ignoring Session.TokenSource; Session.Authorizer may replace the Authorization header", r.ExplainAuth())
+
+	s.Authorizer = func(req *http.Request) error {
+		req.Header.Set("Authorization", "HMAC x")
+		return nil
+	}
+	resp, err = s.Send(&Request{Url: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, AuthAuthorizer, resp.AuthSource())
+	assert.Equal(t, "Session.Authorizer", resp.AuthSource().String())
+}
+
+func TestStrictAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer srv.Close()
+	s := Session{
+		StrictAuth:  true,
+		TokenSource: &fakeTokenSource{tokens: []string{"token"}},
+	}
+	resp, err := s.Get(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, AuthTokenSource, resp.AuthSource())
+
+	_, err = s.Send(&Request{Url: srv.URL, Userinfo: url.UserPassword("old", "pw")})
+	assert.EqualError(t, err, "napping: conflicting credentials from Request.Userinfo, Session.TokenSource")
+	var ce *AuthConflictError
+	if assert.True(t, errors.As(err, &ce)) {
+		assert.Equal(t, []AuthSource{AuthRequestUserinfo, AuthTokenSource}, ce.Sources)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module calls declaratively defined endpoints, expanding their route
+templates and decoding into their result types, as a light client definition
+layer without code generation.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Meta keys set by Session.Call, e.g. to label metrics in AfterResponse.
+const (
+	MetaEndpoint = "napping.endpoint" // Endpoint.Name, or "METHOD Path"
+	MetaRoute    = "napping.route"    // Endpoint.Path, the unexpanded template
+)
+
+// An Endpoint describes an API operation once, for Session.Call and
+// CallTyped.
+type Endpoint struct {
+	Name   string // Optional; defaults to "METHOD Path"
+	Method string // Defaults to GET
+	Path   string // Route template joined below the BaseURL path, e.g. "/users/{id}"
+
+	// Optional types of the values successful and error responses are
+	// decoded into; a new value is allocated for each call.
+	Result reflect.Type
+	Error  reflect.Type
+}
+
+// name returns ep.Name, or "METHOD Path".
+func (ep Endpoint) name() string {
+	if ep.Name != "" {
+		return ep.Name
+	}
+	method := ep.Method
+	if method == "" {
+		method = "GET"
+	}
+	return method + " " + ep.Path
+}
+
+// CallArgs are the arguments of one call of an Endpoint.
+type CallArgs struct {
+	PathParams map[string]string // Values of the {name} placeholders of Path
+	Query      *url.Values
+	Payload    interface{}
+	Header     *http.Header
+	Meta       map[string]interface{}
+}
+
+// Call sends a request to ep, with the placeholders of its Path replaced by
+// args.PathParams, which must provide all of them and no others.  Result and
+// Error of the Response point to new values of ep.Result and ep.Error.
+// Meta carries MetaEndpoint and MetaRoute.
+func (s *Session) Call(ep Endpoint, args CallArgs) (*Response, error) {
+	return s.CallCtx(context.Background(), ep, args)
+}
+
+// CallCtx is Call with a context.
+func (s *Session) CallCtx(ctx context.Context, ep Endpoint, args CallArgs) (*Response, error) {
+	path, err := expandRoute(ep.Path, args.PathParams)
+	if err != nil {
+		return nil, fmt.Errorf("napping: endpoint %s: %w", ep.name(), err)
+	}
+	r := Request{
+		Method:  ep.Method,
+		Url:     strings.TrimLeft(path, "/"), // Below the BaseURL path
+		Params:  args.Query,
+		Payload: args.Payload,
+		Header:  args.Header,
+		Meta:    copyMeta(args.Meta),
+	}
+	if r.Method == "" {
+		r.Method = "GET"
+	}
+	if ep.Result != nil {
+		r.Result = reflect.New(ep.Result).Interface()
+	}
+	if ep.Error != nil {
+		r.Error = reflect.New(ep.Error).Interface()
+	}
+	r.Meta[MetaEndpoint] = ep.name()
+	r.Meta[MetaRoute] = ep.Path
+	return s.SendWithContext(ctx, &r)
+}
+
+// CallTyped calls ep as by Session.Call, decoding a successful response
+// into a new T, which is returned along with the response.  ep.Result, if
+// set, must be T.
+func CallTyped[T any](s *Session, ep Endpoint, args CallArgs) (*T, *Response, error) {
+	return CallTypedCtx[T](context.Background(), s, ep, args)
+}
+
+// CallTypedCtx is CallTyped with a context.
+func CallTypedCtx[T any](ctx context.Context, s *Session, ep Endpoint, args CallArgs) (*T, *Response, error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if ep.Result == nil {
+		ep.Result = typ
+	} else if ep.Result != typ {
+		return nil, nil, fmt.Errorf("napping: endpoint %s: result type is %v, not %v", ep.name(), ep.Result, typ)
+	}
+	resp, err := s.CallCtx(ctx, ep, args)
+	if resp == nil {
+		return nil, nil, err
+	}
+	return resp.Result.(*T), resp, err
+}
+
+// expandRoute replaces the {name} placeholders of tmpl with the escaped
+// values of params.  It fails if a placeholder has no value, or a value no
+// placeholder.
+func expandRoute(tmpl string, params map[string]string) (string, error) {
+	var b strings.Builder
+	used := make(map[string]bool, len(params))
+	for rest := tmpl; ; {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			b.WriteString(rest)
+			break
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated placeholder in %q", tmpl)
+		}
+		name := rest[open+1 : open+end]
+		v, ok := params[name]
+		if !ok || v == "" {
+			return "", fmt.Errorf("missing path parameter %q", name)
+		}
+		used[name] = true
+		b.WriteString(rest[:open])
+		b.WriteString(url.PathEscape(v))
+		rest = rest[open+end+1:]
+	}
+	var unknown []string
+	for name := range params {
+		if !used[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return "", fmt.Errorf("unknown path parameters %q", unknown)
+	}
+	return b.String(), nil
+}
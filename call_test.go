@@ -0,0 +1,96 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type callUser struct {
+	ID   string
+	Name string
+}
+
+type callError struct {
+	Message string
+}
+
+func TestCall(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if req.URL.EscapedPath() != "/v1/users/a%2Fb" {
+			w.WriteHeader(404)
+			json.NewEncoder(w).Encode(callError{"no such user"})
+			return
+		}
+		json.NewEncoder(w).Encode(callUser{ID: "a/b", Name: req.URL.Query().Get("name")})
+	}))
+	defer srv.Close()
+	var routes []interface{}
+	s := Session{
+		BaseURL: srv.URL + "/v1",
+		AfterResponse: func(r *Request, resp *Response) error {
+			routes = append(routes, r.Meta[MetaRoute], r.Meta[MetaEndpoint])
+			return nil
+		},
+	}
+	getUser := Endpoint{
+		Name:   "getUser",
+		Path:   "/users/{id}",
+		Result: reflect.TypeOf(callUser{}),
+		Error:  reflect.TypeOf(callError{}),
+	}
+
+	resp, err := s.Call(getUser, CallArgs{
+		PathParams: map[string]string{"id": "a/b"},
+		Query:      &url.Values{"name": {"kirk"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, &callUser{"a/b", "kirk"}, resp.Result)
+	assert.Equal(t, []interface{}{"/users/{id}", "getUser"}, routes)
+
+	resp, err = s.Call(getUser, CallArgs{PathParams: map[string]string{"id": "nobody"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 404, resp.Status())
+	assert.Equal(t, &callError{"no such user"}, resp.Error)
+
+	_, err = s.Call(getUser, CallArgs{})
+	assert.EqualError(t, err, `napping: endpoint getUser: missing path parameter "id"`)
+	_, err = s.Call(getUser, CallArgs{PathParams: map[string]string{"id": "1", "uid": "2"}})
+	assert.EqualError(t, err, `napping: endpoint getUser: unknown path parameters ["uid"]`)
+}
+
+func TestCallTyped(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(callUser{ID: req.URL.Path, Name: req.Method})
+	}))
+	defer srv.Close()
+	s := Session{BaseURL: srv.URL}
+	ep := Endpoint{Method: "PUT", Path: "/users/{id}"}
+	user, resp, err := CallTyped[callUser](&s, ep, CallArgs{
+		PathParams: map[string]string{"id": "7"},
+		Payload:    callUser{Name: "spock"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, &callUser{"/users/7", "PUT"}, user)
+	assert.Equal(t, "PUT /users/{id}", resp.Meta[MetaEndpoint])
+
+	ep.Result = reflect.TypeOf(callError{})
+	_, _, err = CallTyped[callUser](&s, ep, CallArgs{PathParams: map[string]string{"id": "7"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module abstracts the passing of time, so that tests can control it.
+*/
+
+import (
+	"context"
+	"time"
+)
+
+// A Clock tells the time and waits.  A Session reads and waits on its Clock
+// for timestamps, Timings, retry backoff, Retry-After, endpoint cooldown
+// and re-probing, and DNS cache expiry.  Deadlines on network I/O, which
+// net/http and context enforce, such as Request.BodyTimeout and
+// RetryPolicy.PerAttemptTimeout, stay on the real clock.
+// nappingtest.FakeClock is a Clock for tests.
+type Clock interface {
+	Now() time.Time
+
+	// Sleep waits for d to pass, or until ctx is done, in which case it
+	// returns ctx.Err().
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// realClock is the Clock of the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetClock makes the session use c instead of the real clock; nil restores
+// the real clock.  Call it before the first request.
+func (s *Session) SetClock(c Clock) {
+	s.clk = c
+}
+
+// clock returns the session's Clock.
+func (s *Session) clock() Clock {
+	if s.clk == nil {
+		return realClock{}
+	}
+	return s.clk
+}
+
+// now returns the current time of the session's Clock.
+func (s *Session) now() time.Time {
+	return s.clock().Now()
+}
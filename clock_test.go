@@ -0,0 +1,61 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stubClock is a Clock which stands still until advanced, for the tests of
+// this package, which cannot import nappingtest.FakeClock.  Sleep advances
+// it by the duration slept, at once.
+type stubClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newStubClock() *stubClock {
+	return &stubClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *stubClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *stubClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.Advance(d)
+	return nil
+}
+
+// Advance moves the clock forward by d.
+func (c *stubClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d > 0 {
+		c.now = c.now.Add(d)
+	}
+}
+
+func TestSetClock(t *testing.T) {
+	c := newStubClock()
+	s := Session{}
+	s.SetClock(c)
+	start := s.now()
+	assert.NoError(t, s.clock().Sleep(context.Background(), time.Hour))
+	assert.Equal(t, start.Add(time.Hour), s.now())
+	s.SetClock(nil)
+	assert.WithinDuration(t, time.Now(), s.now(), time.Minute)
+}
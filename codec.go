@@ -0,0 +1,97 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module is a registry of codecs for content types napping does not
+handle itself, such as msgpack or CBOR, so that they can be plugged in
+without napping depending on them.
+*/
+
+import (
+	"mime"
+	"strings"
+	"sync"
+)
+
+// EncoderFunc encodes a request payload.
+type EncoderFunc func(v interface{}) ([]byte, error)
+
+// DecoderFunc decodes a response body into v.
+type DecoderFunc func(data []byte, v interface{}) error
+
+var codecs struct {
+	sync.RWMutex
+	encoders map[string]EncoderFunc
+	decoders map[string]DecoderFunc
+}
+
+// codecKey returns the media type of contentType, without parameters, as
+// registry key.
+func codecKey(contentType string) string {
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mt
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// RegisterEncoder registers fn to encode payloads sent with the given
+// Content-Type, set in the request or session header.  Strings, byte slices
+// and readers are still sent as they are.  Parameters of contentType are
+// ignored, and a nil fn removes the encoder.
+//
+//	napping.RegisterEncoder("application/msgpack", func(v interface{}) ([]byte, error) {
+//		return msgpack.Marshal(v)
+//	})
+func RegisterEncoder(contentType string, fn EncoderFunc) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	if fn == nil {
+		delete(codecs.encoders, codecKey(contentType))
+		return
+	}
+	if codecs.encoders == nil {
+		codecs.encoders = make(map[string]EncoderFunc)
+	}
+	codecs.encoders[codecKey(contentType)] = fn
+}
+
+// RegisterDecoder registers fn to decode response bodies of the given
+// Content-Type into Result and Error, in place of the built-in JSON, XML and
+// protobuf decoding.  Parameters of contentType are ignored, and a nil fn
+// removes the decoder.
+func RegisterDecoder(contentType string, fn DecoderFunc) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	if fn == nil {
+		delete(codecs.decoders, codecKey(contentType))
+		return
+	}
+	if codecs.decoders == nil {
+		codecs.decoders = make(map[string]DecoderFunc)
+	}
+	codecs.decoders[codecKey(contentType)] = fn
+}
+
+// lookupEncoder returns the encoder registered for contentType, or nil.
+func lookupEncoder(contentType string) EncoderFunc {
+	if contentType == "" {
+		return nil
+	}
+	codecs.RLock()
+	defer codecs.RUnlock()
+	return codecs.encoders[codecKey(contentType)]
+}
+
+// lookupDecoder returns the decoder registered for contentType, or nil.
+func lookupDecoder(contentType string) DecoderFunc {
+	if contentType == "" {
+		return nil
+	}
+	codecs.RLock()
+	defer codecs.RUnlock()
+	return codecs.decoders[codecKey(contentType)]
+}
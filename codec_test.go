@@ -0,0 +1,96 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// kv is a toy codec encoding a map[string]string as "key=value" lines.
+const kvType = "application/x-kv"
+
+func encodeKV(v interface{}) ([]byte, error) {
+	m, ok := v.(map[string]string)
+	if !ok {
+		return nil, errors.New("kv: not a map")
+	}
+	var b strings.Builder
+	for k, val := range m {
+		b.WriteString(k + "=" + val + "\n")
+	}
+	return []byte(b.String()), nil
+}
+
+func decodeKV(data []byte, v interface{}) error {
+	m, ok := v.(*map[string]string)
+	if !ok {
+		return errors.New("kv: not a map pointer")
+	}
+	*m = make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) == 2 {
+			(*m)[kv[0]] = kv[1]
+		}
+	}
+	return nil
+}
+
+func TestCodecRegistry(t *testing.T) {
+	RegisterEncoder(kvType, encodeKV)
+	RegisterDecoder(kvType+"; charset=utf-8", decodeKV)
+	defer RegisterEncoder(kvType, nil)
+	defer RegisterDecoder(kvType, nil)
+
+	var gotType, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		gotType, gotBody = req.Header.Get("Content-Type"), string(b)
+		w.Header().Set("Content-Type", kvType+"; charset=utf-8")
+		w.Write([]byte("answer=42\n"))
+	}))
+	defer srv.Close()
+
+	s := Session{}
+	h := http.Header{}
+	h.Set("Content-Type", kvType)
+	var result map[string]string
+	_, err := s.Send(&Request{
+		Url:          srv.URL,
+		Method:       "POST",
+		Header:       &h,
+		Payload:      map[string]string{"question": "life"},
+		Result:       &result,
+		StreamResult: true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, kvType, gotType)
+	assert.Equal(t, "question=life\n", gotBody)
+	assert.Equal(t, map[string]string{"answer": "42"}, result)
+
+	// Without the header the payload is JSON, and raw payloads are
+	// never encoded.
+	_, err = s.Send(&Request{Url: srv.URL, Method: "POST", Payload: map[string]string{"a": "b"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "application/json", gotType)
+	_, err = s.Send(&Request{Url: srv.URL, Method: "POST", Header: &h, Payload: "raw=1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "raw=1", gotBody)
+
+	_, err = s.Send(&Request{Url: srv.URL, Method: "POST", Header: &h, Payload: []int{1}})
+	assert.EqualError(t, err, "kv: not a map")
+
+	RegisterDecoder(kvType, nil)
+	_, err = s.Send(&Request{Url: srv.URL, Method: "GET", Result: &result})
+	assert.Error(t, err)
+}
@@ -0,0 +1,322 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module decodes CSV and TSV response bodies into slices of structs,
+mapping columns to fields by their csv tags.
+*/
+
+import (
+	"encoding"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSVOptions control how CSV is decoded.
+//
+// Columns are matched to struct fields by the field's csv tag, or by its
+// name if untagged, ignoring case; a tag of "-" skips the field, and
+// columns without a field are ignored.  Fields may be strings, integers,
+// floats, bools, time.Time, pointers to these, which are left nil for empty
+// cells, or implement encoding.TextUnmarshaler.  Empty cells leave other
+// fields zero.  A layout tag gives the layout of a time.Time field:
+//
+//	type Sale struct {
+//		Day    time.Time `csv:"day" layout:"2006-01-02"`
+//		Amount float64   `csv:"amount"`
+//	}
+type CSVOptions struct {
+	Comma      rune     // Field delimiter, e.g. '\t' or ';'; ',' if zero
+	Columns    []string // Column names, if the body has no header row
+	SkipHeader bool     // Skip the first row even though Columns is set
+	TimeLayout string   // Default layout of times; time.RFC3339 if empty
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// UnmarshalCSV decodes the CSV response body into v, which must be a
+// pointer to a slice of structs, of pointers to structs, or of []string.
+// A *[][]string receives all rows as they are, including any header row.
+func (r *Response) UnmarshalCSV(v interface{}, opts CSVOptions) error {
+	src := (*Request)(r).bodyReader()
+	if c, ok := src.(io.Closer); ok {
+		defer c.Close()
+	}
+	return decodeCSV(src, v, opts)
+}
+
+// EachCSV decodes the records of the CSV response body one at a time into
+// v, a pointer to a struct, calling fn after each, so that large exports are
+// decoded in constant memory.  It stops at the first error from fn.  With
+// NotProcessBody the live body is read as it arrives, and closed afterwards.
+func (r *Response) EachCSV(v interface{}, opts CSVOptions, fn func() error) error {
+	src := (*Request)(r).bodyReader()
+	if c, ok := src.(io.Closer); ok {
+		defer c.Close()
+	}
+	if r.NotProcessBody && r.response != nil {
+		defer r.response.Body.Close()
+		src = r.response.Body
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("napping: EachCSV needs a pointer to a struct, not %T", v)
+	}
+	d, err := newCSVDecoder(src, rv.Elem().Type(), opts)
+	if err != nil || d == nil {
+		return err
+	}
+	d.cr.ReuseRecord = true
+	for {
+		rec, err := d.cr.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("napping: decoding CSV: %w", err)
+		}
+		rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+		if err := d.fill(rv.Elem(), rec); err != nil {
+			return err
+		}
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+}
+
+// csvMime returns the delimiter of contentType if it is CSV or TSV.
+func csvMime(contentType string) (rune, bool) {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch mt {
+	case "text/csv", "application/csv":
+		return ',', true
+	case "text/tab-separated-values":
+		return '\t', true
+	}
+	return 0, false
+}
+
+// isCSVMime reports whether contentType is CSV or TSV.
+func isCSVMime(contentType string) bool {
+	_, ok := csvMime(contentType)
+	return ok
+}
+
+// isCSVTarget reports whether v can receive CSV rows, i.e. is a pointer to
+// a slice other than []byte.
+func isCSVTarget(v interface{}) bool {
+	t := reflect.TypeOf(v)
+	return t != nil && t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Slice &&
+		t.Elem().Elem().Kind() != reflect.Uint8
+}
+
+// decodeCSV decodes all records read from rd into v; see UnmarshalCSV.
+func decodeCSV(rd io.Reader, v interface{}, opts CSVOptions) error {
+	if !isCSVTarget(v) || reflect.ValueOf(v).IsNil() {
+		return fmt.Errorf("napping: CSV needs a pointer to a slice, not %T", v)
+	}
+	slice := reflect.ValueOf(v).Elem()
+	et := slice.Type().Elem()
+	if et == reflect.TypeOf([]string(nil)) {
+		cr := csv.NewReader(rd)
+		cr.Comma = csvComma(opts)
+		cr.FieldsPerRecord = -1
+		rows, err := cr.ReadAll()
+		if err != nil {
+			return fmt.Errorf("napping: decoding CSV: %w", err)
+		}
+		if len(rows) > 0 && len(rows[0]) > 0 {
+			rows[0][0] = strings.TrimPrefix(rows[0][0], string(utf8BOM))
+		}
+		slice.Set(reflect.ValueOf(rows).Convert(slice.Type()))
+		return nil
+	}
+	st := et
+	if st.Kind() == reflect.Ptr {
+		st = st.Elem()
+	}
+	if st.Kind() != reflect.Struct {
+		return fmt.Errorf("napping: CSV needs a slice of structs or of []string, not %T", v)
+	}
+	out := reflect.MakeSlice(slice.Type(), 0, 0)
+	d, err := newCSVDecoder(rd, st, opts)
+	if err != nil {
+		return err
+	}
+	for d != nil {
+		rec, err := d.cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("napping: decoding CSV: %w", err)
+		}
+		elem := reflect.New(st)
+		if err := d.fill(elem.Elem(), rec); err != nil {
+			return err
+		}
+		if et.Kind() == reflect.Ptr {
+			out = reflect.Append(out, elem)
+		} else {
+			out = reflect.Append(out, elem.Elem())
+		}
+	}
+	slice.Set(out)
+	return nil
+}
+
+func csvComma(opts CSVOptions) rune {
+	if opts.Comma == 0 {
+		return ','
+	}
+	return opts.Comma
+}
+
+// csvField is the struct field a column is decoded into.
+type csvField struct {
+	index  int // -1 if the column is ignored
+	layout string
+}
+
+// csvDecoder decodes CSV records into structs of one type.
+type csvDecoder struct {
+	cr      *csv.Reader
+	columns []string
+	fields  []csvField
+}
+
+// newCSVDecoder reads the header row from rd, if any, and maps the columns
+// to the fields of struct type t.  It returns nil if rd is empty.
+func newCSVDecoder(rd io.Reader, t reflect.Type, opts CSVOptions) (*csvDecoder, error) {
+	cr := csv.NewReader(rd)
+	cr.Comma = csvComma(opts)
+	columns := opts.Columns
+	if columns == nil || opts.SkipHeader {
+		header, err := cr.Read()
+		if err == io.EOF {
+			return nil, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("napping: decoding CSV header: %w", err)
+		}
+		if columns == nil {
+			columns = append([]string(nil), header...)
+			columns[0] = strings.TrimPrefix(columns[0], string(utf8BOM))
+		}
+	}
+	byName := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Tag.Get("csv")
+		if f.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		byName[strings.ToLower(name)] = i
+	}
+	layout := opts.TimeLayout
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	d := &csvDecoder{cr: cr, columns: columns, fields: make([]csvField, len(columns))}
+	for j, col := range columns {
+		i, ok := byName[strings.ToLower(strings.TrimSpace(col))]
+		if !ok {
+			d.fields[j].index = -1
+			continue
+		}
+		d.fields[j] = csvField{index: i, layout: layout}
+		if l := t.Field(i).Tag.Get("layout"); l != "" {
+			d.fields[j].layout = l
+		}
+	}
+	return d, nil
+}
+
+// fill sets the fields of struct sv from record rec.
+func (d *csvDecoder) fill(sv reflect.Value, rec []string) error {
+	for j, s := range rec {
+		if j >= len(d.fields) || d.fields[j].index < 0 {
+			continue
+		}
+		if err := setCSVField(sv.Field(d.fields[j].index), s, d.fields[j].layout); err != nil {
+			line, _ := d.cr.FieldPos(j)
+			return fmt.Errorf("napping: CSV line %d, column %q: %w", line, d.columns[j], err)
+		}
+	}
+	return nil
+}
+
+// setCSVField sets fv from cell s.
+func setCSVField(fv reflect.Value, s, layout string) error {
+	if fv.Kind() == reflect.Ptr {
+		if s == "" {
+			fv.Set(reflect.Zero(fv.Type()))
+			return nil
+		}
+		fv.Set(reflect.New(fv.Type().Elem()))
+		fv = fv.Elem()
+	}
+	if fv.Type() == reflect.TypeOf(time.Time{}) {
+		if s == "" {
+			return nil
+		}
+		t, err := time.Parse(layout, strings.TrimSpace(s))
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if reflect.PtrTo(fv.Type()).Implements(textUnmarshalerType) {
+		return fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	if fv.Kind() == reflect.String {
+		fv.SetString(s)
+		return nil
+	}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	default:
+		return errors.New("unsupported field type " + fv.Type().String())
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type sale struct {
+	Day    time.Time `csv:"day" layout:"2006-01-02"`
+	Region string    `csv:"region"`
+	Units  int       `csv:"units"`
+	Amount float64   `csv:"amount"`
+	Paid   bool
+	Note   *string `csv:"note"`
+	Secret string  `csv:"-"`
+}
+
+const salesCSV = "\xEF\xBB\xBFday,region,units,amount,paid,note,secret\n" +
+	"2024-01-02,\"North, East\",3,9.5,true,,x\n" +
+	"2024-01-03,South,,1e2,false,\"said \"\"hi\"\"\",y\n"
+
+func TestUnmarshalCSV(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/csv":
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Write([]byte(salesCSV))
+		case "/tsv":
+			w.Header().Set("Content-Type", "text/tab-separated-values")
+			w.Write([]byte("a\tb\n1\t2\n"))
+		case "/bad":
+			w.Header().Set("Content-Type", "text/csv")
+			w.Write([]byte("day,units\n2024-01-02,many\n"))
+		}
+	}))
+	defer srv.Close()
+
+	s := Session{}
+	var sales []sale
+	resp, err := s.Send(&Request{Url: srv.URL + "/csv", Method: "GET", Result: &sales, StreamResult: true})
+	assert.NoError(t, err)
+	assert.Len(t, sales, 2)
+	assert.Equal(t, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), sales[0].Day)
+	assert.Equal(t, "North, East", sales[0].Region)
+	assert.Equal(t, 3, sales[0].Units)
+	assert.Equal(t, 9.5, sales[0].Amount)
+	assert.True(t, sales[0].Paid)
+	assert.Nil(t, sales[0].Note)
+	assert.Equal(t, "", sales[0].Secret)
+	assert.Equal(t, 0, sales[1].Units)
+	assert.Equal(t, 100.0, sales[1].Amount)
+	assert.Equal(t, `said "hi"`, *sales[1].Note)
+
+	var ptrs []*sale
+	assert.NoError(t, resp.UnmarshalCSV(&ptrs, CSVOptions{}))
+	assert.Equal(t, "South", ptrs[1].Region)
+
+	// Explicit columns, skipping the header row.
+	var units []struct{ A, B string }
+	assert.NoError(t, resp.UnmarshalCSV(&units, CSVOptions{Columns: []string{"b", "a"}, SkipHeader: true}))
+	assert.Equal(t, "2024-01-02", units[0].B)
+	assert.Equal(t, "North, East", units[0].A)
+
+	var n int
+	var one sale
+	assert.NoError(t, resp.EachCSV(&one, CSVOptions{}, func() error {
+		n += one.Units
+		return nil
+	}))
+	assert.Equal(t, 3, n)
+
+	var rows [][]string
+	_, err = s.Send(&Request{Url: srv.URL + "/tsv", Method: "GET", Result: &rows})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"a", "b"}, {"1", "2"}}, rows)
+
+	resp, err = s.Get(srv.URL+"/bad", nil)
+	assert.NoError(t, err)
+	err = resp.UnmarshalCSV(&sales, CSVOptions{})
+	assert.EqualError(t, err, `napping: CSV line 2, column "units": strconv.ParseInt: parsing "many": invalid syntax`)
+	assert.Error(t, resp.UnmarshalCSV(&one, CSVOptions{}))
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module writes the debug log of requests and responses enabled by
+Session.Log.  Bodies which are not text, or are still compressed, are
+summarized by size and hash instead of being dumped as garbage.
+*/
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// A Logger receives a Session's log output.  *log.Logger implements it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// MaxDebugBody is the number of body bytes written to the debug log.
+var MaxDebugBody = 4096
+
+const debugRule = "--------------------------------------------------------------------------------"
+
+// debugRedacted lists headers whose values are never logged.
+var debugRedacted = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// dumpRequest logs the prepared request.
+func (s *Session) dumpRequest(r *Request, req *http.Request) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\nREQUEST\n%s\n", debugRule, debugRule)
+	fmt.Fprintf(&b, "%s %s\n", req.Method, req.URL.Redacted())
+	if s.Params != nil && r.NoSessionParams {
+		b.WriteString("(session params not merged: NoSessionParams)\n")
+	}
+	if s.Header != nil && r.NoSessionHeaders {
+		b.WriteString("(session headers not merged: NoSessionHeaders)\n")
+	}
+	fmt.Fprintf(&b, "(auth: %s)\n", r.auth.explain())
+	writeDebugHeader(&b, req.Header)
+	for _, k := range r.policyRemoved {
+		fmt.Fprintf(&b, "%s: [removed by HeaderPolicy]\n", k)
+	}
+	switch {
+	case req.Body == nil || req.Body == http.NoBody:
+	case req.GetBody == nil:
+		b.WriteString("\n[streamed body, not logged]\n")
+	default:
+		body, err := req.GetBody()
+		if err != nil {
+			fmt.Fprintf(&b, "\n[body not available: %v]\n", err)
+			break
+		}
+		data, _ := ioutil.ReadAll(body)
+		body.Close()
+		b.WriteString("\n" + formatDebugBody(req.Header, data) + "\n")
+	}
+	s.log(b.String())
+}
+
+// dumpResponse logs the response.  Bodies which were not buffered, because
+// of NotProcessBody or StreamResult, are not logged.
+func (s *Session) dumpResponse(r *Request, resp *http.Response) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\nRESPONSE\n%s\n", debugRule, debugRule)
+	fmt.Fprintf(&b, "%s %s\n", resp.Proto, resp.Status)
+	writeDebugHeader(&b, resp.Header)
+	switch {
+	case r.body != nil:
+		b.WriteString("\n" + formatDebugBody(resp.Header, r.body) + "\n")
+	case r.spill != nil:
+		fmt.Fprintf(&b, "\n[body spilled to disk: %d bytes, not logged]\n", r.spill.size)
+	case r.NotProcessBody || r.StreamResult:
+		b.WriteString("\n[body not buffered, not logged]\n")
+	}
+	s.log(b.String())
+}
+
+func writeDebugHeader(b *strings.Builder, h http.Header) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := strings.Join(h[k], ", ")
+		if debugRedacted[http.CanonicalHeaderKey(k)] {
+			v = "[redacted]"
+		}
+		fmt.Fprintf(b, "%s: %s\n", k, v)
+	}
+}
+
+// formatDebugBody renders a body for the debug log: JSON is pretty-printed,
+// other text is written as is, and compressed or binary bodies are
+// summarized.  Text is truncated to MaxDebugBody bytes.
+func formatDebugBody(h http.Header, body []byte) string {
+	if len(body) == 0 {
+		return "[empty body]"
+	}
+	contentType := h.Get("Content-Type")
+	if enc := strings.ToLower(h.Get("Content-Encoding")); enc != "" && enc != "identity" {
+		return summarizeBody(body, contentType+"; "+enc)
+	}
+	mt, _, _ := mime.ParseMediaType(contentType)
+	if mt == "application/json" || strings.HasSuffix(mt, "+json") {
+		var buf bytes.Buffer
+		if json.Indent(&buf, body, "", "  ") == nil {
+			body = buf.Bytes()
+		}
+	} else if !isTextMime(mt) && !(mt == "" && isText(body)) {
+		return summarizeBody(body, contentType)
+	}
+	if len(body) > MaxDebugBody {
+		return fmt.Sprintf("%s\n... (%d bytes truncated)", body[:MaxDebugBody], len(body)-MaxDebugBody)
+	}
+	return string(body)
+}
+
+// summarizeBody describes a binary body by its size and SHA-256 hash.
+func summarizeBody(body []byte, contentType string) string {
+	sum := sha256.Sum256(body)
+	if contentType == "" {
+		contentType = "unknown type"
+	}
+	return fmt.Sprintf("[binary body: %d bytes, %s, sha256 %s]", len(body), contentType, hex.EncodeToString(sum[:]))
+}
+
+// isTextMime reports whether the media type mt denotes text.
+func isTextMime(mt string) bool {
+	switch {
+	case strings.HasPrefix(mt, "text/"),
+		mt == "application/xml", strings.HasSuffix(mt, "+xml"),
+		mt == "application/x-www-form-urlencoded",
+		mt == "application/javascript", mt == "application/x-ndjson":
+		return true
+	}
+	return false
+}
+
+// isText reports whether body looks like printable UTF-8 text.
+func isText(body []byte) bool {
+	if !utf8.Valid(body) {
+		return false
+	}
+	for _, c := range body {
+		if c < 0x20 && c != '\n' && c != '\r' && c != '\t' {
+			return false
+		}
+	}
+	return true
+}
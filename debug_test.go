@@ -0,0 +1,132 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// captureLog returns the standard logger's output while f runs.
+func captureLog(f func()) string {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	f()
+	return buf.String()
+}
+
+func TestDebugLogJSON(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":1,"tags":["a"]}`))
+	}))
+	defer srv.Close()
+	p := Params{"tenant": "acme"}.AsUrlValues()
+	s := Session{Log: true, Params: &p, Userinfo: url.UserPassword("kirk", "secret")}
+	out := captureLog(func() {
+		if _, err := s.Send(&Request{Method: "POST", Url: srv.URL, Payload: payload{"bar"}, NoSessionParams: true}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	assert.Contains(t, out, "REQUEST\n")
+	assert.Contains(t, out, "(session params not merged: NoSessionParams)\n")
+	assert.Contains(t, out, "(auth: using Session.Userinfo)\n")
+	assert.Contains(t, out, "Authorization: [redacted]\n")
+	assert.NotContains(t, out, "secret")
+	assert.Contains(t, out, "{\n  \"Foo\": \"bar\"\n}\n")
+	assert.Contains(t, out, "RESPONSE\n")
+	assert.Contains(t, out, "{\n  \"id\": 1,\n  \"tags\": [\n    \"a\"\n  ]\n}\n")
+}
+
+func TestDebugLogBinary(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/gz" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "gzip")
+			zw := gzip.NewWriter(w)
+			zw.Write([]byte(`{"id":1}`))
+			zw.Close()
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	}))
+	defer srv.Close()
+	s := Session{Log: true}
+	out := captureLog(func() {
+		if _, err := s.Get(srv.URL, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	assert.Contains(t, out, "[binary body: 16 bytes, image/png, sha256 ")
+	assert.NotContains(t, out, "PNG")
+
+	// Without Session.AcceptEncoding the body stays compressed.
+	h := http.Header{"Accept-Encoding": {"gzip"}}
+	out = captureLog(func() {
+		if _, err := s.Send(&Request{Url: srv.URL + "/gz", Header: &h}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	assert.Contains(t, out, "[binary body: ")
+	assert.Contains(t, out, "application/json; gzip, sha256 ")
+}
+
+func TestFormatDebugBody(t *testing.T) {
+	assert.Equal(t, "plain text", formatDebugBody(http.Header{}, []byte("plain text")))
+	assert.Equal(t, "[empty body]", formatDebugBody(http.Header{}, nil))
+	assert.Equal(t, "[binary body: 3 bytes, unknown type, sha256 039058c6f2c0cb492c533b0a4d14ef77cc0f78abccced5287d84a1a2011cfb81]",
+		formatDebugBody(http.Header{}, []byte{1, 2, 3}))
+	MaxDebugBody = 4
+	defer func() { MaxDebugBody = 4096 }()
+	assert.Equal(t, "<a/>\n... (3 bytes truncated)", formatDebugBody(http.Header{"Content-Type": {"application/xml"}}, []byte("<a/><b>")))
+}
+
+// bufLogger is a Logger collecting output in memory.
+type bufLogger struct{ bytes.Buffer }
+
+func (l *bufLogger) Printf(format string, v ...interface{}) {
+	fmt.Fprintf(&l.Buffer, format, v...)
+}
+
+func TestLogErrors(t *testing.T) {
+	srv := httptest.NewServer(handleStatusJSON(200, "not json"))
+	defer srv.Close()
+	dead := deadURL(t)
+	var result map[string]interface{}
+	var e apiError
+	fail := func(s *Session) {
+		s.Get(dead, nil)
+		s.Send(&Request{Url: srv.URL, Method: "GET", Result: &result})
+		s.Send(&Request{Url: srv.URL, Method: "GET", Error: &e, DecodeInto: func(int) DecodeTarget { return DecodeError }})
+	}
+
+	// Callers handle returned errors; nothing is logged by default.
+	assert.Equal(t, "", captureLog(func() { fail(&Session{}) }))
+
+	var l bufLogger
+	fail(&Session{LogErrors: true, Logger: &l})
+	out := l.String()
+	assert.Contains(t, out, "connection refused")
+	assert.Equal(t, 2, strings.Count(out, "invalid character"), out)
+
+	l.Reset()
+	s := Session{Log: true, Logger: &l}
+	s.Get(srv.URL, nil)
+	assert.Contains(t, l.String(), "RESPONSE")
+}
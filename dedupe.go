@@ -0,0 +1,174 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module guards against sending the same unsafe request twice in quick
+succession, as a double click would.
+*/
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDoubleSubmitEntries bounds the requests a DoubleSubmitGuard
+// remembers when MaxEntries is zero.
+const DefaultDoubleSubmitEntries = 1024
+
+// A DoubleSubmitGuard detects a request with an unsafe method, such as
+// POST, which repeats the method, URL and body of one sent less than Window
+// earlier.  The duplicate fails with a *DuplicateRequestError, or, with
+// ReturnPrevious, gets the outcome of the earlier request once it is
+// complete, its body decoded into the duplicate's Result or Error.  A
+// request which fails without a response, e.g. on a connection error, is
+// forgotten once done, so that it can be sent again at once.  Requests
+// whose body cannot be replayed, and thus hashed, are not
+// guarded.  A guard may be shared by sessions.
+type DoubleSubmitGuard struct {
+	Window         time.Duration
+	ReturnPrevious bool
+	MaxEntries     int // Requests remembered; DefaultDoubleSubmitEntries if zero
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*submission
+	order   []*submission // By time sent, oldest first
+}
+
+// submission is a guarded request, and its outcome once done is closed.
+type submission struct {
+	key  [sha256.Size]byte
+	at   time.Time
+	done chan struct{}
+	resp *Response
+	err  error
+}
+
+// A DuplicateRequestError reports a request refused by DoubleSubmitGuard.
+type DuplicateRequestError struct {
+	Method string
+	URL    string        // Redacted
+	Since  time.Duration // Time since the identical request was sent
+}
+
+func (e *DuplicateRequestError) Error() string {
+	return fmt.Sprintf("napping: duplicate %s %s, %v after an identical request", e.Method, e.URL, e.Since)
+}
+
+// isSafe reports whether method is safe, i.e. read-only, per RFC 7231.
+func isSafe(method string) bool {
+	switch strings.ToUpper(method) {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+// submissionKey hashes the method, URL and body of req.  ok is false if the
+// body cannot be read without consuming it.
+func submissionKey(req *http.Request) (key [sha256.Size]byte, ok bool) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", req.Method, req.URL.String())
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return key, false
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return key, false
+		}
+		_, err = io.Copy(h, body)
+		body.Close()
+		if err != nil {
+			return key, false
+		}
+	}
+	copy(key[:], h.Sum(nil))
+	return key, true
+}
+
+// begin registers req, sent at now, and returns its submission, or the
+// earlier identical one and true.
+func (g *DoubleSubmitGuard) begin(req *http.Request, now time.Time) (sub *submission, dup bool) {
+	key, ok := submissionKey(req)
+	if !ok || isSafe(req.Method) {
+		return nil, false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.entries == nil {
+		g.entries = make(map[[sha256.Size]byte]*submission)
+	}
+	max := g.MaxEntries
+	if max <= 0 {
+		max = DefaultDoubleSubmitEntries
+	}
+	for len(g.order) > 0 && now.Sub(g.order[0].at) >= g.Window {
+		g.evictOldest()
+	}
+	if prev, ok := g.entries[key]; ok {
+		return prev, true
+	}
+	for len(g.order) >= max {
+		g.evictOldest()
+	}
+	sub = &submission{key: key, at: now, done: make(chan struct{})}
+	g.entries[key] = sub
+	g.order = append(g.order, sub)
+	return sub, false
+}
+
+// evictOldest forgets the oldest submission.  The caller holds g.mu.
+func (g *DoubleSubmitGuard) evictOldest() {
+	old := g.order[0]
+	g.order = g.order[1:]
+	if g.entries[old.key] == old {
+		delete(g.entries, old.key)
+	}
+}
+
+// finish records the outcome of sub for its duplicates.  A request which
+// failed without a response is forgotten, so that it can be retried at
+// once.
+func (g *DoubleSubmitGuard) finish(sub *submission, resp *Response, err error) {
+	g.mu.Lock()
+	sub.resp, sub.err = resp, err
+	if resp == nil && err != nil && g.entries[sub.key] == sub {
+		delete(g.entries, sub.key)
+		for i, other := range g.order {
+			if other == sub {
+				g.order = append(g.order[:i:i], g.order[i+1:]...)
+				break
+			}
+		}
+	}
+	g.mu.Unlock()
+	close(sub.done)
+}
+
+// duplicate handles r, a duplicate of prev, per the guard's configuration.
+func (s *Session) duplicate(r *Request, req *http.Request, prev *submission) (*Response, error) {
+	g := s.DoubleSubmitGuard
+	if !g.ReturnPrevious {
+		return nil, &DuplicateRequestError{Method: req.Method, URL: req.URL.Redacted(), Since: s.now().Sub(prev.at)}
+	}
+	select {
+	case <-prev.done:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	if prev.resp != nil && (r.Result != nil || r.Error != nil) {
+		cp := Request(*prev.resp)
+		cp.Result, cp.Error, cp.DecodeInto = r.Result, r.Error, r.DecodeInto
+		s.decode(&cp)
+	}
+	return prev.resp, prev.err
+}
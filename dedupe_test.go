@@ -0,0 +1,144 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoubleSubmitGuard(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if req.Method == "PUT" {
+			<-release
+		}
+		fmt.Fprintf(w, `{"title": "record %d"}`, n)
+	}))
+	defer srv.Close()
+
+	guard := &DoubleSubmitGuard{Window: time.Hour}
+	s := Session{DoubleSubmitGuard: guard}
+	_, err := s.Post(srv.URL, map[string]int{"n": 1})
+	assert.NoError(t, err)
+	_, err = s.Post(srv.URL, map[string]int{"n": 1})
+	var de *DuplicateRequestError
+	if assert.True(t, errors.As(err, &de)) {
+		assert.Equal(t, "POST", de.Method)
+		assert.Equal(t, srv.URL, de.URL)
+	}
+
+	// Other bodies, safe methods and explicit bypasses go through.
+	_, err = s.Post(srv.URL, map[string]int{"n": 2})
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = s.Get(srv.URL, nil)
+		assert.NoError(t, err)
+		_, err = s.Send(&Request{Url: srv.URL, Method: "POST", Payload: map[string]int{"n": 1}, AllowDuplicate: true})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(6), atomic.LoadInt32(&calls))
+
+	// A duplicate of a request in flight waits for its outcome.
+	guard.ReturnPrevious = true
+	type result struct {
+		f    feed
+		resp *Response
+		err  error
+	}
+	put := func(out chan<- result) {
+		var res result
+		res.resp, res.err = s.Send(&Request{Url: srv.URL, Method: "PUT", Payload: "x", Result: &res.f})
+		out <- res
+	}
+	first, second := make(chan result), make(chan result)
+	go put(first)
+	for atomic.LoadInt32(&calls) < 7 {
+		time.Sleep(time.Millisecond)
+	}
+	go put(second)
+	close(release)
+	a, b := <-first, <-second
+	assert.NoError(t, a.err)
+	assert.NoError(t, b.err)
+	assert.Equal(t, "record 7", a.f.Title)
+	assert.Equal(t, "record 7", b.f.Title)
+	assert.Equal(t, a.resp, b.resp)
+	assert.Equal(t, int32(7), atomic.LoadInt32(&calls))
+}
+
+func TestDoubleSubmitGuardBounds(t *testing.T) {
+	g := &DoubleSubmitGuard{Window: time.Second, MaxEntries: 2}
+	req := func(body string) *http.Request {
+		r, _ := http.NewRequest("POST", "http://x.test/", strings.NewReader(body))
+		return r
+	}
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, dup := g.begin(req("a"), t0)
+	assert.False(t, dup)
+	_, dup = g.begin(req("a"), t0.Add(999*time.Millisecond))
+	assert.True(t, dup)
+	_, dup = g.begin(req("a"), t0.Add(time.Second))
+	assert.False(t, dup)
+
+	// The oldest entry is evicted to make room.
+	now := t0.Add(time.Second)
+	g.begin(req("b"), now)
+	g.begin(req("c"), now)
+	assert.Len(t, g.order, 2)
+	_, dup = g.begin(req("a"), now)
+	assert.False(t, dup)
+	_, dup = g.begin(req("c"), now)
+	assert.True(t, dup)
+
+	// Bodies which cannot be replayed are not guarded.
+	r := req("d")
+	r.GetBody = nil
+	r.Body = ioutil.NopCloser(io.MultiReader(strings.NewReader("d")))
+	sub, dup := g.begin(r, now)
+	assert.Nil(t, sub)
+	assert.False(t, dup)
+}
+
+func TestDoubleSubmitGuardForgetsFailures(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.Write([]byte(`{"title": "saved"}`))
+	}))
+	defer srv.Close()
+
+	// A submission which failed without a response may be retried at once.
+	s := Session{DoubleSubmitGuard: &DoubleSubmitGuard{Window: time.Hour, ReturnPrevious: true}}
+	_, err := s.Post(srv.URL, map[string]int{"n": 1})
+	assert.Error(t, err)
+	var f feed
+	_, err = s.Send(&Request{Url: srv.URL, Method: "POST", Payload: map[string]int{"n": 1}, Result: &f})
+	assert.NoError(t, err)
+	assert.Equal(t, "saved", f.Title)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// The successful one is remembered.
+	_, err = s.Post(srv.URL, map[string]int{"n": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module dials connections according to Session.IPPolicy, choosing and
+ordering the resolved addresses of a host by IP family.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// An IPPolicy selects the IP families used to connect to dual-stack hosts.
+type IPPolicy int
+
+const (
+	// Auto tries the family of the first resolved address first, and the
+	// other family after Session.FallbackDelay ("happy eyeballs").
+	Auto IPPolicy = iota
+
+	IPv4Only // Connect over IPv4 only
+	IPv6Only // Connect over IPv6 only
+
+	// PreferIPv4 tries IPv4 first, and IPv6 after Session.FallbackDelay.
+	PreferIPv4
+)
+
+const defaultFallbackDelay = 300 * time.Millisecond
+
+// A Resolver looks up the addresses of a host.  *net.Resolver implements it.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// RemoteAddr returns the address of the server the request was sent to, or
+// nil if it is not known.
+func (r *Response) RemoteAddr() net.Addr {
+	return r.remoteAddr
+}
+
+// policyDialer returns a dial function which resolves host names with
+// s.Resolver, through the DNS cache if enabled, and connects with dial
+// according to s.IPPolicy.
+func (s *Session) policyDialer(dial dialFunc) dialFunc {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	var resolver Resolver = net.DefaultResolver
+	if s.Resolver != nil {
+		resolver = s.Resolver
+	}
+	policy := s.IPPolicy
+	cache := s.dns
+	delay := s.FallbackDelay
+	if delay <= 0 {
+		delay = defaultFallbackDelay
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		var addrs []net.IPAddr
+		if ip := net.ParseIP(host); ip != nil {
+			addrs = []net.IPAddr{{IP: ip}}
+		} else if cache != nil {
+			if addrs, err = cache.lookup(ctx, resolver, host); err != nil {
+				return nil, err
+			}
+		} else if addrs, err = resolver.LookupIPAddr(ctx, host); err != nil {
+			return nil, err
+		}
+		primary, fallback := policy.order(addrs)
+		if len(primary) == 0 {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: fmt.Errorf("napping: no address of %s allowed by IP policy", host)}
+		}
+		conn, err := dialRace(ctx, network, port, primary, fallback, delay, dial)
+		if err != nil && cache != nil && ctx.Err() == nil {
+			cache.invalidate(host)
+		}
+		return conn, err
+	}
+}
+
+// order splits addrs into the addresses to try first and those to try
+// after the fallback delay.
+func (p IPPolicy) order(addrs []net.IPAddr) (primary, fallback []net.IPAddr) {
+	var v4, v6 []net.IPAddr
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			v4 = append(v4, a)
+		} else {
+			v6 = append(v6, a)
+		}
+	}
+	switch p {
+	case IPv4Only:
+		return v4, nil
+	case IPv6Only:
+		return v6, nil
+	case PreferIPv4:
+		primary, fallback = v4, v6
+	default:
+		primary, fallback = v6, v4
+		if len(addrs) > 0 && addrs[0].IP.To4() != nil {
+			primary, fallback = v4, v6
+		}
+	}
+	if len(primary) == 0 {
+		return fallback, nil
+	}
+	return primary, fallback
+}
+
+// dialRace connects to the primary addresses in turn, and starts on the
+// fallback addresses in parallel once delay has passed or the primaries
+// have failed.  The first connection established wins.
+func dialRace(ctx context.Context, network, port string, primary, fallback []net.IPAddr, delay time.Duration, dial dialFunc) (net.Conn, error) {
+	if len(fallback) == 0 {
+		return dialSerial(ctx, network, port, primary, dial)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, 2)
+	start := func(addrs []net.IPAddr) {
+		go func() {
+			conn, err := dialSerial(ctx, network, port, addrs, dial)
+			results <- result{conn, err}
+		}()
+	}
+	start(primary)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	pending, fallbackStarted := 1, false
+	var firstErr error
+	for {
+		select {
+		case <-timer.C:
+			if !fallbackStarted {
+				start(fallback)
+				fallbackStarted = true
+				pending++
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				if pending > 0 {
+					// Close the losing connection, if any, once it arrives.
+					go func() {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}()
+				}
+				return res.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			if !fallbackStarted {
+				start(fallback)
+				fallbackStarted = true
+				pending++
+			} else if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// dialSerial connects to addrs in turn, returning the first connection.
+func dialSerial(ctx context.Context, network, port string, addrs []net.IPAddr, dial dialFunc) (net.Conn, error) {
+	var err error
+	for _, a := range addrs {
+		var conn net.Conn
+		if conn, err = dial(ctx, network, net.JoinHostPort(a.String(), port)); err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stubResolver resolves every host to the same addresses.
+type stubResolver []string
+
+func (s stubResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	var addrs []net.IPAddr
+	for _, a := range s {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(a)})
+	}
+	return addrs, nil
+}
+
+func TestIPPolicy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleEmptyOK))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	target := "http://dualstack.test:" + u.Port() + "/"
+	// The server listens on IPv4 only, so IPv6 connections are refused.
+	dual := stubResolver{"::1", "127.0.0.1"}
+
+	for _, policy := range []IPPolicy{Auto, IPv4Only, PreferIPv4} {
+		s := Session{IPPolicy: policy, Resolver: dual}
+		resp, err := s.Get(target, nil)
+		if err != nil {
+			t.Fatal(policy, err)
+		}
+		host, _, _ := net.SplitHostPort(resp.RemoteAddr().String())
+		assert.Equal(t, "127.0.0.1", host, "policy %d", policy)
+	}
+
+	s := Session{IPPolicy: IPv6Only, Resolver: dual}
+	_, err := s.Get(target, nil)
+	assert.Error(t, err)
+	s = Session{IPPolicy: IPv6Only, Resolver: stubResolver{"127.0.0.1"}}
+	_, err = s.Get(target, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "no address of dualstack.test allowed by IP policy")
+}
+
+func TestDialRaceFallback(t *testing.T) {
+	var mu sync.Mutex
+	var dialed []string
+	// IPv6 hangs until canceled, as on a host with a broken IPv6 route.
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		dialed = append(dialed, addr)
+		mu.Unlock()
+		if addr[0] == '[' {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		c1, c2 := net.Pipe()
+		c2.Close()
+		return c1, nil
+	}
+	s := Session{Resolver: stubResolver{"2001:db8::1", "192.0.2.1"}, FallbackDelay: 20 * time.Millisecond}
+	start := time.Now()
+	conn, err := s.policyDialer(dial)(context.Background(), "tcp", "example.test:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 20*time.Millisecond && elapsed < time.Second, "%v", elapsed)
+	mu.Lock()
+	assert.Equal(t, []string{"[2001:db8::1]:80", "192.0.2.1:80"}, dialed)
+	mu.Unlock()
+
+	// PreferIPv4 does not wait for IPv6.
+	dialed = nil
+	s.IPPolicy = PreferIPv4
+	s.FallbackDelay = time.Second
+	start = time.Now()
+	conn, err = s.policyDialer(dial)(context.Background(), "tcp", "example.test:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+
+	// Errors from both families.
+	failing := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, errors.New("unreachable " + addr)
+	}
+	_, err = s.policyDialer(failing)(context.Background(), "tcp", "example.test:80")
+	assert.EqualError(t, err, "unreachable 192.0.2.1:80")
+}
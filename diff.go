@@ -0,0 +1,221 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module compares JSON documents, for detecting drift between live
+responses and stored golden copies.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A DiffKind classifies a Difference.
+type DiffKind int
+
+const (
+	DiffAdded   DiffKind = iota // Present only in the second document
+	DiffRemoved                 // Present only in the first document
+	DiffChanged                 // Present in both with different values
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffChanged:
+		return "changed"
+	}
+	return "unknown"
+}
+
+// A Difference describes one point at which two JSON documents differ.  Path
+// uses the same dot notation as Response.Path; Old and New hold the decoded
+// values from the first and second document respectively.
+type Difference struct {
+	Path string
+	Kind DiffKind
+	Old  interface{}
+	New  interface{}
+}
+
+func (d Difference) String() string {
+	path := d.Path
+	if path == "" {
+		path = "(root)"
+	}
+	switch d.Kind {
+	case DiffAdded:
+		return fmt.Sprintf("%s: added %s", path, jsonString(d.New))
+	case DiffRemoved:
+		return fmt.Sprintf("%s: removed %s", path, jsonString(d.Old))
+	}
+	return fmt.Sprintf("%s: changed %s -> %s", path, jsonString(d.Old), jsonString(d.New))
+}
+
+// DiffOptions controls DiffJSON and Response.DiffAgainstFile.
+type DiffOptions struct {
+	// Paths to skip, e.g. "meta.timestamp".  A "*" segment matches any
+	// single object key or array index, as in "items.*.id".
+	IgnorePaths []string
+
+	// Numbers differing by no more than Tolerance are considered equal.
+	Tolerance float64
+
+	// Update makes DiffAgainstFile rewrite the golden file from the
+	// response instead of comparing against it.
+	Update bool
+}
+
+// DiffJSON compares JSON documents a and b, returning their differences
+// ordered by path.  An empty result means the documents are equivalent.
+func DiffJSON(a, b []byte, opts DiffOptions) ([]Difference, error) {
+	var va, vb interface{}
+	if err := json.Unmarshal(a, &va); err != nil {
+		return nil, fmt.Errorf("napping: diff: first document: %w", err)
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		return nil, fmt.Errorf("napping: diff: second document: %w", err)
+	}
+	d := differ{opts: opts}
+	d.diff(nil, va, vb)
+	return d.out, nil
+}
+
+// DiffAgainstFile compares the JSON body of the response against the golden
+// file at path.  The golden file is the first document, so fields missing
+// from the response are reported as DiffRemoved.  With opts.Update set the
+// golden file is instead (re)written from the response body and no
+// differences are returned.
+func (r *Response) DiffAgainstFile(path string, opts DiffOptions) ([]Difference, error) {
+	if r.spill != nil {
+		return nil, ErrBodySpilled
+	}
+	if opts.Update {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, r.body, "", "  "); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+		return nil, ioutil.WriteFile(path, buf.Bytes(), 0644)
+	}
+	golden, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return DiffJSON(golden, r.body, opts)
+}
+
+type differ struct {
+	opts DiffOptions
+	out  []Difference
+}
+
+func (d *differ) add(path []string, kind DiffKind, old, new interface{}) {
+	d.out = append(d.out, Difference{Path: strings.Join(path, "."), Kind: kind, Old: old, New: new})
+}
+
+func (d *differ) diff(path []string, a, b interface{}) {
+	if d.ignored(path) {
+		return
+	}
+	switch va := a.(type) {
+	case map[string]interface{}:
+		vb, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(va)+len(vb))
+		for k := range va {
+			keys = append(keys, k)
+		}
+		for k := range vb {
+			if _, ok := va[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := append(path[:len(path):len(path)], k)
+			ea, inA := va[k]
+			eb, inB := vb[k]
+			switch {
+			case d.ignored(p):
+			case !inB:
+				d.add(p, DiffRemoved, ea, nil)
+			case !inA:
+				d.add(p, DiffAdded, nil, eb)
+			default:
+				d.diff(p, ea, eb)
+			}
+		}
+		return
+	case []interface{}:
+		vb, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(va) || i < len(vb); i++ {
+			p := append(path[:len(path):len(path)], strconv.Itoa(i))
+			switch {
+			case d.ignored(p):
+			case i >= len(vb):
+				d.add(p, DiffRemoved, va[i], nil)
+			case i >= len(va):
+				d.add(p, DiffAdded, nil, vb[i])
+			default:
+				d.diff(p, va[i], vb[i])
+			}
+		}
+		return
+	case float64:
+		if vb, ok := b.(float64); ok && math.Abs(va-vb) <= d.opts.Tolerance {
+			return
+		}
+	}
+	if !reflect.DeepEqual(a, b) {
+		d.add(path, DiffChanged, a, b)
+	}
+}
+
+func (d *differ) ignored(path []string) bool {
+	for _, ig := range d.opts.IgnorePaths {
+		pattern := splitPath(ig)
+		if len(pattern) != len(path) {
+			continue
+		}
+		match := true
+		for i := range pattern {
+			if pattern[i] != "*" && pattern[i] != path[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonString(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
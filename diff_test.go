@@ -0,0 +1,76 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffJSON(t *testing.T) {
+	a := `{"id": 1, "name": "kirk", "rank": 1.0, "ts": "a", "tags": ["x", "y"], "items": [{"id": 1, "v": 1}]}`
+	b := `{"id": 1, "name": "spock", "rank": 1.05, "ts": "b", "tags": ["x"], "items": [{"id": 2, "v": 1}], "new": true}`
+	diffs, err := DiffJSON([]byte(a), []byte(b), DiffOptions{
+		IgnorePaths: []string{"ts", "items.*.id"},
+		Tolerance:   0.1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []Difference{
+		{Path: "name", Kind: DiffChanged, Old: "kirk", New: "spock"},
+		{Path: "new", Kind: DiffAdded, New: true},
+		{Path: "tags.1", Kind: DiffRemoved, Old: "y"},
+	}, diffs)
+	assert.Equal(t, `name: changed "kirk" -> "spock"`, diffs[0].String())
+
+	diffs, err = DiffJSON([]byte(`{"a": 1}`), []byte(`{"a": 1.05}`), DiffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, diffs, 1)
+
+	_, err = DiffJSON([]byte(`{`), []byte(`{}`), DiffOptions{})
+	assert.Error(t, err)
+}
+
+func TestDiffAgainstFile(t *testing.T) {
+	body := `{"id": 1, "name": "kirk"}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	golden := filepath.Join(t.TempDir(), "golden.json")
+	resp, err := Get(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffs, err := resp.DiffAgainstFile(golden, DiffOptions{Update: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, diffs)
+
+	body = `{"id": 2, "name": "kirk"}`
+	resp, err = Get(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffs, err = resp.DiffAgainstFile(golden, DiffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []Difference{{Path: "id", Kind: DiffChanged, Old: 1.0, New: 2.0}}, diffs)
+	diffs, err = resp.DiffAgainstFile(golden, DiffOptions{IgnorePaths: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, diffs)
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements an optional cache of DNS lookups, which spares the DNS
+servers when connection pools churn under high load.
+*/
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// MinDNSCacheTTL is the shortest time-to-live of cached DNS lookups.
+const MinDNSCacheTTL = time.Second
+
+// dnsRefreshTimeout bounds background refreshes of stale entries.
+const dnsRefreshTimeout = 10 * time.Second
+
+// DNSCacheStats counts the lookups of a Session's DNS cache.
+type DNSCacheStats struct {
+	Hits          int64 // Served from a fresh entry
+	StaleHits     int64 // Served from an expired entry while refreshing it
+	Misses        int64 // Resolved before dialing
+	Invalidations int64 // Entries dropped because no address could be dialed
+	Entries       int   // Entries currently cached
+}
+
+type dnsEntry struct {
+	addrs      []net.IPAddr
+	expires    time.Time
+	refreshing bool
+}
+
+// dnsCache caches the results of a Resolver.
+type dnsCache struct {
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+	stats   DNSCacheStats
+}
+
+// EnableDNSCache makes the session cache successful DNS lookups for ttl,
+// which is at least MinDNSCacheTTL, keeping at most maxEntries hosts (zero
+// means no limit).  For up to ttl after an entry expires it is still used
+// while it is refreshed in the background.  An entry is dropped when none
+// of its addresses can be dialed.  The cache sits between Resolver and the
+// IPPolicy dialer.  Call EnableDNSCache before the first request; it has no
+// effect when Client or Transport is supplied.
+func (s *Session) EnableDNSCache(ttl time.Duration, maxEntries int) {
+	if ttl < MinDNSCacheTTL {
+		ttl = MinDNSCacheTTL
+	}
+	s.dns = &dnsCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        s.now,
+		entries:    map[string]*dnsEntry{},
+	}
+}
+
+// DNSCacheStats returns the counters of the DNS cache enabled with
+// EnableDNSCache, or zero counters if it is not enabled.
+func (s *Session) DNSCacheStats() DNSCacheStats {
+	if s.dns == nil {
+		return DNSCacheStats{}
+	}
+	s.dns.mu.Lock()
+	defer s.dns.mu.Unlock()
+	stats := s.dns.stats
+	stats.Entries = len(s.dns.entries)
+	return stats
+}
+
+// lookup resolves host through the cache, using next on a miss.
+func (c *dnsCache) lookup(ctx context.Context, next Resolver, host string) ([]net.IPAddr, error) {
+	c.mu.Lock()
+	now := c.now()
+	if e, ok := c.entries[host]; ok {
+		switch {
+		case now.Before(e.expires):
+			c.stats.Hits++
+			c.mu.Unlock()
+			return e.addrs, nil
+		case now.Before(e.expires.Add(c.ttl)):
+			c.stats.StaleHits++
+			if !e.refreshing {
+				e.refreshing = true
+				go c.refresh(next, host)
+			}
+			c.mu.Unlock()
+			return e.addrs, nil
+		}
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	addrs, err := next.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	c.store(host, addrs)
+	return addrs, nil
+}
+
+// refresh looks up host again in the background.  On failure the stale
+// entry is kept until it is too old to be served.
+func (c *dnsCache) refresh(next Resolver, host string) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsRefreshTimeout)
+	defer cancel()
+	addrs, err := next.LookupIPAddr(ctx, host)
+	if err == nil {
+		c.store(host, addrs)
+		return
+	}
+	c.mu.Lock()
+	if e, ok := c.entries[host]; ok {
+		e.refreshing = false
+	}
+	c.mu.Unlock()
+}
+
+// store caches addrs for host, evicting the entry closest to expiry if
+// the cache is full.
+func (c *dnsCache) store(host string, addrs []net.IPAddr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[host]; !ok && c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+		var oldest string
+		for h, e := range c.entries {
+			if oldest == "" || e.expires.Before(c.entries[oldest].expires) {
+				oldest = h
+			}
+		}
+		delete(c.entries, oldest)
+	}
+	c.entries[host] = &dnsEntry{addrs: addrs, expires: c.now().Add(c.ttl)}
+}
+
+// invalidate drops the entry for host.
+func (c *dnsCache) invalidate(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[host]; ok {
+		delete(c.entries, host)
+		c.stats.Invalidations++
+	}
+}
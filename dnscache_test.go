@@ -0,0 +1,125 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingResolver resolves every host to addr and counts lookups.
+type countingResolver struct {
+	mu      sync.Mutex
+	addr    string
+	lookups int
+	done    chan struct{}
+}
+
+func (c *countingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lookups++
+	if c.done != nil {
+		c.done <- struct{}{}
+	}
+	return []net.IPAddr{{IP: net.ParseIP(c.addr)}}, nil
+}
+
+func (c *countingResolver) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lookups
+}
+
+func TestDNSCache(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleEmptyOK))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	target := "http://cached.test:" + u.Port() + "/"
+
+	res := &countingResolver{addr: "127.0.0.1"}
+	s := Session{Resolver: res}
+	s.EnableDNSCache(time.Minute, 0)
+	var mu sync.Mutex
+	now := time.Now()
+	s.dns.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		mu.Lock()
+		now = now.Add(d)
+		mu.Unlock()
+	}
+	get := func() {
+		// Force a fresh dial for each request.
+		s.Get(target, nil)
+		s.Client.CloseIdleConnections()
+	}
+
+	get()
+	get()
+	assert.Equal(t, 1, res.count())
+	stats := s.DNSCacheStats()
+	assert.Equal(t, int64(1), stats.Misses)
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, 1, stats.Entries)
+
+	// An expired entry is still served while it is refreshed.
+	res.done = make(chan struct{}, 1)
+	advance(90 * time.Second)
+	get()
+	<-res.done
+	assert.Equal(t, int64(1), s.DNSCacheStats().StaleHits)
+	assert.Equal(t, 2, res.count())
+
+	// Too old to be served.
+	advance(3 * time.Minute)
+	get()
+	<-res.done
+	assert.Equal(t, int64(2), s.DNSCacheStats().Misses)
+}
+
+func TestDNSCacheInvalidate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleEmptyOK))
+	u, _ := url.Parse(srv.URL)
+	target := "http://cached.test:" + u.Port() + "/"
+	srv.Close()
+
+	res := &countingResolver{addr: "127.0.0.1"}
+	s := Session{Resolver: res}
+	s.EnableDNSCache(time.Minute, 0)
+	_, err := s.Get(target, nil)
+	assert.Error(t, err)
+	stats := s.DNSCacheStats()
+	assert.Equal(t, int64(1), stats.Invalidations)
+	assert.Equal(t, 0, stats.Entries)
+}
+
+func TestDNSCacheLimits(t *testing.T) {
+	s := Session{}
+	s.EnableDNSCache(0, 2)
+	assert.Equal(t, MinDNSCacheTTL, s.dns.ttl)
+	now := time.Now()
+	s.dns.now = func() time.Time { return now }
+	res := &countingResolver{addr: "127.0.0.1"}
+	for _, host := range []string{"a", "b", "c"} {
+		s.dns.lookup(context.Background(), res, host)
+		now = now.Add(time.Millisecond)
+	}
+	assert.Equal(t, 2, s.DNSCacheStats().Entries)
+	assert.NotContains(t, s.dns.entries, "a")
+	assert.Equal(t, DNSCacheStats{}, (&Session{}).DNSCacheStats())
+}
@@ -19,7 +19,12 @@ Example:
 	}
 	result := Spam{}
 	url := "http://foo.com/bar"
-	resp, err := napping.Post(url, &payload, &result, nil)
+	resp, err := napping.Send(&napping.Request{
+		Url:     url,
+		Method:  "POST",
+		Payload: &payload,
+		Result:  &result,
+	})
 	if err != nil {
 		panic(err)
 	}
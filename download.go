@@ -0,0 +1,134 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements conditional downloads of files, for mirroring assets
+which rarely change.
+*/
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A DownloadResult describes the outcome of DownloadIfModified.
+type DownloadResult struct {
+	Status       int
+	Unmodified   bool      // The server answered 304; the file was kept
+	Bytes        int64     // Bytes written, if the file was downloaded
+	ETag         string    // ETag of the file, if the server sent one
+	LastModified time.Time // Last-Modified of the file, if the server sent one
+	Response     *Response
+}
+
+// etagSuffix names the sidecar file in which the ETag of a download is kept.
+const etagSuffix = ".etag"
+
+// DownloadIfModified downloads url to destPath unless the existing file is
+// still current.  The request carries If-Modified-Since with the mtime of
+// destPath, and If-None-Match with the ETag stored in destPath+".etag" by a
+// previous download.  A 304 response leaves the file untouched.
+//
+// A 200 response is streamed to a temporary file in the same directory,
+// synced and then renamed over destPath, so an interrupted download never
+// clobbers the existing file.  Its mtime is set from Last-Modified.  Any
+// other status is returned as an error.
+func (s *Session) DownloadIfModified(url, destPath string) (*DownloadResult, error) {
+	return s.DownloadIfModifiedCtx(context.Background(), url, destPath)
+}
+
+// DownloadIfModifiedCtx is DownloadIfModified, canceled when ctx is done.
+func (s *Session) DownloadIfModifiedCtx(ctx context.Context, url, destPath string) (*DownloadResult, error) {
+	header := http.Header{}
+	if fi, err := os.Stat(destPath); err == nil {
+		header.Set("If-Modified-Since", fi.ModTime().UTC().Format(http.TimeFormat))
+		if etag, err := ioutil.ReadFile(destPath + etagSuffix); err == nil {
+			if v := strings.TrimSpace(string(etag)); v != "" {
+				header.Set("If-None-Match", v)
+			}
+		}
+	}
+	r := Request{
+		Method:         "GET",
+		Url:            url,
+		Header:         &header,
+		NotProcessBody: true,
+	}
+	resp, err := s.SendWithContext(ctx, &r)
+	if err != nil {
+		return nil, err
+	}
+	body := resp.HttpResponse().Body
+	defer body.Close()
+	res := &DownloadResult{
+		Status:   resp.Status(),
+		ETag:     resp.HttpResponse().Header.Get("ETag"),
+		Response: resp,
+	}
+	if lm, ok := resp.LastModified(); ok {
+		res.LastModified = lm
+	}
+	switch res.Status {
+	case http.StatusNotModified:
+		res.Unmodified = true
+		return res, nil
+	case http.StatusOK:
+	default:
+		return res, fmt.Errorf("napping: download of %s: unexpected status %d", url, res.Status)
+	}
+
+	if res.Bytes, err = writeFileAtomic(destPath, body, res.LastModified); err != nil {
+		return res, err
+	}
+	if res.ETag != "" {
+		err = ioutil.WriteFile(destPath+etagSuffix, []byte(res.ETag+"\n"), 0644)
+	} else if err = os.Remove(destPath + etagSuffix); os.IsNotExist(err) {
+		err = nil
+	}
+	return res, err
+}
+
+// writeFileAtomic copies src to a temporary file next to path and renames
+// it into place once it is complete and synced.  mtime is applied unless it
+// is zero.
+func writeFileAtomic(path string, src io.Reader, mtime time.Time) (n int64, err error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if n, err = io.Copy(tmp, src); err != nil {
+		return n, err
+	}
+	if err = tmp.Sync(); err != nil {
+		return n, err
+	}
+	if err = tmp.Close(); err != nil {
+		return n, err
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return n, err
+	}
+	if !mtime.IsZero() {
+		if err = os.Chtimes(tmp.Name(), mtime, mtime); err != nil {
+			return n, err
+		}
+	}
+	return n, os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadIfModified(t *testing.T) {
+	modified := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	content := "asset v1"
+	etag := `"v1"`
+	truncate := false
+	var gotIMS, gotINM string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotIMS = req.Header.Get("If-Modified-Since")
+		gotINM = req.Header.Get("If-None-Match")
+		if gotINM == etag {
+			w.WriteHeader(304)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		if truncate {
+			// Promise more than is sent, so the client sees an unexpected EOF.
+			w.Header().Set("Content-Length", "100")
+		}
+		w.Write([]byte(content))
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "asset.bin")
+	s := Session{}
+
+	res, err := s.DownloadIfModified(srv.URL, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "", gotIMS)
+	assert.False(t, res.Unmodified)
+	assert.Equal(t, int64(8), res.Bytes)
+	b, _ := ioutil.ReadFile(dest)
+	assert.Equal(t, "asset v1", string(b))
+	fi, _ := os.Stat(dest)
+	assert.True(t, modified.Equal(fi.ModTime()))
+
+	res, err = s.DownloadIfModified(srv.URL, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Wed, 21 Oct 2015 07:28:00 GMT", gotIMS)
+	assert.Equal(t, `"v1"`, gotINM)
+	assert.True(t, res.Unmodified)
+	assert.Equal(t, 304, res.Status)
+
+	// A broken download of a new version keeps the old file.
+	etag, content, truncate = `"v2"`, "asset v2, partial", true
+	_, err = s.DownloadIfModified(srv.URL, dest)
+	assert.Error(t, err)
+	b, _ = ioutil.ReadFile(dest)
+	assert.Equal(t, "asset v1", string(b))
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 2) // asset.bin and asset.bin.etag
+
+	truncate = false
+	res, err = s.DownloadIfModified(srv.URL, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `"v2"`, res.ETag)
+	b, _ = ioutil.ReadFile(dest)
+	assert.Equal(t, "asset v2, partial", string(b))
+	b, _ = ioutil.ReadFile(dest + ".etag")
+	assert.Equal(t, "\"v2\"\n", string(b))
+}
+
+func TestDownloadIfModifiedStatus(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	dest := filepath.Join(t.TempDir(), "missing")
+	res, err := (&Session{}).DownloadIfModified(srv.URL, dest)
+	assert.Error(t, err)
+	assert.Equal(t, 404, res.Status)
+	_, err = os.Stat(dest)
+	assert.True(t, os.IsNotExist(err))
+}
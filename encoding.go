@@ -0,0 +1,91 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements response decompression for sessions which set their
+own Accept-Encoding, in which case net/http does not decompress.
+*/
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// accepts reports whether the Accept-Encoding value offered coding, that
+// is, listed it with a q-value other than zero.
+func accepts(offered, coding string) bool {
+	for _, member := range splitList(offered, ',') {
+		params := splitList(member, ';')
+		if len(params) == 0 || !strings.EqualFold(params[0], coding) {
+			continue
+		}
+		q, err := strconv.ParseFloat(parseParams(params[1:])["q"], 64)
+		return err != nil || q != 0
+	}
+	return false
+}
+
+// decompress replaces the body of resp with a decoding reader if its
+// Content-Encoding is one which was offered and which napping supports.
+// Other encodings are left untouched.
+func decompress(resp *http.Response, offered string) error {
+	coding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if coding == "" || !accepts(offered, coding) {
+		return nil
+	}
+	var dec io.ReadCloser
+	switch coding {
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		dec = zr
+	case "deflate":
+		dec = deflateReader(resp.Body)
+	default:
+		return nil
+	}
+	resp.Body = &decodedBody{dec, resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// deflateReader returns a reader decoding the deflate coding of HTTP, which
+// is zlib-wrapped (RFC 9110, section 8.4.1.2).  Some servers send raw
+// deflate data instead, which is decoded as such if body does not start
+// with a zlib header.
+func deflateReader(body io.Reader) io.ReadCloser {
+	br := bufio.NewReader(body)
+	if h, err := br.Peek(2); err == nil && h[0]&0x0f == 8 && (uint(h[0])<<8|uint(h[1]))%31 == 0 {
+		if zr, err := zlib.NewReader(br); err == nil {
+			return zr
+		}
+	}
+	return flate.NewReader(br)
+}
+
+// decodedBody reads through a decoder and closes both it and the
+// underlying body.
+type decodedBody struct {
+	io.ReadCloser
+	orig io.ReadCloser
+}
+
+func (b *decodedBody) Close() error {
+	b.ReadCloser.Close()
+	return b.orig.Close()
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func gzipped(s string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(s))
+	zw.Close()
+	return buf.Bytes()
+}
+
+// handleAlwaysGzip compresses its response regardless of what was asked for.
+func handleAlwaysGzip(seen *string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		*seen = req.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(gzipped("hello"))
+	}
+}
+
+func TestAcceptEncodingIdentity(t *testing.T) {
+	var seen string
+	srv := httptest.NewServer(handleAlwaysGzip(&seen))
+	defer srv.Close()
+	s := Session{AcceptEncoding: "identity"}
+	resp, err := s.Get(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "identity", seen)
+	assert.Equal(t, gzipped("hello"), resp.RawByte())
+	assert.Equal(t, "gzip", resp.HttpResponse().Header.Get("Content-Encoding"))
+}
+
+func TestAcceptEncodingGzip(t *testing.T) {
+	var seen string
+	srv := httptest.NewServer(handleAlwaysGzip(&seen))
+	defer srv.Close()
+	s := Session{AcceptEncoding: "br;q=1.0, gzip;q=0.8"}
+	resp, err := s.Get(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "br;q=1.0, gzip;q=0.8", seen)
+	assert.Equal(t, "hello", resp.RawText())
+	assert.True(t, resp.HttpResponse().Uncompressed)
+
+	s = Session{AcceptEncoding: "gzip;q=0, identity"}
+	resp, err = s.Get(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, gzipped("hello"), resp.RawByte())
+}
+
+func TestAccepts(t *testing.T) {
+	cases := []struct {
+		offered string
+		want    bool
+	}{
+		{"gzip", true},
+		{"GZIP", true},
+		{"br, gzip;q=0.8", true},
+		{"gzip;q=1", true},
+		{"gzip;q=0.001", true},
+		{"gzip;q=0", false},
+		{"gzip;q=0.0", false},
+		{"gzip;q=0.00", false},
+		{"gzip; q=0.000", false},
+		{"gzip;q=bogus", true},
+		{"br, deflate", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, accepts(c.offered, "gzip"), c.offered)
+	}
+}
+
+func TestAcceptEncodingDeflate(t *testing.T) {
+	var zbuf, fbuf bytes.Buffer
+	zw := zlib.NewWriter(&zbuf)
+	zw.Write([]byte("hello zlib"))
+	zw.Close()
+	fw, _ := flate.NewWriter(&fbuf, flate.DefaultCompression)
+	fw.Write([]byte("hello raw"))
+	fw.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Encoding", "deflate")
+		if req.URL.Path == "/raw" {
+			w.Write(fbuf.Bytes())
+			return
+		}
+		w.Write(zbuf.Bytes())
+	}))
+	defer srv.Close()
+	s := Session{AcceptEncoding: "deflate"}
+	resp, err := s.Get(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "hello zlib", resp.RawText())
+
+	// Raw deflate data, as some servers send, is decoded too.
+	resp, err = s.Get(srv.URL+"/raw", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "hello raw", resp.RawText())
+}
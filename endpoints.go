@@ -0,0 +1,344 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements failover and load distribution between alternate
+endpoints of a service, with health tracking per endpoint.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An EndpointPolicy chooses the order in which Session.Endpoints are tried.
+type EndpointPolicy int
+
+const (
+	// Failover tries the endpoints in order, so the first one is the
+	// primary and the others are only used when it is unreachable.
+	Failover EndpointPolicy = iota
+
+	// RoundRobin starts each request at the next endpoint in turn,
+	// distributing requests in proportion to Session.EndpointWeights.
+	// The selection is deterministic (smooth weighted round robin), so
+	// for weights 5, 1, 1 every seven requests go a, a, b, a, c, a, a.
+	RoundRobin
+)
+
+const defaultEjectFor = 10 * time.Second
+
+// endpointState tracks endpoint selection and health across requests.
+type endpointState struct {
+	mu      sync.Mutex
+	current int       // Sticky endpoint
+	since   time.Time // When current was chosen
+	health  []endpointHealth
+}
+
+// endpointHealth holds the statistics of one endpoint.
+type endpointHealth struct {
+	weight   int // Smooth weighted round robin counter
+	requests int64
+	failures int64
+	streak   int // Consecutive connection failures
+	ejected  bool
+}
+
+// EndpointStats describes the traffic and health of a Session endpoint.
+type EndpointStats struct {
+	URL      string
+	Requests int64 // Attempts sent to the endpoint
+	Failures int64 // Attempts which could not connect
+	Ejected  bool  // Currently ejected after EjectAfter failures
+}
+
+// EndpointStats returns statistics for each of s.Endpoints, in order.
+func (s *Session) EndpointStats() []EndpointStats {
+	st := &s.state().endpoints
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.grow(len(s.Endpoints))
+	stats := make([]EndpointStats, len(s.Endpoints))
+	for i, e := range s.Endpoints {
+		h := st.health[i]
+		stats[i] = EndpointStats{URL: e, Requests: h.requests, Failures: h.failures, Ejected: h.ejected}
+	}
+	return stats
+}
+
+// grow makes room for the health of n endpoints.  The caller holds st.mu.
+func (st *endpointState) grow(n int) {
+	if len(st.health) < n {
+		st.health = append(st.health, make([]endpointHealth, n-len(st.health))...)
+	}
+}
+
+// Endpoint returns the base URL of the Session endpoint which served the
+// request, or the URL which won Session.Race, or "" if neither was used.
+func (r *Response) Endpoint() string {
+	return r.endpoint
+}
+
+// isAbsURL reports whether raw is an absolute URL.
+func isAbsURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.IsAbs()
+}
+
+// endpointWeight returns the configured weight of endpoint idx.
+func (s *Session) endpointWeight(idx int) int {
+	if idx < len(s.EndpointWeights) && s.EndpointWeights[idx] > 0 {
+		return s.EndpointWeights[idx]
+	}
+	return 1
+}
+
+// endpointOrder returns the indexes of s.Endpoints in the order in which
+// they should be tried for the next request.  Ejected endpoints come last.
+func (s *Session) endpointOrder() []int {
+	st := &s.state().endpoints
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	n := len(s.Endpoints)
+	st.grow(n)
+	allEjected := true
+	for i := 0; i < n; i++ {
+		allEjected = allEjected && st.health[i].ejected
+	}
+	eligible := func(idx int) bool { return allEjected || !st.health[idx].ejected }
+	start := 0
+	switch s.EndpointPolicy {
+	case RoundRobin:
+		total, best := 0, -1
+		for i := 0; i < n; i++ {
+			if !eligible(i) {
+				continue
+			}
+			w := s.endpointWeight(i)
+			st.health[i].weight += w
+			total += w
+			if best < 0 || st.health[i].weight > st.health[best].weight {
+				best = i
+			}
+		}
+		st.health[best].weight -= total
+		start = best
+	default:
+		if s.Sticky && st.current < n {
+			if st.current != 0 && s.StickyCooldown > 0 && s.now().Sub(st.since) >= s.StickyCooldown {
+				st.current = 0 // Re-probe the primary
+			}
+			start = st.current
+		}
+		for !eligible(start) {
+			start = (start + 1) % n
+		}
+	}
+	order := make([]int, 0, n)
+	var ejected []int
+	for i := 0; i < n; i++ {
+		idx := i
+		if s.EndpointPolicy == RoundRobin {
+			idx = (start + i) % n
+		} else if i == 0 {
+			idx = start
+		} else if idx <= start {
+			idx--
+		}
+		if eligible(idx) {
+			order = append(order, idx)
+		} else {
+			ejected = append(ejected, idx)
+		}
+	}
+	return append(order, ejected...)
+}
+
+// endpointServed records that endpoint idx answered a request.
+func (s *Session) endpointServed(idx int) {
+	st := &s.state().endpoints
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.grow(len(s.Endpoints))
+	h := &st.health[idx]
+	h.requests++
+	h.streak = 0
+	h.ejected = false
+	if s.Sticky && s.EndpointPolicy == Failover && st.current != idx {
+		st.current = idx
+		st.since = s.now()
+	}
+}
+
+// endpointFailed records that endpoint idx could not be connected to, and
+// ejects it after s.EjectAfter consecutive failures.
+func (s *Session) endpointFailed(idx int) {
+	state := s.state()
+	st := &state.endpoints
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.grow(len(s.Endpoints))
+	h := &st.health[idx]
+	h.requests++
+	h.failures++
+	h.streak++
+	if s.EjectAfter > 0 && h.streak >= s.EjectAfter && !h.ejected {
+		h.ejected = true
+		go s.reprobe(state, idx)
+	}
+}
+
+// reprobe periodically sends a HEAD request to the base URL of ejected
+// endpoint idx, and re-admits it in state once any HTTP response is
+// received.  It stops when the session is Reset, which replaces state.
+func (s *Session) reprobe(state *sessionState, idx int) {
+	every := s.EjectFor
+	if every <= 0 {
+		every = defaultEjectFor
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-state.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	client := s.client(&Request{})
+	st := &state.endpoints
+	for {
+		if s.clock().Sleep(ctx, every) != nil {
+			return // The session was Reset
+		}
+		st.mu.Lock()
+		ejected := idx < len(st.health) && st.health[idx].ejected
+		st.mu.Unlock()
+		if !ejected || idx >= len(s.Endpoints) {
+			return // Re-admitted in the meantime, or no longer configured
+		}
+		pctx, pcancel := context.WithTimeout(ctx, every)
+		req, err := http.NewRequestWithContext(pctx, "HEAD", s.Endpoints[idx], nil)
+		if err != nil {
+			pcancel()
+			return
+		}
+		resp, err := client.Do(req)
+		pcancel()
+		if err == nil {
+			resp.Body.Close()
+			st.mu.Lock()
+			st.health[idx].ejected = false
+			st.health[idx].streak = 0
+			st.mu.Unlock()
+			return
+		}
+	}
+}
+
+// doEndpoints sends req, which was prepared against the first endpoint in
+// order, failing over to the following endpoints on connection errors.
+// HTTP error statuses are responses like any other and do not fail over.
+// Each following attempt starts from header, the headers of req before
+// the session hooks ran, and runs the hooks again for its own URL.
+func (s *Session) doEndpoints(client *http.Client, req *http.Request, header http.Header, r *Request, order []int) (*http.Response, error) {
+	var lastErr error
+	for i, idx := range order {
+		if i > 0 {
+			raw, err := s.normalizeURL(r.Url)
+			if err != nil {
+				return nil, err
+			}
+			u, err := s.resolveURL(raw, s.Endpoints[idx])
+			if err != nil {
+				return nil, err
+			}
+			u.RawQuery = req.URL.RawQuery
+			next, err := replay(req, u)
+			if err != nil {
+				return nil, lastErr
+			}
+			next.Header = header.Clone()
+			req = next
+			if err := s.hooks(r, req); err != nil {
+				return nil, err
+			}
+			r.attemptErrs = append(r.attemptErrs, s.proxyError(lastErr))
+		}
+		r.endpoint = s.Endpoints[idx]
+		resp, err := client.Do(req)
+		if err == nil {
+			s.endpointServed(idx)
+			return resp, nil
+		}
+		if !isConnError(err) {
+			return nil, err
+		}
+		s.endpointFailed(idx)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// An AttemptsError reports the failure of a request after several attempts,
+// e.g. at each of the session's Endpoints.  Errs holds the error of each
+// attempt in order; the final one, which may also be a failure to decode
+// the response, comes last.  errors.Is and errors.As match any of them.
+type AttemptsError struct {
+	Errs []error
+}
+
+func (e *AttemptsError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Errs[len(e.Errs)-1].Error())
+	fmt.Fprintf(&b, " (after %d attempts", len(e.Errs))
+	for i, err := range e.Errs[:len(e.Errs)-1] {
+		fmt.Fprintf(&b, "; attempt %d: %v", i+1, err)
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+func (e *AttemptsError) Unwrap() []error {
+	return e.Errs
+}
+
+// AttemptErrors returns the error of each attempt of a failed request, in
+// order.  It returns a single error if err is not an *AttemptsError, and
+// nil if err is nil.
+func AttemptErrors(err error) []error {
+	var ae *AttemptsError
+	if errors.As(err, &ae) {
+		return ae.Errs
+	}
+	if err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
+// isConnError reports whether err means that no connection to the server
+// could be established, so that the request certainly was not processed.
+func isConnError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
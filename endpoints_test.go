@@ -0,0 +1,273 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// deadURL returns the URL of a port on which nothing is listening.
+func deadURL(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return "http://" + addr
+}
+
+func handleEcho(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		w.Write([]byte(name + " " + req.URL.RequestURI() + " " + string(body)))
+	}
+}
+
+func TestEndpointFailover(t *testing.T) {
+	secondary := httptest.NewServer(handleEcho("secondary"))
+	defer secondary.Close()
+	dead := deadURL(t)
+	s := Session{Endpoints: []string{dead + "/api", secondary.URL + "/api/"}}
+	p := Params{"q": "1"}.AsUrlValues()
+	resp, err := s.Send(&Request{Url: "users?x=2", Method: "POST", Params: &p, Payload: payload{"bar"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `secondary /api/users?q=1&x=2 {"Foo":"bar"}`, resp.RawText())
+	assert.Equal(t, secondary.URL+"/api/", resp.Endpoint())
+
+	// Absolute URLs do not use the endpoints.
+	resp, err = s.Get(secondary.URL+"/abs", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "", resp.Endpoint())
+
+	// HTTP errors do not fail over.
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer primary.Close()
+	s = Session{Endpoints: []string{primary.URL, secondary.URL}}
+	resp, err = s.Get("/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 503, resp.Status())
+	assert.Equal(t, primary.URL, resp.Endpoint())
+
+	// All endpoints down.
+	s = Session{Endpoints: []string{dead, dead}}
+	_, err = s.Get("/", nil)
+	assert.True(t, isConnError(err))
+}
+
+func TestEndpointFailoverHooks(t *testing.T) {
+	var got http.Header
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = req.Header
+	}))
+	defer secondary.Close()
+	dead := deadURL(t)
+	var before, authorized []string
+	s := Session{
+		Endpoints: []string{dead, secondary.URL},
+		BeforeSend: func(r *Request, req *http.Request) error {
+			before = append(before, req.URL.Host)
+			req.Header.Add("X-Hosts", req.URL.Host)
+			return nil
+		},
+		Authorizer: func(req *http.Request) error {
+			authorized = append(authorized, req.URL.Host)
+			req.Header.Set("Authorization", "Sig "+req.URL.Host)
+			return nil
+		},
+	}
+	_, err := s.Get("/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := strings.TrimPrefix(secondary.URL, "http://")
+	want := []string{strings.TrimPrefix(dead, "http://"), host}
+	assert.Equal(t, want, before)
+	assert.Equal(t, want, authorized)
+	// Each attempt starts over from the headers set before the hooks ran.
+	assert.Equal(t, []string{host}, got.Values("X-Hosts"))
+	assert.Equal(t, "Sig "+host, got.Get("Authorization"))
+}
+
+func TestEndpointSticky(t *testing.T) {
+	secondary := httptest.NewServer(handleEcho("secondary"))
+	defer secondary.Close()
+	s := Session{
+		Endpoints:      []string{deadURL(t), secondary.URL},
+		Sticky:         true,
+		StickyCooldown: 50 * time.Millisecond,
+	}
+	c := newStubClock()
+	s.SetClock(c)
+	if _, err := s.Get("/", nil); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []int{1, 0}, s.endpointOrder())
+	c.Advance(49 * time.Millisecond)
+	assert.Equal(t, []int{1, 0}, s.endpointOrder())
+	c.Advance(time.Millisecond)
+	assert.Equal(t, []int{0, 1}, s.endpointOrder())
+
+	s.Sticky = false
+	s.st = nil
+	if _, err := s.Get("/", nil); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []int{0, 1}, s.endpointOrder())
+}
+
+func TestEndpointRoundRobin(t *testing.T) {
+	a := httptest.NewServer(handleEcho("a"))
+	defer a.Close()
+	b := httptest.NewServer(handleEcho("b"))
+	defer b.Close()
+	s := Session{Endpoints: []string{a.URL, b.URL}, EndpointPolicy: RoundRobin}
+	var served []string
+	for i := 0; i < 4; i++ {
+		resp, err := s.Get("/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		served = append(served, resp.RawText()[:1])
+	}
+	assert.Equal(t, []string{"a", "b", "a", "b"}, served)
+}
+
+func TestEndpointWeights(t *testing.T) {
+	s := Session{
+		Endpoints:       []string{"http://a", "http://b", "http://c"},
+		EndpointPolicy:  RoundRobin,
+		EndpointWeights: []int{5, 1, 1},
+	}
+	var starts []int
+	for i := 0; i < 14; i++ {
+		starts = append(starts, s.endpointOrder()[0])
+	}
+	assert.Equal(t, []int{0, 0, 1, 0, 2, 0, 0, 0, 0, 1, 0, 2, 0, 0}, starts)
+}
+
+func TestEndpointEjection(t *testing.T) {
+	dead := deadURL(t)
+	live := httptest.NewServer(handleEcho("live"))
+	defer live.Close()
+	s := Session{
+		Endpoints:      []string{dead, live.URL},
+		EndpointPolicy: RoundRobin,
+		EjectAfter:     2,
+		EjectFor:       20 * time.Millisecond,
+	}
+	for i := 0; i < 6; i++ {
+		resp, err := s.Get("/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, live.URL, resp.Endpoint())
+	}
+	stats := s.EndpointStats()
+	assert.Equal(t, EndpointStats{URL: dead, Requests: 2, Failures: 2, Ejected: true}, stats[0])
+	assert.Equal(t, EndpointStats{URL: live.URL, Requests: 6}, stats[1])
+	assert.Equal(t, []int{1, 0}, s.endpointOrder())
+
+	// Bring the dead endpoint back; the background probe re-admits it.
+	ln, err := net.Listen("tcp", dead[len("http://"):])
+	if err != nil {
+		t.Skip("port was reused:", err)
+	}
+	revived := httptest.NewUnstartedServer(handleEcho("revived"))
+	revived.Listener = ln
+	revived.Start()
+	defer revived.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for s.EndpointStats()[0].Ejected && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.False(t, s.EndpointStats()[0].Ejected)
+	var served []string
+	for i := 0; i < 2; i++ {
+		resp, err := s.Get("/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		served = append(served, resp.Endpoint())
+	}
+	assert.ElementsMatch(t, []string{dead, live.URL}, served)
+}
+
+func TestResetAfterEjection(t *testing.T) {
+	dead := deadURL(t)
+	live := httptest.NewServer(handleEcho("live"))
+	defer live.Close()
+	s := Session{
+		Endpoints:  []string{dead, live.URL},
+		EjectAfter: 1,
+		EjectFor:   5 * time.Millisecond,
+	}
+	if _, err := s.Get("/", nil); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, s.EndpointStats()[0].Ejected)
+
+	// The probe started by the ejection stops instead of reading the new,
+	// empty state, even if Reset comes before it runs.
+	s.Reset()
+	time.Sleep(20 * time.Millisecond)
+	before := runtime.NumGoroutine()
+	s.endpointFailed(0)
+	s.Reset()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, s.EndpointStats()[0].Ejected)
+}
+
+func TestAttemptsError(t *testing.T) {
+	srv := httptest.NewServer(handleStatusJSON(200, "<html>not json</html>"))
+	defer srv.Close()
+	s := Session{Endpoints: []string{deadURL(t), deadURL(t), srv.URL}}
+	var result map[string]interface{}
+	_, err := s.Send(&Request{Url: "/", Method: "GET", Result: &result})
+	errs := AttemptErrors(err)
+	if assert.Len(t, errs, 3) {
+		var opErr *net.OpError
+		assert.True(t, errors.As(err, &opErr))
+		var syntaxErr *json.SyntaxError
+		assert.True(t, errors.As(errs[2], &syntaxErr))
+		assert.True(t, strings.HasPrefix(err.Error(), errs[2].Error()+" (after 3 attempts; attempt 1: "))
+	}
+
+	s = Session{Endpoints: []string{deadURL(t), deadURL(t)}}
+	_, err = s.Get("/", nil)
+	assert.Len(t, AttemptErrors(err), 2)
+
+	err = &AttemptsError{Errs: []error{context.DeadlineExceeded, errors.New("503")}}
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, []error{io.EOF}, AttemptErrors(io.EOF))
+	assert.Nil(t, AttemptErrors(nil))
+}
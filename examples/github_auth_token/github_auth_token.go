@@ -91,7 +91,13 @@ func main() {
 	//
 	// Send request to server
 	//
-	resp, err := s.Post(url, &payload, &res, &e)
+	resp, err := s.Send(&napping.Request{
+		Url:     url,
+		Method:  "POST",
+		Payload: &payload,
+		Result:  &res,
+		Error:   &e,
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -40,7 +40,7 @@ func main() {
 	fmt.Println("URL:>", url)
 
 	res := ResponseUserAgent{}
-	resp, err := s.Get(url, nil, &res, nil)
+	resp, err := s.Send(&napping.Request{Url: url, Method: "GET", Result: &res})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -67,7 +67,7 @@ func main() {
 	p := napping.Params{"foo": "bar"}.AsUrlValues()
 
 	res = ResponseUserAgent{}
-	resp, err = s.Get(url, &p, &res, nil)
+	resp, err = s.Send(&napping.Request{Url: url, Method: "GET", Params: &p, Result: &res})
 	if err != nil {
 		log.Fatal(err)
 	}
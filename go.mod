@@ -1,6 +1,6 @@
 module github.com/yinyajiang/napping
 
-go 1.18
+go 1.20
 
 require (
 	github.com/howeyc/gopass v0.0.0-20210920133722-c8aef6fb66ef
@@ -0,0 +1,294 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module parses structured response headers.  Header values must never be
+split naively on commas: Set-Cookie dates and quoted Link titles contain
+commas which are not list separators.
+*/
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// splitList splits a header value on sep, ignoring separators inside quoted
+// strings and <URI-references>.  Members are trimmed and empty members are
+// dropped.
+func splitList(s string, sep byte) []string {
+	var out []string
+	start := 0
+	inQuote, inAngle, escaped := false, false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inQuote && c == '\\':
+			escaped = true
+		case c == '"' && !inAngle:
+			inQuote = !inQuote
+		case c == '<' && !inQuote:
+			inAngle = true
+		case c == '>' && !inQuote:
+			inAngle = false
+		case c == sep && !inQuote && !inAngle:
+			if m := strings.TrimSpace(s[start:i]); m != "" {
+				out = append(out, m)
+			}
+			start = i + 1
+		}
+	}
+	if m := strings.TrimSpace(s[start:]); m != "" {
+		out = append(out, m)
+	}
+	return out
+}
+
+// unquote removes the quotes and backslash escapes of an HTTP quoted-string.
+// Tokens are returned unchanged.
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	s = s[1 : len(s)-1]
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		buf.WriteByte(s[i])
+	}
+	return buf.String()
+}
+
+// parseParams parses ";"-separated name=value parameters.  Names are
+// lower-cased; the first occurrence of a name wins.
+func parseParams(members []string) map[string]string {
+	params := map[string]string{}
+	for _, m := range members {
+		name, value := m, ""
+		if i := strings.IndexByte(m, '='); i >= 0 {
+			name, value = m[:i], unquote(strings.TrimSpace(m[i+1:]))
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, dup := params[name]; !dup && name != "" {
+			params[name] = value
+		}
+	}
+	return params
+}
+
+// A Link is one link from an RFC 8288 Link header.
+type Link struct {
+	URL    string            // Target URI, as sent by the server
+	Rel    string            // Relation types, space-separated
+	Params map[string]string // All parameters, including rel, by lower-cased name
+}
+
+// HasRel reports whether the link has relation type rel, e.g. "next".
+func (l Link) HasRel(rel string) bool {
+	for _, r := range strings.Fields(l.Rel) {
+		if strings.EqualFold(r, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseLinks parses the values of one or more Link headers.  Malformed
+// links are skipped.
+func ParseLinks(values []string) []Link {
+	var links []Link
+	for _, v := range values {
+		for _, member := range splitList(v, ',') {
+			parts := splitList(member, ';')
+			if len(parts) == 0 {
+				continue
+			}
+			target := parts[0]
+			if len(target) < 2 || target[0] != '<' || target[len(target)-1] != '>' {
+				continue
+			}
+			params := parseParams(parts[1:])
+			links = append(links, Link{
+				URL:    target[1 : len(target)-1],
+				Rel:    params["rel"],
+				Params: params,
+			})
+		}
+	}
+	return links
+}
+
+// Links returns the links from the Link headers of the server's response.
+func (r *Response) Links() []Link {
+	if r.response == nil {
+		return nil
+	}
+	return ParseLinks(r.response.Header.Values("Link"))
+}
+
+// A RateLimit describes the rate limit state advertised by a server.
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time // Zero if the server did not say
+}
+
+// RateLimit returns the rate limit advertised by the server's response,
+// using either the RateLimit-* fields of the IETF draft or the common
+// X-RateLimit-* headers.  Reset may be sent as delta-seconds or, in the
+// X- variant, as a Unix timestamp.  ok is false if no limit was given.
+func (r *Response) RateLimit() (rl RateLimit, ok bool) {
+	if r.response == nil {
+		return
+	}
+	h := r.response.Header
+	for _, prefix := range []string{"RateLimit-", "X-RateLimit-"} {
+		limit, okLimit := firstInt(h.Get(prefix + "Limit"))
+		remaining, okRemaining := firstInt(h.Get(prefix + "Remaining"))
+		if !okLimit && !okRemaining {
+			continue
+		}
+		rl = RateLimit{Limit: limit, Remaining: remaining}
+		if reset, okReset := firstInt(h.Get(prefix + "Reset")); okReset {
+			// Values too large to be a delay are taken as Unix timestamps.
+			if reset > 1e9 {
+				rl.Reset = time.Unix(int64(reset), 0)
+			} else {
+				rl.Reset = r.timestamp.Add(time.Duration(reset) * time.Second)
+			}
+		}
+		return rl, true
+	}
+	return
+}
+
+// firstInt parses the leading integer of a header list such as
+// "100, 100;w=60".
+func firstInt(v string) (int, bool) {
+	members := splitList(v, ',')
+	if len(members) == 0 {
+		return 0, false
+	}
+	item := splitList(members[0], ';')
+	if len(item) == 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(item[0])
+	return n, err == nil
+}
+
+// parseHTTPDate parses an HTTP date in any of the three formats allowed by
+// RFC 7231: IMF-fixdate, RFC 850 and asctime.  ok is false if v is empty or
+// malformed.
+func parseHTTPDate(v string) (t time.Time, ok bool) {
+	t, err := http.ParseTime(strings.TrimSpace(v))
+	return t, err == nil
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date.  A date is taken relative to the Date header, if
+// any, else to now, so that clock skew does not matter.  Dates in the past
+// give zero.  ok is false if the header is missing or malformed.
+func parseRetryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if secs < 0 || secs > int64(math.MaxInt64/time.Second) {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, ok := parseHTTPDate(v)
+	if !ok {
+		return 0, false
+	}
+	if date, ok := parseHTTPDate(h.Get("Date")); ok {
+		now = date
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// headerDate returns the date in header name of the response.
+func (r *Response) headerDate(name string) (time.Time, bool) {
+	if r.response == nil {
+		return time.Time{}, false
+	}
+	return parseHTTPDate(r.response.Header.Get(name))
+}
+
+// Date returns the time given by the Date header of the response.  ok is
+// false if the header is missing or malformed.
+func (r *Response) Date() (time.Time, bool) {
+	return r.headerDate("Date")
+}
+
+// LastModified returns the time given by the Last-Modified header of the
+// response.  ok is false if the header is missing or malformed.
+func (r *Response) LastModified() (time.Time, bool) {
+	return r.headerDate("Last-Modified")
+}
+
+// Expires returns the time given by the Expires header of the response.  ok
+// is false if the header is missing or malformed, such as "0", which caches
+// treat as already expired.
+func (r *Response) Expires() (time.Time, bool) {
+	return r.headerDate("Expires")
+}
+
+// RetryAfter returns how long the server asked the client to wait with the
+// Retry-After header, given either in seconds or as a date.  ok is false if
+// the header is missing or malformed.
+func (r *Response) RetryAfter() (time.Duration, bool) {
+	if r.response == nil {
+		return 0, false
+	}
+	now := time.Now()
+	if r.clk != nil {
+		now = r.clk.Now()
+	}
+	return parseRetryAfter(r.response.Header, now)
+}
+
+// ServerTime returns the time given by the Date header of the response, like
+// Date.
+func (r *Response) ServerTime() (t time.Time, ok bool) {
+	return r.Date()
+}
+
+// ClockSkew returns how far the server's clock is ahead of the local clock,
+// comparing the Date header with the time the request was sent.  The result
+// is negative if the server is behind, and zero if it sent no Date.  Date has
+// a resolution of one second, so small skews cannot be detected.
+func (r *Response) ClockSkew() time.Duration {
+	t, ok := r.ServerTime()
+	if !ok {
+		return 0
+	}
+	return t.Sub(r.timestamp.Truncate(time.Second))
+}
+
+// Location returns the URL in the Location header of the response, resolved
+// against the URL of the request if it is relative.  It returns
+// http.ErrNoLocation if the header is missing.
+func (r *Response) Location() (*url.URL, error) {
+	if r.response == nil {
+		return nil, http.ErrNoLocation
+	}
+	return r.response.Location()
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLinks(t *testing.T) {
+	links := ParseLinks([]string{
+		`<https://api.example.com/items?page=2&sort=a,b>; rel="next"; title="Page 2, the sequel; really", <https://api.example.com/items?page=9>; rel=last`,
+		`</items?page=1>;rel="first prev" ;title="say \"hi\""`,
+		`garbage, <>; rel=self`,
+	})
+	if !assert.Len(t, links, 4) {
+		return
+	}
+	assert.Equal(t, "https://api.example.com/items?page=2&sort=a,b", links[0].URL)
+	assert.Equal(t, "Page 2, the sequel; really", links[0].Params["title"])
+	assert.True(t, links[0].HasRel("next"))
+	assert.Equal(t, "last", links[1].Rel)
+	assert.True(t, links[2].HasRel("prev"))
+	assert.True(t, links[2].HasRel("FIRST"))
+	assert.Equal(t, `say "hi"`, links[2].Params["title"])
+	assert.Equal(t, "", links[3].URL)
+}
+
+func TestHeaderHelpersFromServer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		h := w.Header()
+		h.Add("Set-Cookie", "a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT; Path=/")
+		h.Add("Set-Cookie", "b=2; Expires=Thu, 22 Oct 2015 07:28:00 GMT")
+		h.Add("Link", `<http://x/?p=2>; rel="next", <http://x/?p=5>; rel="last"`)
+		h.Add("Link", `<http://x/?p=1>; rel="first"; title="one, first"`)
+		h.Set("RateLimit-Limit", "100, 100;w=60, 1000;w=3600")
+		h.Set("RateLimit-Remaining", "42")
+		h.Set("RateLimit-Reset", "30")
+	}))
+	defer srv.Close()
+	resp, err := Get(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := resp.SetCookies()
+	if assert.Len(t, cookies, 2) {
+		assert.Equal(t, "1", cookies[0].Value)
+		assert.Equal(t, 21, cookies[0].Expires.Day())
+		assert.Equal(t, "2", cookies[1].Value)
+	}
+	links := resp.Links()
+	if assert.Len(t, links, 3) {
+		assert.Equal(t, "one, first", links[2].Params["title"])
+	}
+	rl, ok := resp.RateLimit()
+	assert.True(t, ok)
+	assert.Equal(t, 100, rl.Limit)
+	assert.Equal(t, 42, rl.Remaining)
+	assert.Equal(t, resp.Timestamp().Add(30*time.Second), rl.Reset)
+}
+
+func TestFoldedLinkHeader(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 4096)
+		conn.Read(buf)
+		conn.Write([]byte("HTTP/1.1 200 OK\r\n" +
+			"Link: <http://x/?p=2>; rel=\"next\",\r\n" +
+			" <http://x/?p=3>; rel=\"last\"\r\n" +
+			"X-RateLimit-Limit: 60\r\n" +
+			"X-RateLimit-Remaining: 0\r\n" +
+			"X-RateLimit-Reset: 1700000000\r\n" +
+			"Content-Length: 0\r\nConnection: close\r\n\r\n"))
+	}()
+	resp, err := Get("http://"+ln.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := resp.Links()
+	if assert.Len(t, links, 2) {
+		assert.Equal(t, "http://x/?p=3", links[1].URL)
+		assert.True(t, links[1].HasRel("last"))
+	}
+	rl, ok := resp.RateLimit()
+	assert.True(t, ok)
+	assert.Equal(t, RateLimit{Limit: 60, Remaining: 0, Reset: time.Unix(1700000000, 0)}, rl)
+}
+
+func TestClockSkew(t *testing.T) {
+	sent := time.Date(2015, 10, 21, 7, 28, 0, 400e6, time.UTC)
+	r := Response{
+		timestamp: sent,
+		response:  &http.Response{Header: http.Header{"Date": {"Wed, 21 Oct 2015 07:30:30 GMT"}}},
+	}
+	st, ok := r.ServerTime()
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2015, 10, 21, 7, 30, 30, 0, time.UTC), st)
+	assert.Equal(t, 150*time.Second, r.ClockSkew())
+
+	r.response.Header.Set("Date", "Wed, 21 Oct 2015 07:27:00 GMT")
+	assert.Equal(t, -time.Minute, r.ClockSkew())
+
+	r.response.Header.Set("Date", "yesterday")
+	_, ok = r.ServerTime()
+	assert.False(t, ok)
+	assert.Equal(t, time.Duration(0), r.ClockSkew())
+}
+
+func TestLocation(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/abs" {
+			w.Header().Set("Location", "https://other.test/x")
+		} else if req.URL.Path != "/none" {
+			w.Header().Set("Location", "items/42")
+		}
+		w.WriteHeader(201)
+	}))
+	defer srv.Close()
+
+	resp, err := Get(srv.URL+"/api/", nil)
+	assert.NoError(t, err)
+	loc, err := resp.Location()
+	assert.NoError(t, err)
+	assert.Equal(t, srv.URL+"/api/items/42", loc.String())
+
+	resp, _ = Get(srv.URL+"/abs", nil)
+	loc, _ = resp.Location()
+	assert.Equal(t, "https://other.test/x", loc.String())
+
+	resp, _ = Get(srv.URL+"/none", nil)
+	_, err = resp.Location()
+	assert.Equal(t, http.ErrNoLocation, err)
+}
+
+func TestDateHeaders(t *testing.T) {
+	want := time.Date(1994, 11, 6, 8, 49, 37, 0, time.UTC)
+	for _, v := range []string{
+		"Sun, 06 Nov 1994 08:49:37 GMT",  // IMF-fixdate
+		"Sunday, 06-Nov-94 08:49:37 GMT", // RFC 850
+		"Sun Nov  6 08:49:37 1994",       // asctime
+	} {
+		r := Response{response: &http.Response{Header: http.Header{
+			"Date": {v}, "Last-Modified": {v}, "Expires": {v},
+		}}}
+		for _, get := range []func() (time.Time, bool){r.Date, r.LastModified, r.Expires} {
+			got, ok := get()
+			assert.True(t, ok, v)
+			assert.True(t, want.Equal(got), v)
+		}
+	}
+
+	r := Response{response: &http.Response{Header: http.Header{"Expires": {"0"}}}}
+	_, ok := r.Expires()
+	assert.False(t, ok)
+	_, ok = r.LastModified()
+	assert.False(t, ok)
+	_, ok = (&Response{}).Date()
+	assert.False(t, ok)
+}
+
+func TestRetryAfter(t *testing.T) {
+	h := func(kv ...string) *Response {
+		hdr := http.Header{}
+		for i := 0; i < len(kv); i += 2 {
+			hdr.Set(kv[i], kv[i+1])
+		}
+		return &Response{response: &http.Response{Header: hdr}}
+	}
+	d, ok := h("Retry-After", "120").RetryAfter()
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Minute, d)
+
+	// Dates are relative to the server's Date.
+	d, ok = h("Retry-After", "Sun, 06 Nov 1994 08:50:07 GMT", "Date", "Sun, 06 Nov 1994 08:49:37 GMT").RetryAfter()
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, d)
+	d, ok = h("Retry-After", "Sun, 06 Nov 1994 08:50:07 GMT").RetryAfter()
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), d)
+
+	for _, v := range []string{"", "-1", "soon", "1.5"} {
+		_, ok = h("Retry-After", v).RetryAfter()
+		assert.False(t, ok, v)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module keeps headers which must not leave the service, such as internal
+credentials, out of requests to hosts which may not see them.
+*/
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// PolicyMode selects what a HeaderPolicy does with a forbidden header.
+type PolicyMode int
+
+const (
+	PolicyStrip PolicyMode = iota // Remove the header silently
+	PolicyFail                    // Fail the request with a *HeaderPolicyError
+)
+
+// A HeaderRule restricts the headers sent to the hosts matching any of
+// Hosts.  A pattern is a host name, "*.example.com" for the subdomains of
+// example.com, or "*" for all hosts; ports are ignored.  Headers listed in
+// Deny are forbidden, and if Allow is not empty, so are all headers it does
+// not list.  Header names are case-insensitive.
+type HeaderRule struct {
+	Hosts []string
+	Allow []string
+	Deny  []string
+}
+
+// A HeaderPolicy is applied to each request as the last step before it is
+// sent, so it also covers the headers napping adds itself, such as Accept,
+// User-Agent, Content-Type and Authorization; an allowlist must name them
+// to keep them.  A stripped User-Agent is not replaced by the net/http
+// default.  Headers added by the transport, such as Host, Content-Length
+// and Accept-Encoding: gzip, are beyond its reach.  The policy is applied
+// again to each redirect and to each endpoint tried, for their host.  All
+// rules matching the host apply.
+type HeaderPolicy struct {
+	Rules []HeaderRule
+	Mode  PolicyMode
+}
+
+// A HeaderPolicyError reports headers which the session's HeaderPolicy
+// forbids sending to Host.
+type HeaderPolicyError struct {
+	Host    string
+	Headers []string
+}
+
+func (e *HeaderPolicyError) Error() string {
+	return fmt.Sprintf("napping: HeaderPolicy forbids sending %s to host %s", strings.Join(e.Headers, ", "), e.Host)
+}
+
+// matchHost reports whether host matches pattern; see HeaderRule.
+func matchHost(pattern, host string) bool {
+	pattern = strings.ToLower(pattern)
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	switch {
+	case pattern == "*":
+		return true
+	case strings.HasPrefix(pattern, "*."):
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return pattern == host
+}
+
+// containsHeader reports whether list names header, ignoring case.
+func containsHeader(list []string, header string) bool {
+	for _, h := range list {
+		if strings.EqualFold(h, header) {
+			return true
+		}
+	}
+	return false
+}
+
+// forbidden reports whether rule forbids sending header.
+func (rule *HeaderRule) forbidden(header string) bool {
+	if containsHeader(rule.Deny, header) {
+		return true
+	}
+	return len(rule.Allow) > 0 && !containsHeader(rule.Allow, header)
+}
+
+// apply enforces p on header h of a request to host.  It returns the names
+// of the headers it removed, sorted, or an error in PolicyFail mode.  A nil
+// policy does nothing.
+func (p *HeaderPolicy) apply(host string, h http.Header) ([]string, error) {
+	if p == nil {
+		return nil, nil
+	}
+	var rules []*HeaderRule
+	for i := range p.Rules {
+		for _, pattern := range p.Rules[i].Hosts {
+			if matchHost(pattern, host) {
+				rules = append(rules, &p.Rules[i])
+				break
+			}
+		}
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	var bad []string
+	for k, v := range h {
+		if k == "User-Agent" && len(v) == 1 && v[0] == "" {
+			continue // Suppresses the net/http default; nothing is sent
+		}
+		for _, rule := range rules {
+			if rule.forbidden(k) {
+				bad = append(bad, k)
+				break
+			}
+		}
+	}
+	if len(bad) == 0 {
+		return nil, nil
+	}
+	sort.Strings(bad)
+	if p.Mode == PolicyFail {
+		return nil, &HeaderPolicyError{Host: host, Headers: bad}
+	}
+	for _, k := range bad {
+		delete(h, k)
+		if http.CanonicalHeaderKey(k) == "User-Agent" {
+			h["User-Agent"] = []string{""}
+		}
+	}
+	return bad, nil
+}
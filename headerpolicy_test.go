@@ -0,0 +1,94 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchHost(t *testing.T) {
+	assert.True(t, matchHost("*", "anything.test"))
+	assert.True(t, matchHost("api.example.com", "API.example.com."))
+	assert.True(t, matchHost("*.example.com", "a.b.example.com"))
+	assert.False(t, matchHost("*.example.com", "example.com"))
+	assert.False(t, matchHost("*.example.com", "badexample.com"))
+	assert.False(t, matchHost("example.com", "api.example.com"))
+}
+
+// echoHeaders answers with the request headers as JSON.
+func echoHeaders(w http.ResponseWriter, req *http.Request) {
+	json.NewEncoder(w).Encode(req.Header)
+}
+
+func TestHeaderPolicy(t *testing.T) {
+	external := httptest.NewServer(http.HandlerFunc(echoHeaders))
+	defer external.Close()
+	externalURL := strings.Replace(external.URL, "127.0.0.1", "localhost", 1)
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/out" {
+			http.Redirect(w, req, externalURL, http.StatusFound)
+			return
+		}
+		echoHeaders(w, req)
+	}))
+	defer internal.Close()
+
+	var l bufLogger
+	h := http.Header{}
+	h.Set("X-Internal-Auth", "secret")
+	h.Set("X-Debug", "1")
+	s := Session{
+		Header: &h,
+		HeaderPolicy: &HeaderPolicy{Rules: []HeaderRule{
+			{Hosts: []string{"*"}, Deny: []string{"x-debug"}},
+			{Hosts: []string{"localhost", "*.example.com"}, Allow: []string{"Accept", "X-Debug"}},
+		}},
+		Log:    true,
+		Logger: &l,
+	}
+	var got http.Header
+	_, err := s.Send(&Request{Url: internal.URL, Method: "GET", Result: &got})
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", got.Get("X-Internal-Auth"))
+	assert.Equal(t, "", got.Get("X-Debug"))
+	assert.NotEqual(t, "", got.Get("User-Agent"))
+	assert.Contains(t, l.String(), "X-Debug: [removed by HeaderPolicy]")
+
+	// Only Accept is left for the external host, including after a
+	// redirect; the net/http User-Agent is not sent either.
+	for _, u := range []string{externalURL, internal.URL + "/out"} {
+		got = nil
+		_, err = s.Send(&Request{Url: u, Method: "POST", Payload: map[string]int{"n": 1}, Result: &got})
+		assert.NoError(t, err)
+		assert.Equal(t, "", got.Get("X-Internal-Auth"), u)
+		assert.Equal(t, "", got.Get("User-Agent"), u)
+		assert.Equal(t, "*/*", got.Get("Accept"), u)
+	}
+
+	s.HeaderPolicy.Mode = PolicyFail
+	_, err = s.Get(externalURL, nil)
+	var pe *HeaderPolicyError
+	assert.True(t, errors.As(err, &pe))
+	assert.Equal(t, []string{"User-Agent", "X-Debug", "X-Internal-Auth"}, pe.Headers)
+	assert.EqualError(t, err, "napping: HeaderPolicy forbids sending User-Agent, X-Debug, X-Internal-Auth to host localhost")
+
+	// Only the redirect to the external host fails.
+	h.Del("X-Debug")
+	_, err = s.Get(internal.URL, nil)
+	assert.NoError(t, err)
+	pe = nil
+	_, err = s.Get(internal.URL+"/out", nil)
+	if assert.True(t, errors.As(err, &pe)) {
+		assert.Equal(t, "localhost", pe.Host)
+	}
+}
@@ -0,0 +1,494 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+// Package jsonschema validates JSON documents against JSON Schema, for use
+// as napping.Request.Schema.
+package jsonschema
+
+/*
+This module implements the validation keywords of JSON Schema draft 7 which
+contract tests commonly rely on:
+
+	type, enum, const
+	properties, required, additionalProperties, minProperties, maxProperties
+	items, minItems, maxItems, uniqueItems
+	minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf
+	minLength, maxLength, pattern
+	allOf, anyOf, oneOf, not
+	$ref to definitions within the same document
+
+Other keywords, including format, are ignored.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yinyajiang/napping"
+)
+
+// A Schema is a compiled JSON Schema.  It is immutable, so one Schema may
+// validate any number of documents concurrently.
+type Schema struct {
+	root *node
+}
+
+var _ napping.SchemaValidator = (*Schema)(nil)
+
+// node is a compiled schema or subschema.
+type node struct {
+	always *bool // Set for the boolean schemas true and false
+
+	types    []string
+	enum     []interface{}
+	constant interface{}
+	hasConst bool
+
+	properties    map[string]*node
+	required      []string
+	additional    *node
+	minProperties int
+	maxProperties int
+
+	items       *node
+	minItems    int
+	maxItems    int
+	uniqueItems bool
+
+	minimum, maximum                   *float64
+	exclusiveMinimum, exclusiveMaximum *float64
+	multipleOf                         *float64
+
+	minLength int
+	maxLength int
+	pattern   *regexp.Regexp
+
+	allOf, anyOf, oneOf []*node
+	not                 *node
+	ref                 *node
+}
+
+// Compile parses a JSON Schema document.
+func Compile(schema []byte) (*Schema, error) {
+	var doc interface{}
+	if err := json.Unmarshal(schema, &doc); err != nil {
+		return nil, fmt.Errorf("jsonschema: %w", err)
+	}
+	c := compiler{doc: doc, nodes: map[string]*node{}}
+	root, err := c.compile(doc, "")
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{root: root}, nil
+}
+
+// MustCompile is Compile, but panics if the schema is invalid.
+func MustCompile(schema []byte) *Schema {
+	s, err := Compile(schema)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Validate checks a JSON document against the schema, returning every
+// violation found.
+func (s *Schema) Validate(body []byte) []napping.SchemaViolation {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return []napping.SchemaViolation{{Message: "invalid JSON: " + err.Error()}}
+	}
+	var v []napping.SchemaViolation
+	s.root.validate(doc, "", &v)
+	return v
+}
+
+// compiler compiles the subschemas of doc, keyed by JSON pointer so that
+// each $ref target is compiled once, even if it refers to itself.
+type compiler struct {
+	doc   interface{}
+	nodes map[string]*node
+}
+
+func (c *compiler) compile(raw interface{}, ptr string) (*node, error) {
+	if n, ok := c.nodes[ptr]; ok {
+		return n, nil
+	}
+	n := &node{minProperties: -1, maxProperties: -1, minItems: -1, maxItems: -1, minLength: -1, maxLength: -1}
+	c.nodes[ptr] = n
+	if b, ok := raw.(bool); ok {
+		n.always = &b
+		return n, nil
+	}
+	m, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("jsonschema: %s: schema must be an object or boolean", where(ptr))
+	}
+	var err error
+	sub := func(key string) (*node, error) {
+		v, ok := m[key]
+		if !ok {
+			return nil, nil
+		}
+		return c.compile(v, ptr+"/"+escape(key))
+	}
+	subs := func(key string) ([]*node, error) {
+		v, ok := m[key]
+		if !ok {
+			return nil, nil
+		}
+		list, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("jsonschema: %s/%s: must be an array", where(ptr), key)
+		}
+		var out []*node
+		for i, s := range list {
+			n, err := c.compile(s, ptr+"/"+key+"/"+strconv.Itoa(i))
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, n)
+		}
+		return out, nil
+	}
+
+	if ref, ok := m["$ref"].(string); ok {
+		if !strings.HasPrefix(ref, "#") {
+			return nil, fmt.Errorf("jsonschema: %s: only local $ref is supported, not %q", where(ptr), ref)
+		}
+		target, err := resolve(c.doc, ref[1:])
+		if err != nil {
+			return nil, fmt.Errorf("jsonschema: %s: %w", where(ptr), err)
+		}
+		if n.ref, err = c.compile(target, ref[1:]); err != nil {
+			return nil, err
+		}
+		// In draft 7, keywords beside $ref are ignored.
+		return n, nil
+	}
+
+	switch t := m["type"].(type) {
+	case string:
+		n.types = []string{t}
+	case []interface{}:
+		for _, v := range t {
+			if s, ok := v.(string); ok {
+				n.types = append(n.types, s)
+			}
+		}
+	}
+	if e, ok := m["enum"].([]interface{}); ok {
+		n.enum = e
+	}
+	n.constant, n.hasConst = m["const"]
+
+	if props, ok := m["properties"].(map[string]interface{}); ok {
+		n.properties = map[string]*node{}
+		for k, v := range props {
+			if n.properties[k], err = c.compile(v, ptr+"/properties/"+escape(k)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if req, ok := m["required"].([]interface{}); ok {
+		for _, v := range req {
+			if s, ok := v.(string); ok {
+				n.required = append(n.required, s)
+			}
+		}
+	}
+	if n.additional, err = sub("additionalProperties"); err != nil {
+		return nil, err
+	}
+	if n.items, err = sub("items"); err != nil {
+		return nil, err
+	}
+	if n.not, err = sub("not"); err != nil {
+		return nil, err
+	}
+	if n.allOf, err = subs("allOf"); err != nil {
+		return nil, err
+	}
+	if n.anyOf, err = subs("anyOf"); err != nil {
+		return nil, err
+	}
+	if n.oneOf, err = subs("oneOf"); err != nil {
+		return nil, err
+	}
+
+	ints := map[string]*int{
+		"minProperties": &n.minProperties, "maxProperties": &n.maxProperties,
+		"minItems": &n.minItems, "maxItems": &n.maxItems,
+		"minLength": &n.minLength, "maxLength": &n.maxLength,
+	}
+	for k, p := range ints {
+		if f, ok := m[k].(float64); ok {
+			*p = int(f)
+		}
+	}
+	floats := map[string]**float64{
+		"minimum": &n.minimum, "maximum": &n.maximum,
+		"exclusiveMinimum": &n.exclusiveMinimum, "exclusiveMaximum": &n.exclusiveMaximum,
+		"multipleOf": &n.multipleOf,
+	}
+	for k, p := range floats {
+		if f, ok := m[k].(float64); ok {
+			*p = &f
+		}
+	}
+	n.uniqueItems, _ = m["uniqueItems"].(bool)
+	if p, ok := m["pattern"].(string); ok {
+		if n.pattern, err = regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("jsonschema: %s/pattern: %w", where(ptr), err)
+		}
+	}
+	return n, nil
+}
+
+// resolve returns the value at JSON pointer ptr in doc.
+func resolve(doc interface{}, ptr string) (interface{}, error) {
+	if ptr == "" {
+		return doc, nil
+	}
+	if ptr[0] != '/' {
+		return nil, fmt.Errorf("invalid JSON pointer %q", ptr)
+	}
+	v := doc
+	for _, tok := range strings.Split(ptr[1:], "/") {
+		tok = strings.NewReplacer("~1", "/", "~0", "~").Replace(tok)
+		switch t := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = t[tok]; !ok {
+				return nil, fmt.Errorf("$ref target %q not found", ptr)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(tok)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil, fmt.Errorf("$ref target %q not found", ptr)
+			}
+			v = t[i]
+		default:
+			return nil, fmt.Errorf("$ref target %q not found", ptr)
+		}
+	}
+	return v, nil
+}
+
+// escape escapes a JSON pointer token.
+func escape(tok string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(tok)
+}
+
+func where(ptr string) string {
+	if ptr == "" {
+		return "(root)"
+	}
+	return ptr
+}
+
+// validate appends the violations of v, found at ptr, to out.
+func (n *node) validate(v interface{}, ptr string, out *[]napping.SchemaViolation) {
+	fail := func(format string, args ...interface{}) {
+		*out = append(*out, napping.SchemaViolation{Pointer: ptr, Message: fmt.Sprintf(format, args...)})
+	}
+	if n.always != nil {
+		if !*n.always {
+			fail("no value is allowed here")
+		}
+		return
+	}
+	if n.ref != nil {
+		n.ref.validate(v, ptr, out)
+		return
+	}
+
+	if len(n.types) > 0 {
+		ok := false
+		for _, t := range n.types {
+			if hasType(v, t) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			fail("expected %s, got %s", strings.Join(n.types, " or "), typeOf(v))
+			return
+		}
+	}
+	if n.enum != nil {
+		ok := false
+		for _, e := range n.enum {
+			if reflect.DeepEqual(e, v) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			fail("must be one of %s", compact(n.enum))
+		}
+	}
+	if n.hasConst && !reflect.DeepEqual(n.constant, v) {
+		fail("must be %s", compact(n.constant))
+	}
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		n.validateObject(t, ptr, out, fail)
+	case []interface{}:
+		if n.minItems >= 0 && len(t) < n.minItems {
+			fail("must have at least %d items", n.minItems)
+		}
+		if n.maxItems >= 0 && len(t) > n.maxItems {
+			fail("must have at most %d items", n.maxItems)
+		}
+		if n.uniqueItems {
+			for i := range t {
+				for j := 0; j < i; j++ {
+					if reflect.DeepEqual(t[i], t[j]) {
+						fail("items %d and %d are equal", j, i)
+					}
+				}
+			}
+		}
+		if n.items != nil {
+			for i, item := range t {
+				n.items.validate(item, ptr+"/"+strconv.Itoa(i), out)
+			}
+		}
+	case float64:
+		switch {
+		case n.minimum != nil && t < *n.minimum:
+			fail("must be >= %v", *n.minimum)
+		case n.maximum != nil && t > *n.maximum:
+			fail("must be <= %v", *n.maximum)
+		case n.exclusiveMinimum != nil && t <= *n.exclusiveMinimum:
+			fail("must be > %v", *n.exclusiveMinimum)
+		case n.exclusiveMaximum != nil && t >= *n.exclusiveMaximum:
+			fail("must be < %v", *n.exclusiveMaximum)
+		}
+		if n.multipleOf != nil {
+			if q := t / *n.multipleOf; q != math.Trunc(q) {
+				fail("must be a multiple of %v", *n.multipleOf)
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(t)
+		if n.minLength >= 0 && length < n.minLength {
+			fail("must be at least %d characters long", n.minLength)
+		}
+		if n.maxLength >= 0 && length > n.maxLength {
+			fail("must be at most %d characters long", n.maxLength)
+		}
+		if n.pattern != nil && !n.pattern.MatchString(t) {
+			fail("must match pattern %q", n.pattern)
+		}
+	}
+
+	for _, s := range n.allOf {
+		s.validate(v, ptr, out)
+	}
+	if len(n.anyOf) > 0 && matches(n.anyOf, v) == 0 {
+		fail("must match at least one schema of anyOf")
+	}
+	if len(n.oneOf) > 0 {
+		if c := matches(n.oneOf, v); c != 1 {
+			fail("must match exactly one schema of oneOf, matched %d", c)
+		}
+	}
+	if n.not != nil && n.not.valid(v) {
+		fail("must not match the schema of not")
+	}
+}
+
+func (n *node) validateObject(obj map[string]interface{}, ptr string, out *[]napping.SchemaViolation, fail func(string, ...interface{})) {
+	for _, k := range n.required {
+		if _, ok := obj[k]; !ok {
+			fail("missing required property %q", k)
+		}
+	}
+	if n.minProperties >= 0 && len(obj) < n.minProperties {
+		fail("must have at least %d properties", n.minProperties)
+	}
+	if n.maxProperties >= 0 && len(obj) > n.maxProperties {
+		fail("must have at most %d properties", n.maxProperties)
+	}
+	// Sorted keys keep the violations in a stable order.
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if p, ok := n.properties[k]; ok {
+			p.validate(obj[k], ptr+"/"+escape(k), out)
+		} else if n.additional != nil {
+			n.additional.validate(obj[k], ptr+"/"+escape(k), out)
+		}
+	}
+}
+
+// valid reports whether v satisfies n.
+func (n *node) valid(v interface{}) bool {
+	var out []napping.SchemaViolation
+	n.validate(v, "", &out)
+	return len(out) == 0
+}
+
+// matches counts the schemas which v satisfies.
+func matches(schemas []*node, v interface{}) int {
+	c := 0
+	for _, s := range schemas {
+		if s.valid(v) {
+			c++
+		}
+	}
+	return c
+}
+
+func hasType(v interface{}, t string) bool {
+	switch t {
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	}
+	return typeOf(v) == t
+}
+
+func typeOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+// compact formats v as JSON for messages.
+func compact(v interface{}) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+	return strings.TrimSpace(buf.String())
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package jsonschema
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinyajiang/napping"
+)
+
+var userSchema = MustCompile([]byte(`{
+	"type": "object",
+	"required": ["id", "name", "tags"],
+	"properties": {
+		"id": {"type": "integer", "minimum": 1},
+		"name": {"type": "string", "minLength": 1, "maxLength": 8},
+		"email": {"type": "string", "pattern": "^[^@]+@[^@]+$"},
+		"role": {"enum": ["admin", "user"]},
+		"tags": {"type": "array", "items": {"$ref": "#/definitions/tag"}, "uniqueItems": true},
+		"manager": {"anyOf": [{"type": "null"}, {"$ref": "#"}]}
+	},
+	"additionalProperties": false,
+	"definitions": {
+		"tag": {"type": "string", "pattern": "^[a-z]+$"}
+	}
+}`))
+
+func violations(body string) []napping.SchemaViolation {
+	return userSchema.Validate([]byte(body))
+}
+
+func TestValidate(t *testing.T) {
+	assert.Empty(t, violations(`{"id": 1, "name": "ann", "tags": ["a"], "manager": {"id": 2, "name": "bob", "tags": []}}`))
+
+	assert.Equal(t, []napping.SchemaViolation{
+		{Pointer: "", Message: `missing required property "tags"`},
+		{Pointer: "/email", Message: `must match pattern "^[^@]+@[^@]+$"`},
+		{Pointer: "/extra", Message: "no value is allowed here"},
+		{Pointer: "/id", Message: "expected integer, got number"},
+		{Pointer: "/name", Message: "must be at most 8 characters long"},
+		{Pointer: "/role", Message: `must be one of ["admin","user"]`},
+	}, violations(`{"id": 1.5, "name": "much too long", "email": "nope", "role": "root", "extra": 1}`))
+
+	assert.Equal(t, []napping.SchemaViolation{
+		{Pointer: "/tags", Message: "items 0 and 2 are equal"},
+	}, violations(`{"id": 1, "name": "a", "tags": ["x", "y", "x"]}`))
+
+	assert.Equal(t, []napping.SchemaViolation{
+		{Pointer: "/id", Message: "must be >= 1"},
+		{Pointer: "/manager", Message: "must match at least one schema of anyOf"},
+		{Pointer: "/tags/0", Message: `must match pattern "^[a-z]+$"`},
+	}, violations(`{"id": 0, "name": "a", "tags": ["X"], "manager": 3}`))
+
+	assert.Equal(t, "", violations(`{`)[0].Pointer)
+}
+
+func TestKeywords(t *testing.T) {
+	cases := []struct {
+		schema, doc string
+		valid       bool
+	}{
+		{`{"const": 3}`, `3`, true},
+		{`{"const": 3}`, `4`, false},
+		{`{"type": ["string", "null"]}`, `null`, true},
+		{`{"exclusiveMaximum": 10}`, `10`, false},
+		{`{"multipleOf": 0.5}`, `2.5`, true},
+		{`{"multipleOf": 2}`, `3`, false},
+		{`{"minItems": 2}`, `[1]`, false},
+		{`{"maxProperties": 1}`, `{"a": 1, "b": 2}`, false},
+		{`{"oneOf": [{"type": "integer"}, {"minimum": 0}]}`, `1`, false},
+		{`{"oneOf": [{"type": "integer"}, {"minimum": 0}]}`, `-1`, true},
+		{`{"allOf": [{"minimum": 0}, {"maximum": 5}]}`, `6`, false},
+		{`{"not": {"type": "string"}}`, `"x"`, false},
+		{`false`, `1`, false},
+		{`true`, `1`, true},
+		{`{"format": "email"}`, `"ignored"`, true},
+	}
+	for _, c := range cases {
+		s, err := Compile([]byte(c.schema))
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.Equal(t, c.valid, len(s.Validate([]byte(c.doc))) == 0, "%s against %s", c.doc, c.schema)
+	}
+
+	for _, bad := range []string{`{`, `3`, `{"pattern": "("}`, `{"$ref": "#/missing"}`, `{"$ref": "other.json"}`} {
+		_, err := Compile([]byte(bad))
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestSessionSchema(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": 7, "name": "", "tags": []}`))
+	}))
+	defer srv.Close()
+
+	var user struct{ ID int }
+	s := napping.Session{}
+	resp, err := s.Send(&napping.Request{Url: srv.URL, Method: "GET", Result: &user, Schema: userSchema})
+	var se *napping.SchemaValidationError
+	if assert.True(t, errors.As(err, &se)) {
+		assert.Equal(t, []napping.SchemaViolation{{Pointer: "/name", Message: "must be at least 1 characters long"}}, se.Violations)
+	}
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, 7, user.ID)
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements shadow traffic: a sample of requests is re-sent to a
+second service so that its responses can be compared with the primary ones.
+*/
+
+import (
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults for the budget of mirrored requests.
+const (
+	DefaultMirrorTimeout     = 5 * time.Second
+	DefaultMirrorMaxInFlight = 64
+)
+
+// A Mirror re-sends a sample of a Session's requests to another base URL.
+// Mirrored requests are sent in the background after the primary response
+// has been received, once and without retries, so they never delay or fail
+// the primary call.  The request is sent with the same method, headers
+// (including credentials) and body, to the same path and query below the
+// URL of BaseURL.
+type Mirror struct {
+	BaseURL string
+	Percent float64 // Share of requests to mirror, from 0 to 100
+
+	// Compare is called in its own goroutine with the primary response and
+	// the mirrored one, which is nil if the mirrored request failed.
+	Compare func(primary, shadow *Response)
+
+	Timeout     time.Duration // Limit on each mirrored request; DefaultMirrorTimeout if zero
+	MaxInFlight int           // Mirrored requests are skipped beyond this; DefaultMirrorMaxInFlight if zero
+
+	// Non-idempotent methods such as POST and PATCH are only mirrored if
+	// AllowUnsafe is set.
+	AllowUnsafe bool
+
+	disabled int32
+	inFlight int32
+}
+
+// Disable stops mirroring until Enable is called.  It is safe to call while
+// requests are in flight.
+func (m *Mirror) Disable() {
+	atomic.StoreInt32(&m.disabled, 1)
+}
+
+// Enable resumes mirroring after Disable.
+func (m *Mirror) Enable() {
+	atomic.StoreInt32(&m.disabled, 0)
+}
+
+// Enabled reports whether mirroring is enabled.
+func (m *Mirror) Enabled() bool {
+	return atomic.LoadInt32(&m.disabled) == 0
+}
+
+// sample reports whether a request with the given method should be
+// mirrored, and if so counts it as in flight.
+func (m *Mirror) sample(method string) bool {
+	if !m.Enabled() || m.Percent <= 0 || (!m.AllowUnsafe && !isIdempotent(method)) {
+		return false
+	}
+	if m.Percent < 100 && rand.Float64()*100 >= m.Percent {
+		return false
+	}
+	max := m.MaxInFlight
+	if max <= 0 {
+		max = DefaultMirrorMaxInFlight
+	}
+	if atomic.AddInt32(&m.inFlight, 1) > int32(max) {
+		atomic.AddInt32(&m.inFlight, -1)
+		return false
+	}
+	return true
+}
+
+// isIdempotent reports whether method is idempotent per RFC 7231.
+func isIdempotent(method string) bool {
+	switch strings.ToUpper(method) {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// mirror sends a copy of req to the mirror, if it is sampled, and passes
+// the result to Compare along with primary.
+func (s *Session) mirror(client *http.Client, req *http.Request, primary *Response) {
+	m := s.Mirror
+	if !m.sample(req.Method) {
+		return
+	}
+	base, err := url.Parse(m.BaseURL)
+	if err == nil {
+		req, err = replay(req, mirrorURL(req.URL, base))
+	}
+	if err != nil {
+		atomic.AddInt32(&m.inFlight, -1)
+		s.logError("Mirror", err)
+		return
+	}
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = DefaultMirrorTimeout
+	}
+	go func() {
+		defer atomic.AddInt32(&m.inFlight, -1)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		shadow, err := s.sendMirror(client, req.WithContext(ctx))
+		if err != nil {
+			s.logError("Mirror", err)
+		}
+		if m.Compare != nil {
+			m.Compare(primary, shadow)
+		}
+	}()
+}
+
+// sendMirror sends a mirrored request and reads its response.
+func (s *Session) sendMirror(client *http.Client, req *http.Request) (*Response, error) {
+	r := &Request{Url: req.URL.String(), Method: req.Method, timestamp: s.now()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if s.AcceptEncoding != "" {
+		if err = decompress(resp, req.Header.Get("Accept-Encoding")); err != nil {
+			return nil, err
+		}
+	}
+	if r.body, err = ioutil.ReadAll(resp.Body); err != nil {
+		return nil, err
+	}
+	r.status = resp.StatusCode
+	r.response = resp
+	rsp := Response(*r)
+	return &rsp, nil
+}
+
+// mirrorURL returns u moved onto the scheme and host of base, below its
+// path.
+func mirrorURL(u, base *url.URL) *url.URL {
+	out := *u
+	out.Scheme = base.Scheme
+	out.Host = base.Host
+	out.User = nil
+	if p := strings.TrimSuffix(base.Path, "/"); p != "" {
+		out.Path = p + u.Path
+		out.RawPath = ""
+	}
+	return &out
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mirrored struct {
+	primary, shadow *Response
+}
+
+func TestMirror(t *testing.T) {
+	primary := httptest.NewServer(handleJSON(`{"v": 1}`))
+	defer primary.Close()
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.URL.RequestURI() + " " + req.Header.Get("X-Test")))
+	}))
+	defer shadow.Close()
+
+	done := make(chan mirrored, 10)
+	m := &Mirror{
+		BaseURL: shadow.URL + "/v2",
+		Percent: 100,
+		Compare: func(p, s *Response) { done <- mirrored{p, s} },
+	}
+	s := Session{Mirror: m, Header: &http.Header{"X-Test": {"yes"}}}
+	resp, err := s.Get(primary.URL+"/items", &url.Values{"q": {"x"}})
+	assert.NoError(t, err)
+	got := <-done
+	assert.Equal(t, resp, got.primary)
+	if assert.NotNil(t, got.shadow) {
+		assert.Equal(t, 200, got.shadow.Status())
+		assert.Equal(t, "/v2/items?q=x yes", got.shadow.RawText())
+	}
+
+	// Non-idempotent methods are not mirrored unless allowed.
+	_, err = s.Post(primary.URL, map[string]int{"a": 1})
+	assert.NoError(t, err)
+	m.AllowUnsafe = true
+	_, err = s.Post(primary.URL, map[string]int{"a": 1})
+	assert.NoError(t, err)
+	got = <-done
+	assert.Equal(t, "POST", got.shadow.Method)
+
+	// Kill switch.
+	m.Disable()
+	_, err = s.Get(primary.URL, nil)
+	assert.NoError(t, err)
+	select {
+	case <-done:
+		t.Fatal("mirrored while disabled")
+	case <-time.After(50 * time.Millisecond):
+	}
+	m.Enable()
+	assert.True(t, m.Enabled())
+}
+
+func TestMirrorFailure(t *testing.T) {
+	primary := httptest.NewServer(handleJSON(`{}`))
+	defer primary.Close()
+	block := make(chan struct{})
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-block
+	}))
+	defer shadow.Close()
+	defer close(block)
+
+	done := make(chan mirrored, 1)
+	s := Session{Mirror: &Mirror{
+		BaseURL: shadow.URL,
+		Percent: 100,
+		Timeout: 500 * time.Millisecond,
+		Compare: func(p, s *Response) { done <- mirrored{p, s} },
+	}}
+	start := time.Now()
+	_, err := s.Get(primary.URL, nil)
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+	got := <-done
+	assert.NotNil(t, got.primary)
+	assert.Nil(t, got.shadow)
+}
+
+func TestMirrorSample(t *testing.T) {
+	m := &Mirror{Percent: 100, MaxInFlight: 1}
+	assert.True(t, m.sample("GET"))
+	assert.False(t, m.sample("GET"), "over budget")
+	m.inFlight = 0
+	assert.False(t, m.sample("PATCH"))
+	m.Percent = 0
+	assert.False(t, m.sample("GET"))
+}
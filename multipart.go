@@ -0,0 +1,76 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements multipart/form-data request bodies for file uploads.
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// A FormFile is a file uploaded as one part of a multipart/form-data request.
+type FormFile struct {
+	FieldName string
+	FileName  string
+	Content   io.Reader
+
+	// Optional part headers, e.g. Content-Transfer-Encoding.  These are
+	// added to, and override, the default Content-Disposition and
+	// Content-Type headers of the part.
+	Header textproto.MIMEHeader
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// encodeMultipart renders the request's Form fields and Files as a
+// multipart/form-data body, returning the body and its content type.
+func (r *Request) encodeMultipart() (*bytes.Buffer, string, error) {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	if r.MultipartBoundary != "" {
+		if err := mw.SetBoundary(r.MultipartBoundary); err != nil {
+			return nil, "", err
+		}
+	}
+	if r.Form != nil {
+		for k, vs := range *r.Form {
+			for _, v := range vs {
+				if err := mw.WriteField(k, v); err != nil {
+					return nil, "", err
+				}
+			}
+		}
+	}
+	for _, f := range r.Files {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			quoteEscaper.Replace(f.FieldName), quoteEscaper.Replace(f.FileName)))
+		h.Set("Content-Type", "application/octet-stream")
+		for k, v := range f.Header {
+			h[textproto.CanonicalMIMEHeaderKey(k)] = v
+		}
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			return nil, "", err
+		}
+		if f.Content != nil {
+			if _, err := io.Copy(part, f.Content); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+	return body, mw.FormDataContentType(), nil
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultipartUpload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		assert.Equal(t, "fixed-boundary-1234", params["boundary"])
+		mr, err := req.MultipartReader()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		form, err := mr.ReadForm(1 << 20)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		assert.Equal(t, []string{"kirk"}, form.Value["captain"])
+		fh := form.File["log"][0]
+		assert.Equal(t, "log.txt", fh.Filename)
+		assert.Equal(t, "base64", fh.Header.Get("Content-Transfer-Encoding"))
+		assert.Equal(t, "text/plain", fh.Header.Get("Content-Type"))
+		f, _ := fh.Open()
+		b, _ := ioutil.ReadAll(f)
+		assert.Equal(t, "U3RhcmRhdGU=", string(b))
+	}))
+	defer srv.Close()
+	form := url.Values{"captain": {"kirk"}}
+	r := Request{
+		Url:    srv.URL,
+		Method: "POST",
+		Form:   &form,
+		Files: []FormFile{{
+			FieldName: "log",
+			FileName:  "log.txt",
+			Content:   strings.NewReader("U3RhcmRhdGU="),
+			Header: textproto.MIMEHeader{
+				"Content-Transfer-Encoding": {"base64"},
+				"Content-Type":              {"text/plain"},
+			},
+		}},
+		MultipartBoundary: "fixed-boundary-1234",
+	}
+	resp, err := Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+}
+
+func TestMultipartRandomBoundary(t *testing.T) {
+	var boundaries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, params, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		boundaries = append(boundaries, params["boundary"])
+	}))
+	defer srv.Close()
+	for i := 0; i < 2; i++ {
+		r := Request{
+			Url:    srv.URL,
+			Method: "POST",
+			Files:  []FormFile{{FieldName: "f", FileName: "f", Content: strings.NewReader("x")}},
+		}
+		if _, err := Send(&r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.Len(t, boundaries, 2)
+	assert.NotEmpty(t, boundaries[0])
+	assert.NotEqual(t, boundaries[0], boundaries[1])
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+/*
+Package nappingtest provides helpers for testing code built on package
+napping.
+
+The Assert functions report failures through the supplied testing.TB and
+return whether the assertion held.  Every failure message includes the
+response status, headers and a truncated, pretty-printed body so that a
+failure can be diagnosed from CI logs alone.
+*/
+package nappingtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/yinyajiang/napping"
+)
+
+// MaxBodyDump is the number of body bytes included in failure messages.
+var MaxBodyDump = 2048
+
+// AssertStatus checks that the response has the expected HTTP status.
+func AssertStatus(t testing.TB, resp *napping.Response, code int) bool {
+	t.Helper()
+	if resp.Status() != code {
+		t.Errorf("expected status %d, got %d\n%s", code, resp.Status(), describe(resp))
+		return false
+	}
+	return true
+}
+
+// AssertHeader checks that the response carries header key.  If values are
+// given, the header values must equal them exactly and in order.
+func AssertHeader(t testing.TB, resp *napping.Response, key string, values ...string) bool {
+	t.Helper()
+	actual := headerValues(resp, key)
+	if len(actual) == 0 {
+		t.Errorf("expected header %q to be present\n%s", key, describe(resp))
+		return false
+	}
+	if len(values) > 0 && !reflect.DeepEqual(actual, values) {
+		t.Errorf("expected header %q to be %q, got %q\n%s", key, values, actual, describe(resp))
+		return false
+	}
+	return true
+}
+
+// AssertJSONField checks that the value at path in the JSON response body
+// equals expected.  Comparison is semantic: both values are round-tripped
+// through encoding/json, so 42 matches the decoded float64 42, or the
+// json.Number "42" with UseNumber.
+func AssertJSONField(t testing.TB, resp *napping.Response, path string, expected interface{}) bool {
+	t.Helper()
+	actual, err := resp.Path(path)
+	if err != nil {
+		t.Errorf("%s\n%s", err, describe(resp))
+		return false
+	}
+	if actual, err = normalize(actual); err != nil {
+		t.Errorf("cannot encode value at %q: %s\n%s", path, err, describe(resp))
+		return false
+	}
+	want, err := normalize(expected)
+	if err != nil {
+		t.Errorf("cannot encode expected value for %q: %s", path, err)
+		return false
+	}
+	if !reflect.DeepEqual(actual, want) {
+		t.Errorf("expected %q to be %s, got %s\n%s", path, compact(want), compact(actual), describe(resp))
+		return false
+	}
+	return true
+}
+
+// AssertBodyMatches checks that the JSON response body is semantically
+// equal to snippet, ignoring object key order.  Paths listed in ignore
+// (e.g. "meta.timestamp") are removed from both documents before comparing,
+// which is useful for volatile fields.
+func AssertBodyMatches(t testing.TB, resp *napping.Response, snippet string, ignore ...string) bool {
+	t.Helper()
+	var want, actual interface{}
+	if err := json.Unmarshal([]byte(snippet), &want); err != nil {
+		t.Errorf("cannot decode expected JSON: %s", err)
+		return false
+	}
+	err := resp.Unmarshal(&actual)
+	if err == nil {
+		actual, err = normalize(actual)
+	}
+	if err != nil {
+		t.Errorf("cannot decode response body: %s\n%s", err, describe(resp))
+		return false
+	}
+	for _, p := range ignore {
+		want = removePath(want, strings.Split(p, "."))
+		actual = removePath(actual, strings.Split(p, "."))
+	}
+	if !reflect.DeepEqual(actual, want) {
+		t.Errorf("response body does not match\nexpected: %s\n%s", compact(want), describe(resp))
+		return false
+	}
+	return true
+}
+
+// normalize round-trips v through encoding/json, so that values compare
+// equal whatever types they were decoded or constructed as.
+func normalize(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	err = json.Unmarshal(b, &out)
+	return out, err
+}
+
+// removePath deletes the member addressed by path from v, returning v.
+func removePath(v interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return nil
+	}
+	switch node := v.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			delete(node, path[0])
+		} else if child, ok := node[path[0]]; ok {
+			node[path[0]] = removePath(child, path[1:])
+		}
+	case []interface{}:
+		if path[0] == "*" {
+			for i := range node {
+				node[i] = removePath(node[i], path[1:])
+			}
+		} else if idx, err := strconv.Atoi(path[0]); err == nil && idx >= 0 && idx < len(node) {
+			if len(path) == 1 {
+				return append(node[:idx:idx], node[idx+1:]...)
+			}
+			node[idx] = removePath(node[idx], path[1:])
+		}
+	}
+	return v
+}
+
+func headerValues(resp *napping.Response, key string) []string {
+	if resp.HttpResponse() == nil {
+		return nil
+	}
+	return resp.HttpResponse().Header.Values(key)
+}
+
+func compact(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// describe renders the status, headers and a truncated body of resp.
+func describe(resp *napping.Response) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- response status: %d\n", resp.Status())
+	if hr := resp.HttpResponse(); hr != nil {
+		keys := make([]string, 0, len(hr.Header))
+		for k := range hr.Header {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&buf, "%s: %s\n", k, strings.Join(hr.Header[k], ", "))
+		}
+	}
+	body := resp.RawByte()
+	var pretty bytes.Buffer
+	if json.Indent(&pretty, body, "", "  ") == nil {
+		body = pretty.Bytes()
+	}
+	buf.WriteString("\n")
+	if len(body) > MaxBodyDump {
+		buf.Write(body[:MaxBodyDump])
+		fmt.Fprintf(&buf, "\n... (%d bytes truncated)", len(body)-MaxBodyDump)
+	} else {
+		buf.Write(body)
+	}
+	return buf.String()
+}
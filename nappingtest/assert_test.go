@@ -0,0 +1,102 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package nappingtest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinyajiang/napping"
+)
+
+// recorder captures failures instead of failing the enclosing test.
+type recorder struct {
+	testing.TB
+	msgs []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.msgs = append(r.msgs, fmt.Sprintf(format, args...))
+}
+
+func userResponse(t *testing.T) *napping.Response {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteHeader(200)
+		fmt.Fprint(w, `{"user": {"id": 42, "name": "kirk"}, "updated": "2013-01-01T00:00:00Z"}`)
+	}))
+	defer srv.Close()
+	resp, err := napping.Get(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestAssertPass(t *testing.T) {
+	resp := userResponse(t)
+	rec := &recorder{TB: t}
+	assert.True(t, AssertStatus(rec, resp, 200))
+	assert.True(t, AssertHeader(rec, resp, "ETag"))
+	assert.True(t, AssertHeader(rec, resp, "ETag", `"v1"`))
+	assert.True(t, AssertJSONField(rec, resp, "user.id", 42))
+	assert.True(t, AssertBodyMatches(rec, resp, `{"updated": "x", "user": {"name": "kirk", "id": 42}}`, "updated"))
+	assert.Empty(t, rec.msgs)
+}
+
+func TestAssertUseNumber(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"user": {"id": 42, "score": 1.5}}`)
+	}))
+	defer srv.Close()
+	resp, err := napping.Send(&napping.Request{Url: srv.URL, UseNumber: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &recorder{TB: t}
+	assert.True(t, AssertJSONField(rec, resp, "user.id", 42))
+	assert.True(t, AssertJSONField(rec, resp, "user.score", 1.5))
+	assert.True(t, AssertBodyMatches(rec, resp, `{"user": {"score": 1.5, "id": 42}}`))
+	assert.Empty(t, rec.msgs)
+	assert.False(t, AssertJSONField(rec, resp, "user.id", 7))
+	assert.Len(t, rec.msgs, 1)
+}
+
+func TestAssertFail(t *testing.T) {
+	resp := userResponse(t)
+	rec := &recorder{TB: t}
+	assert.False(t, AssertStatus(rec, resp, 404))
+	assert.False(t, AssertHeader(rec, resp, "X-Missing"))
+	assert.False(t, AssertJSONField(rec, resp, "user.id", 7))
+	assert.False(t, AssertJSONField(rec, resp, "user.email", "x"))
+	assert.False(t, AssertBodyMatches(rec, resp, `{"user": {"id": 42, "name": "kirk"}}`))
+	assert.Len(t, rec.msgs, 5)
+	for _, m := range rec.msgs {
+		assert.Contains(t, m, "response status: 200")
+		assert.Contains(t, m, "Etag: \"v1\"")
+		assert.Contains(t, m, `"name": "kirk"`)
+	}
+}
+
+func TestDescribeTruncates(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, strings.Repeat("x", MaxBodyDump+10))
+	}))
+	defer srv.Close()
+	resp, err := napping.Get(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, describe(resp), "(10 bytes truncated)")
+}
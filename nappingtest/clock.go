@@ -0,0 +1,118 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package nappingtest
+
+/*
+This module implements a napping.Clock whose time only moves when a test
+says so.
+*/
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A FakeClock is a napping.Clock which stands still until Advance is
+// called, so that tests of retries, cooldowns and expiry run instantly and
+// deterministically:
+//
+//	clock := nappingtest.NewFakeClock(time.Now())
+//	s.SetClock(clock)
+//	go s.Get(url, nil) // Retries after a backoff
+//	clock.WaitForSleepers(1)
+//	clock.Advance(time.Second)
+type FakeClock struct {
+	mu       sync.Mutex
+	cond     *sync.Cond // Signaled when sleepers are added
+	now      time.Time
+	sleepers []*sleeper
+}
+
+// sleeper is a call to Sleep waiting for the clock to reach until.
+type sleeper struct {
+	until time.Time
+	wake  chan struct{}
+}
+
+// NewFakeClock returns a FakeClock set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Sleep waits until the clock has been advanced by d, or ctx is done.
+func (c *FakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	c.mu.Lock()
+	sl := &sleeper{until: c.now.Add(d), wake: make(chan struct{})}
+	c.sleepers = append(c.sleepers, sl)
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	select {
+	case <-sl.wake:
+		return nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		c.remove(sl)
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// remove drops sl from the sleepers.  The caller holds c.mu.
+func (c *FakeClock) remove(sl *sleeper) {
+	for i, other := range c.sleepers {
+		if other == sl {
+			c.sleepers = append(c.sleepers[:i], c.sleepers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Advance moves the clock forward by d, waking the sleepers whose time has
+// come.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	kept := c.sleepers[:0]
+	for _, sl := range c.sleepers {
+		if c.now.Before(sl.until) {
+			kept = append(kept, sl)
+		} else {
+			close(sl.wake)
+		}
+	}
+	c.sleepers = kept
+}
+
+// Sleepers returns the number of calls to Sleep waiting for the clock.
+func (c *FakeClock) Sleepers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.sleepers)
+}
+
+// WaitForSleepers blocks until at least n calls to Sleep are waiting, so
+// that a test can Advance the clock past them.
+func (c *FakeClock) WaitForSleepers(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.sleepers) < n {
+		c.cond.Wait()
+	}
+}
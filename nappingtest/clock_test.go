@@ -0,0 +1,83 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package nappingtest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinyajiang/napping"
+)
+
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestFakeClock(t *testing.T) {
+	c := NewFakeClock(epoch)
+	done := make(chan error)
+	go func() { done <- c.Sleep(context.Background(), time.Minute) }()
+	c.WaitForSleepers(1)
+	c.Advance(59 * time.Second)
+	assert.Equal(t, 1, c.Sleepers())
+	c.Advance(time.Second)
+	assert.NoError(t, <-done)
+	assert.Equal(t, epoch.Add(time.Minute), c.Now())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { done <- c.Sleep(ctx, time.Minute) }()
+	c.WaitForSleepers(1)
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+	assert.Equal(t, 0, c.Sleepers())
+	assert.NoError(t, c.Sleep(context.Background(), 0))
+}
+
+func TestFakeClockDrivesSession(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(503)
+		case 2:
+			w.WriteHeader(503)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer srv.Close()
+
+	c := NewFakeClock(epoch)
+	s := napping.Session{Retry: &napping.RetryPolicy{MaxAttempts: 3, Backoff: time.Minute}}
+	s.SetClock(c)
+	type result struct {
+		resp *napping.Response
+		err  error
+	}
+	done := make(chan result)
+	go func() {
+		resp, err := s.Get(srv.URL, nil)
+		done <- result{resp, err}
+	}()
+
+	// Retry-After outweighs the first backoff, then the backoff doubles.
+	c.WaitForSleepers(1)
+	c.Advance(time.Hour - time.Second)
+	assert.Equal(t, 1, c.Sleepers())
+	c.Advance(time.Second)
+	c.WaitForSleepers(1)
+	c.Advance(2 * time.Minute)
+	res := <-done
+	assert.NoError(t, res.err)
+	assert.Equal(t, "ok", res.resp.RawText())
+	assert.Equal(t, epoch, res.resp.Timestamp())
+	assert.Equal(t, time.Hour+2*time.Minute, res.resp.Timings().Total)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
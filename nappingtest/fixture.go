@@ -0,0 +1,157 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package nappingtest
+
+/*
+This module implements a fake HTTP server answering from canned responses on
+disk, for developing clients while the real API is unavailable.
+*/
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yinyajiang/napping"
+)
+
+// LatencyHeader is a pseudo-header which, when present in a fixture file,
+// delays the response by the given duration (e.g. "250ms").  It is not sent
+// to the client.
+const LatencyHeader = "Fixture-Latency"
+
+// Wildcard is the name of a fixture directory matching any single path
+// segment.  Exact matches take precedence over wildcards.
+const Wildcard = "_"
+
+// A FixtureServer serves canned responses from a directory tree.
+//
+// The response to "GET /users/42/profile" is read from the file
+// users/42/profile/GET.http below the fixture directory, or from
+// users/_/profile/GET.http if no exact match exists.  Fixture files hold an
+// HTTP response as it appears on the wire:
+//
+//	HTTP/1.1 200 OK
+//	Content-Type: application/json
+//	Fixture-Latency: 100ms
+//
+//	{"id": 42}
+//
+// Fixtures are read from disk on every request, so edits take effect
+// immediately without restarting the server.
+type FixtureServer struct {
+	*httptest.Server
+	Dir string
+
+	// Logf reports unmatched requests.  Defaults to log.Printf.
+	Logf func(format string, args ...interface{})
+
+	mu        sync.Mutex
+	unmatched []string
+}
+
+// NewFixtureServer starts a FixtureServer serving fixtures from dir.  The
+// caller should call Close when finished.
+func NewFixtureServer(dir string) *FixtureServer {
+	f := &FixtureServer{
+		Dir:  dir,
+		Logf: log.Printf,
+	}
+	f.Server = httptest.NewServer(f)
+	return f
+}
+
+// Session returns a Session whose relative request URLs are resolved against
+// the fixture server.
+func (f *FixtureServer) Session() *napping.Session {
+	return &napping.Session{BaseURL: f.URL}
+}
+
+// Unmatched returns the requests, as "METHOD /path", for which no fixture was
+// found.
+func (f *FixtureServer) Unmatched() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.unmatched...)
+}
+
+// ServeHTTP implements http.Handler.
+func (f *FixtureServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	segments := strings.FieldsFunc(req.URL.Path, func(r rune) bool { return r == '/' })
+	path := f.find(f.Dir, segments, req.Method+".http")
+	if path == "" {
+		f.miss(w, req)
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		f.miss(w, req)
+		return
+	}
+	defer file.Close()
+	resp, err := http.ReadResponse(bufio.NewReader(file), req)
+	if err != nil {
+		http.Error(w, "bad fixture "+path+": "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer resp.Body.Close()
+	if latency := resp.Header.Get(LatencyHeader); latency != "" {
+		resp.Header.Del(LatencyHeader)
+		if d, err := time.ParseDuration(latency); err == nil {
+			select {
+			case <-time.After(d):
+			case <-req.Context().Done():
+				return
+			}
+		}
+	}
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// find returns the fixture file for segments below dir, preferring exact
+// segment matches over wildcards, or "" if there is none.
+func (f *FixtureServer) find(dir string, segments []string, name string) string {
+	if len(segments) == 0 {
+		path := filepath.Join(dir, name)
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			return path
+		}
+		return ""
+	}
+	for _, seg := range []string{segments[0], Wildcard} {
+		if seg == "." || seg == ".." {
+			continue
+		}
+		if fi, err := os.Stat(filepath.Join(dir, seg)); err == nil && fi.IsDir() {
+			if path := f.find(filepath.Join(dir, seg), segments[1:], name); path != "" {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+func (f *FixtureServer) miss(w http.ResponseWriter, req *http.Request) {
+	entry := req.Method + " " + req.URL.Path
+	f.mu.Lock()
+	f.unmatched = append(f.unmatched, entry)
+	f.mu.Unlock()
+	if f.Logf != nil {
+		f.Logf("nappingtest: no fixture for %s", entry)
+	}
+	http.Error(w, "no fixture for "+entry, http.StatusNotFound)
+}
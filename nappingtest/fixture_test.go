@@ -0,0 +1,90 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package nappingtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFixture(t *testing.T, dir, path, content string) {
+	full := filepath.Join(dir, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFixtureServer(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, "users/_/GET.http", "HTTP/1.1 200 OK\nContent-Type: application/json\n\n{\"id\": \"any\"}")
+	writeFixture(t, dir, "users/42/GET.http", "HTTP/1.1 200 OK\nContent-Type: application/json\nX-Exact: yes\n\n{\"id\": 42}")
+	writeFixture(t, dir, "users/POST.http", "HTTP/1.1 201 Created\nLocation: /users/43\nFixture-Latency: 50ms\n\n")
+	fs := NewFixtureServer(dir)
+	defer fs.Close()
+	var logged []string
+	fs.Logf = func(format string, args ...interface{}) {
+		logged = append(logged, format)
+	}
+	s := fs.Session()
+
+	resp, err := s.Get("/users/42", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, resp, 200)
+	AssertHeader(t, resp, "X-Exact", "yes")
+	AssertJSONField(t, resp, "id", 42)
+
+	resp, err = s.Get("/users/7", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertJSONField(t, resp, "id", "any")
+
+	start := time.Now()
+	resp, err = s.Post("/users", map[string]string{"name": "kirk"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, resp, 201)
+	AssertHeader(t, resp, "Location", "/users/43")
+	assert.Empty(t, resp.HttpResponse().Header.Get(LatencyHeader))
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	resp, err = s.Delete("/users/42", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, resp, 404)
+	assert.Equal(t, []string{"DELETE /users/42"}, fs.Unmatched())
+	assert.Len(t, logged, 1)
+}
+
+func TestFixtureServerReload(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, "GET.http", "HTTP/1.1 200 OK\n\nfirst")
+	fs := NewFixtureServer(dir)
+	defer fs.Close()
+	s := fs.Session()
+	resp, err := s.Get("/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "first", resp.RawText())
+	writeFixture(t, dir, "GET.http", "HTTP/1.1 200 OK\n\nsecond")
+	resp, err = s.Get("/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "second", resp.RawText())
+}
@@ -0,0 +1,227 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package nappingtest
+
+/*
+This module implements an http.RoundTripper answering from canned responses
+in memory, for unit tests which do not need a real server.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yinyajiang/napping"
+)
+
+// A MockTransport is an http.RoundTripper which answers requests with the
+// canned responses of the first matching MockRoute, and records every
+// request it sees.  Set it as Session.Transport:
+//
+//	mock := nappingtest.NewMockTransport()
+//	mock.On("GET", "/users/42").RespondJSON(200, user)
+//	s := napping.Session{Transport: mock, BaseURL: "http://api.test"}
+//
+// Requests without a matching route fail with an error naming the request.
+type MockTransport struct {
+	mu        sync.Mutex
+	routes    []*MockRoute
+	requests  []*RecordedRequest
+	unmatched []string
+}
+
+// A RecordedRequest is a request seen by a MockTransport.
+type RecordedRequest struct {
+	Method string
+	URL    *url.URL
+	Header http.Header
+	Body   []byte
+}
+
+// A MockRoute is a canned response for the requests it matches.
+type MockRoute struct {
+	m       *MockTransport
+	method  string
+	pattern string
+	status  int
+	header  http.Header
+	body    []byte
+	err     error
+	delay   time.Duration
+	times   int // Remaining uses; 0 means unlimited
+	hits    int
+}
+
+// NewMockTransport returns a MockTransport without routes.
+func NewMockTransport() *MockTransport {
+	return &MockTransport{}
+}
+
+// Session returns a Session sending its requests to m.
+func (m *MockTransport) Session() *napping.Session {
+	return &napping.Session{Transport: m}
+}
+
+// On adds a route for requests with the given method, or any method if it
+// is "" or "*".  The pattern is a path such as "/users/42", which matches
+// requests to any host, or an absolute URL.  A query string in the pattern
+// must match exactly; without one, any query matches.  Routes are tried in
+// the order they were added.  The route answers 200 with an empty body
+// until configured otherwise.
+func (m *MockTransport) On(method, pattern string) *MockRoute {
+	r := &MockRoute{m: m, method: strings.ToUpper(method), pattern: pattern, status: http.StatusOK, header: http.Header{}}
+	m.mu.Lock()
+	m.routes = append(m.routes, r)
+	m.mu.Unlock()
+	return r
+}
+
+// Respond sets the status and body of the response.
+func (r *MockRoute) Respond(status int, body string) *MockRoute {
+	r.status = status
+	r.body = []byte(body)
+	return r
+}
+
+// RespondJSON sets the status of the response, and its body to the JSON
+// encoding of v.
+func (r *MockRoute) RespondJSON(status int, v interface{}) *MockRoute {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic("nappingtest: RespondJSON: " + err.Error())
+	}
+	r.status = status
+	r.body = b
+	r.header.Set("Content-Type", "application/json")
+	return r
+}
+
+// Header adds a response header.
+func (r *MockRoute) Header(key, value string) *MockRoute {
+	r.header.Add(key, value)
+	return r
+}
+
+// Fail makes the route fail the request with err instead of responding,
+// e.g. to simulate a connection error.
+func (r *MockRoute) Fail(err error) *MockRoute {
+	r.err = err
+	return r
+}
+
+// Delay makes the route wait before answering.  The wait ends early, with
+// the context's error, if the request is canceled.
+func (r *MockRoute) Delay(d time.Duration) *MockRoute {
+	r.delay = d
+	return r
+}
+
+// Times limits the route to its next n matches.
+func (r *MockRoute) Times(n int) *MockRoute {
+	r.times = n
+	return r
+}
+
+// Hits returns the number of requests the route answered.
+func (r *MockRoute) Hits() int {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	return r.hits
+}
+
+// matches reports whether the route applies to req.
+func (r *MockRoute) matches(req *http.Request) bool {
+	if r.method != "" && r.method != "*" && r.method != req.Method {
+		return false
+	}
+	if r.times > 0 && r.hits >= r.times {
+		return false
+	}
+	p, err := url.Parse(r.pattern)
+	if err != nil {
+		return false
+	}
+	if p.IsAbs() && (p.Scheme != req.URL.Scheme || p.Host != req.URL.Host) {
+		return false
+	}
+	if p.Path != req.URL.Path {
+		return false
+	}
+	return p.RawQuery == "" || p.Query().Encode() == req.URL.Query().Encode()
+}
+
+// RoundTrip implements http.RoundTripper.
+func (m *MockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := &RecordedRequest{Method: req.Method, URL: req.URL, Header: req.Header.Clone()}
+	if req.Body != nil {
+		rec.Body, _ = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+	}
+	m.mu.Lock()
+	m.requests = append(m.requests, rec)
+	var route *MockRoute
+	for _, r := range m.routes {
+		if r.matches(req) {
+			route = r
+			r.hits++
+			break
+		}
+	}
+	if route == nil {
+		m.unmatched = append(m.unmatched, req.Method+" "+req.URL.String())
+	}
+	m.mu.Unlock()
+
+	if route == nil {
+		return nil, fmt.Errorf("nappingtest: no mock route for %s %s", req.Method, req.URL)
+	}
+	if route.delay > 0 {
+		select {
+		case <-time.After(route.delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	if route.err != nil {
+		return nil, route.err
+	}
+	header := route.header.Clone()
+	header.Set("Content-Length", strconv.Itoa(len(route.body)))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", route.status, http.StatusText(route.status)),
+		StatusCode:    route.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(route.body)),
+		ContentLength: int64(len(route.body)),
+		Request:       req,
+	}, nil
+}
+
+// Requests returns the requests seen so far, in order.
+func (m *MockTransport) Requests() []*RecordedRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*RecordedRequest(nil), m.requests...)
+}
+
+// Unmatched returns the requests, as "METHOD URL", for which no route
+// matched.
+func (m *MockTransport) Unmatched() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.unmatched...)
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package nappingtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinyajiang/napping"
+)
+
+func TestMockTransport(t *testing.T) {
+	mock := NewMockTransport()
+	user := mock.On("GET", "/users/42").RespondJSON(200, map[string]string{"name": "kirk"})
+	mock.On("POST", "http://api.test/users").Respond(201, "").Header("Location", "/users/43")
+	mock.On("*", "/search?q=spock").Respond(200, "found")
+	mock.On("GET", "/flaky").Fail(errors.New("connection reset")).Times(1)
+	mock.On("GET", "/flaky").Respond(200, "recovered")
+	s := mock.Session()
+	s.BaseURL = "http://api.test"
+
+	var res struct{ Name string }
+	resp, err := s.Send(&napping.Request{Url: "/users/42?verbose=1", Result: &res})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, "kirk", res.Name)
+	assert.Equal(t, 1, user.Hits())
+
+	resp, err = s.Post("/users", map[string]string{"name": "mccoy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 201, resp.Status())
+	AssertHeader(t, resp, "Location", "/users/43")
+
+	resp, err = s.Get("/search?q=spock", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "found", resp.RawText())
+
+	_, err = s.Get("/flaky", nil)
+	assert.EqualError(t, err, `Get "http://api.test/flaky": connection reset`)
+	resp, err = s.Get("/flaky", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "recovered", resp.RawText())
+
+	reqs := mock.Requests()
+	if assert.Len(t, reqs, 5) {
+		assert.Equal(t, "POST", reqs[1].Method)
+		assert.Equal(t, `{"name":"mccoy"}`, string(reqs[1].Body))
+		assert.Equal(t, "application/json", reqs[1].Header.Get("Content-Type"))
+	}
+	assert.Empty(t, mock.Unmatched())
+}
+
+func TestMockTransportUnmatched(t *testing.T) {
+	mock := NewMockTransport()
+	mock.On("GET", "/search?q=spock")
+	mock.On("GET", "https://other.test/users")
+	s := mock.Session()
+	for _, u := range []string{"http://api.test/search?q=kirk", "http://api.test/users", "http://api.test/nothing"} {
+		_, err := s.Get(u, nil)
+		assert.Error(t, err)
+	}
+	_, err := s.Delete("https://other.test/users", nil)
+	assert.Contains(t, err.Error(), "nappingtest: no mock route for DELETE https://other.test/users")
+	assert.Equal(t, []string{
+		"GET http://api.test/search?q=kirk",
+		"GET http://api.test/users",
+		"GET http://api.test/nothing",
+		"DELETE https://other.test/users",
+	}, mock.Unmatched())
+}
+
+func TestMockTransportDelay(t *testing.T) {
+	mock := NewMockTransport()
+	mock.On("GET", "/slow").Delay(time.Second)
+	s := mock.Session()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := s.GetCtx(ctx, "http://api.test/slow", nil)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+}
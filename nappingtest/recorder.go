@@ -0,0 +1,31 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package nappingtest
+
+/*
+This module turns the output of http.Handlers into napping Responses, for
+testing code which consumes Responses without a server.
+*/
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/yinyajiang/napping"
+)
+
+// FromRecorder converts what a handler wrote to rec into a Response.
+func FromRecorder(rec *httptest.ResponseRecorder) *napping.Response {
+	res := rec.Result()
+	return napping.NewTestResponse(res.StatusCode, res.Header, rec.Body.Bytes())
+}
+
+// ServeResponse runs handler on req and returns its output as a Response.
+func ServeResponse(handler http.Handler, req *http.Request) *napping.Response {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return FromRecorder(rec)
+}
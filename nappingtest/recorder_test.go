@@ -0,0 +1,40 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package nappingtest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromRecorder(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Type", "application/json")
+	rec.Header().Set("ETag", `"v1"`)
+	rec.WriteHeader(201)
+	rec.Write([]byte(`{"id": 3}`))
+
+	resp := FromRecorder(rec)
+	assert.Equal(t, 201, resp.Status())
+	assert.True(t, resp.IsJsonMime())
+	assert.Equal(t, `"v1"`, resp.HttpResponse().Header.Get("ETag"))
+	var v struct{ ID int }
+	assert.NoError(t, resp.Unmarshal(&v))
+	assert.Equal(t, 3, v.ID)
+}
+
+func TestServeResponse(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "no "+req.URL.Path, http.StatusNotFound)
+	})
+	resp := ServeResponse(h, httptest.NewRequest("GET", "/thing", nil))
+	assert.Equal(t, 404, resp.Status())
+	assert.Equal(t, "no /thing", resp.RawText())
+	assert.False(t, resp.StatusOk())
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module encodes slice payloads as newline-delimited JSON, for bulk
+endpoints such as Elasticsearch's _bulk.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// NDJSONContentType is the media type of newline-delimited JSON.
+const NDJSONContentType = "application/x-ndjson"
+
+// checkNDJSON reports an error if payload cannot be sent as NDJSON.
+func checkNDJSON(payload interface{}) error {
+	switch reflect.ValueOf(payload).Kind() {
+	case reflect.Slice, reflect.Array:
+		return nil
+	}
+	return fmt.Errorf("napping: invalid request: NDJSON payload must be a slice, not %T", payload)
+}
+
+// encodeNDJSON encodes each element of the slice or array v as JSON on a
+// line of its own, each followed by a newline.
+func encodeNDJSON(v interface{}) (*bytes.Buffer, error) {
+	if err := checkNDJSON(v); err != nil {
+		return nil, err
+	}
+	rv := reflect.ValueOf(v)
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i := 0; i < rv.Len(); i++ {
+		if err := enc.Encode(rv.Index(i).Interface()); err != nil {
+			return nil, fmt.Errorf("napping: NDJSON element %d: %w", i, err)
+		}
+	}
+	return &buf, nil
+}
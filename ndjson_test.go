@@ -0,0 +1,58 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNDJSONPayload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		w.Header().Set("X-Content-Type", req.Header.Get("Content-Type"))
+		w.Write(body)
+	}))
+	defer srv.Close()
+	s := Session{}
+
+	docs := []interface{}{
+		map[string]interface{}{"index": map[string]string{"_id": "1"}},
+		map[string]interface{}{"name": "kirk"},
+		[]int{1, 2},
+	}
+	resp, err := s.Send(&Request{Method: "POST", Url: srv.URL, Payload: docs, NDJSON: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, NDJSONContentType, resp.HttpResponse().Header.Get("X-Content-Type"))
+	body := string(resp.RawByte())
+	assert.True(t, strings.HasSuffix(body, "\n"))
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	if assert.Len(t, lines, len(docs)) {
+		for _, line := range lines {
+			assert.True(t, json.Valid([]byte(line)), line)
+		}
+		assert.Equal(t, `{"name":"kirk"}`, lines[1])
+	}
+
+	// Typed slices work too, and a Content-Type header wins.
+	type doc struct{ ID int }
+	resp, err = s.Send(&Request{Method: "POST", Url: srv.URL, Payload: []doc{{1}, {2}}, NDJSON: true,
+		Header: &http.Header{"Content-Type": {"application/json"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"ID\":1}\n{\"ID\":2}\n", string(resp.RawByte()))
+	assert.Equal(t, "application/json", resp.HttpResponse().Header.Get("X-Content-Type"))
+
+	_, err = s.Send(&Request{Method: "POST", Url: srv.URL, Payload: map[string]int{"a": 1}, NDJSON: true})
+	assert.EqualError(t, err, "napping: invalid request: NDJSON payload must be a slice, not map[string]int")
+}
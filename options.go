@@ -0,0 +1,48 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module constructs Sessions from functional options, as an alternative to
+setting their fields directly.
+*/
+
+import (
+	"time"
+)
+
+// A SessionOption configures a Session created by NewSession.
+type SessionOption func(s *Session)
+
+// NewSession returns a Session configured by opts, applied in order.
+func NewSession(opts ...SessionOption) *Session {
+	s := &Session{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// WithConnectTimeout sets Session.ConnectTimeout.
+func WithConnectTimeout(d time.Duration) SessionOption {
+	return func(s *Session) {
+		s.ConnectTimeout = d
+	}
+}
+
+// WithResponseHeaderTimeout sets Session.ResponseHeaderTimeout.
+func WithResponseHeaderTimeout(d time.Duration) SessionOption {
+	return func(s *Session) {
+		s.ResponseHeaderTimeout = d
+	}
+}
+
+// WithBodyTimeout sets Session.BodyTimeout.
+func WithBodyTimeout(d time.Duration) SessionOption {
+	return func(s *Session) {
+		s.BodyTimeout = d
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSession(t *testing.T) {
+	s := NewSession(
+		WithConnectTimeout(2*time.Second),
+		WithResponseHeaderTimeout(10*time.Second),
+		WithBodyTimeout(5*time.Minute),
+	)
+	assert.Equal(t, 2*time.Second, s.ConnectTimeout)
+	assert.Equal(t, 10*time.Second, s.ResponseHeaderTimeout)
+	assert.Equal(t, 5*time.Minute, s.BodyTimeout)
+	assert.Equal(t, &Session{}, NewSession())
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module walks collections paged by offset and limit query parameters,
+or by page number, advancing until the total count is reached.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// DefaultPageSize is the page size of OffsetPaging if it sets none.
+const DefaultPageSize = 100
+
+// ErrStopPaging may be returned by the callback of GetOffsetPaged to stop
+// paging without an error.
+var ErrStopPaging = errors.New("napping: stop paging")
+
+// A PagingMode selects what the offset parameter of OffsetPaging carries.
+type PagingMode int
+
+const (
+	PagingOffset     PagingMode = iota // The index of the first item, from 0
+	PagingPageNumber                   // The number of the page, from 1 unless ZeroBasedPages
+)
+
+// OffsetPaging describes an API paged by query parameters.  The total count
+// of items is learned from TotalHeader or TotalPath, and the items of each
+// page are counted at ItemsPath.  Unless the body is an array of items, at
+// least one of them must be set.
+type OffsetPaging struct {
+	OffsetParam string // Default "offset", or "page" with PagingPageNumber
+	LimitParam  string // Default "limit"
+	PageSize    int    // Default DefaultPageSize
+
+	Mode           PagingMode
+	ZeroBasedPages bool // With PagingPageNumber, the first page is 0
+
+	TotalHeader string // Header holding the total count, e.g. "X-Total-Count"
+	TotalPath   string // JSON path of the total count in the body, e.g. "meta.total"
+
+	// JSON path of the array of items in the body, e.g. "data".  "" means
+	// the body is the array if it is one.
+	ItemsPath string
+}
+
+// GetOffsetPaged sends GET requests for successive pages of url, with the
+// parameters of base plus the offset and limit described by opts, and calls
+// each with every response.  It stops, returning nil, when the total count
+// is reached, when a page has no items, or when each returns ErrStopPaging.
+// Other errors of each, and a status other than 200 as a *StatusError, are
+// returned.  In offset mode, the offset advances by the number of items
+// received, so that servers capping the page size lose nothing.
+func (s *Session) GetOffsetPaged(url string, base *url.Values, opts OffsetPaging, each func(*Response) error) error {
+	return s.GetOffsetPagedCtx(context.Background(), url, base, opts, each)
+}
+
+// GetOffsetPagedCtx is GetOffsetPaged with a context.
+func (s *Session) GetOffsetPagedCtx(ctx context.Context, rawurl string, base *url.Values, opts OffsetPaging, each func(*Response) error) error {
+	offsetParam, limitParam := opts.OffsetParam, opts.LimitParam
+	if offsetParam == "" {
+		offsetParam = "offset"
+		if opts.Mode == PagingPageNumber {
+			offsetParam = "page"
+		}
+	}
+	if limitParam == "" {
+		limitParam = "limit"
+	}
+	size := opts.PageSize
+	if size <= 0 {
+		size = DefaultPageSize
+	}
+	page := 1
+	if opts.ZeroBasedPages {
+		page = 0
+	}
+	offset, seen := 0, 0
+	for {
+		p := url.Values{}
+		if base != nil {
+			for k, v := range *base {
+				p[k] = append([]string(nil), v...)
+			}
+		}
+		if opts.Mode == PagingPageNumber {
+			p.Set(offsetParam, strconv.Itoa(page))
+		} else {
+			p.Set(offsetParam, strconv.Itoa(offset))
+		}
+		p.Set(limitParam, strconv.Itoa(size))
+		resp, err := s.GetCtx(ctx, rawurl, &p)
+		if err != nil {
+			return err
+		}
+		if err = resp.Expect(http.StatusOK); err != nil {
+			return err
+		}
+		total, hasTotal, err := opts.total(resp)
+		if err != nil {
+			return err
+		}
+		count, hasCount := opts.count(resp)
+		if !hasTotal && !hasCount {
+			return fmt.Errorf("napping: paging %s: no total count or items found; set TotalHeader, TotalPath or ItemsPath", rawurl)
+		}
+		if hasCount && count == 0 {
+			return nil
+		}
+		if err = each(resp); err != nil {
+			if err == ErrStopPaging {
+				return nil
+			}
+			return err
+		}
+		if !hasCount {
+			count = size
+		}
+		seen += count
+		offset += count
+		page++
+		if hasTotal && seen >= total {
+			return nil
+		}
+	}
+}
+
+// total returns the total count of items given by resp, if any.
+func (opts *OffsetPaging) total(resp *Response) (n int, ok bool, err error) {
+	if opts.TotalHeader != "" {
+		if v := resp.HttpResponse().Header.Get(opts.TotalHeader); v != "" {
+			n, err = strconv.Atoi(v)
+			if err != nil {
+				return 0, false, fmt.Errorf("napping: paging: bad %s header %q", opts.TotalHeader, v)
+			}
+			return n, true, nil
+		}
+	}
+	if opts.TotalPath != "" {
+		v, err := resp.Path(opts.TotalPath)
+		if err != nil {
+			return 0, false, err
+		}
+		switch t := v.(type) {
+		case float64:
+			return int(t), true, nil
+		case json.Number:
+			i, err := t.Int64()
+			return int(i), err == nil, err
+		case string:
+			n, err = strconv.Atoi(t)
+			return n, err == nil, err
+		}
+		return 0, false, fmt.Errorf("napping: paging: total at %q is %T, not a number", opts.TotalPath, v)
+	}
+	return 0, false, nil
+}
+
+// count returns the number of items in resp, if they can be found.
+func (opts *OffsetPaging) count(resp *Response) (int, bool) {
+	v, err := resp.Path(opts.ItemsPath)
+	if err != nil {
+		return 0, false
+	}
+	items, ok := v.([]interface{})
+	return len(items), ok
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pagedServer serves 25 items, capping the page size at maxLimit.
+func pagedServer(t *testing.T, maxLimit int) *httptest.Server {
+	const n = 25
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		assert.Equal(t, "x", q.Get("filter"))
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		if limit > maxLimit {
+			limit = maxLimit
+		}
+		var offset int
+		if p := q.Get("page"); p != "" {
+			page, _ := strconv.Atoi(p)
+			offset = (page - 1) * limit
+		} else {
+			offset, _ = strconv.Atoi(q.Get("offset"))
+		}
+		items := []int{}
+		for i := offset; i < offset+limit && i < n; i++ {
+			items = append(items, i)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch req.URL.Path {
+		case "/wrapped":
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": items, "meta": map[string]int{"total": n}})
+		case "/header":
+			w.Header().Set("X-Total-Count", strconv.Itoa(n))
+			json.NewEncoder(w).Encode(items)
+		case "/bare":
+			json.NewEncoder(w).Encode(items)
+		default:
+			w.WriteHeader(500)
+		}
+	}))
+}
+
+func TestGetOffsetPaged(t *testing.T) {
+	base := &url.Values{"filter": {"x"}}
+	collect := func(srv *httptest.Server, path string, opts OffsetPaging) (ids []int, pages int, err error) {
+		s := Session{}
+		err = s.GetOffsetPaged(srv.URL+path, base, opts, func(resp *Response) error {
+			pages++
+			var items []int
+			if err := resp.Unmarshal(&items); err != nil {
+				var wrapped struct{ Data []int }
+				resp.Unmarshal(&wrapped)
+				items = wrapped.Data
+			}
+			ids = append(ids, items...)
+			return nil
+		})
+		return
+	}
+	all := make([]int, 25)
+	for i := range all {
+		all[i] = i
+	}
+
+	srv := pagedServer(t, 100)
+	defer srv.Close()
+	ids, pages, err := collect(srv, "/wrapped", OffsetPaging{PageSize: 10, TotalPath: "meta.total", ItemsPath: "data"})
+	assert.NoError(t, err)
+	assert.Equal(t, all, ids)
+	assert.Equal(t, 3, pages)
+
+	// Pages numbered from 1, total in a header.
+	ids, pages, err = collect(srv, "/header", OffsetPaging{Mode: PagingPageNumber, PageSize: 10, TotalHeader: "X-Total-Count"})
+	assert.NoError(t, err)
+	assert.Equal(t, all, ids)
+	assert.Equal(t, 3, pages)
+
+	// No total: stop at the first empty page.
+	ids, pages, err = collect(srv, "/bare", OffsetPaging{PageSize: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, all, ids)
+	assert.Equal(t, 3, pages)
+
+	// No total and no items found.
+	_, _, err = collect(srv, "/wrapped", OffsetPaging{PageSize: 10})
+	assert.Error(t, err)
+
+	_, _, err = collect(srv, "/broken", OffsetPaging{TotalHeader: "X-Total-Count"})
+	var se *StatusError
+	assert.True(t, errors.As(err, &se))
+
+	// The callback stops paging.
+	s := Session{}
+	calls := 0
+	err = s.GetOffsetPaged(srv.URL+"/bare", base, OffsetPaging{PageSize: 10}, func(*Response) error {
+		calls++
+		return ErrStopPaging
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestGetOffsetPagedCapped(t *testing.T) {
+	// The server returns at most 7 items whatever the limit asked for.
+	srv := pagedServer(t, 7)
+	defer srv.Close()
+	s := Session{}
+	var ids []int
+	err := s.GetOffsetPaged(srv.URL+"/header", &url.Values{"filter": {"x"}},
+		OffsetPaging{PageSize: 10, TotalHeader: "X-Total-Count"}, func(resp *Response) error {
+			var items []int
+			err := resp.Unmarshal(&items)
+			ids = append(ids, items...)
+			return err
+		})
+	assert.NoError(t, err)
+	assert.Len(t, ids, 25)
+	assert.Equal(t, 24, ids[24])
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements lookups into decoded JSON documents using simple
+dot-separated paths such as "user.id" or "items.0.name".
+*/
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// splitPath breaks a dot-separated path into its segments.  The empty path
+// refers to the document root.
+func splitPath(path string) []string {
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+// lookupPath walks v, a value produced by encoding/json, following path.
+// Object members are addressed by key and array elements by index.
+func lookupPath(v interface{}, path string) (interface{}, error) {
+	cur := v
+	for i, seg := range splitPath(path) {
+		switch node := cur.(type) {
+		case map[string]interface{}:
+			next, ok := node[seg]
+			if !ok {
+				return nil, fmt.Errorf("napping: path %q: no member %q", path, strings.Join(splitPath(path)[:i+1], "."))
+			}
+			cur = next
+		case []interface{}:
+			idx, err := strconv.Atoi(seg)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, fmt.Errorf("napping: path %q: bad index %q", path, seg)
+			}
+			cur = node[idx]
+		default:
+			return nil, fmt.Errorf("napping: path %q: cannot descend into %T at %q", path, cur, seg)
+		}
+	}
+	return cur, nil
+}
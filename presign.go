@@ -0,0 +1,84 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module transfers data to and from presigned URLs, such as those issued
+by S3 and compatible object stores, whose signature covers the exact URL.
+*/
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// presigned returns a request which sends url exactly as given, with no
+// session params or headers, implicit headers or credentials.
+func presigned(method, url string) *Request {
+	return &Request{
+		Method:           method,
+		Url:              url,
+		PreserveQuery:    true,
+		NoSessionParams:  true,
+		NoSessionHeaders: true,
+		bare:             true,
+	}
+}
+
+// PutPresigned uploads length bytes from body to a presigned URL, streaming
+// them with the given Content-Type.  The URL is sent exactly as given, and
+// no headers other than Content-Type and Content-Length are added; the
+// session's params, headers, credentials and Authorizer are not applied.
+// A negative length sends the body chunked, which not every store accepts.
+func (s *Session) PutPresigned(url string, body io.Reader, contentType string, length int64) (*Response, error) {
+	return s.PutPresignedCtx(context.Background(), url, body, contentType, length)
+}
+
+// PutPresignedCtx is PutPresigned with a context.
+func (s *Session) PutPresignedCtx(ctx context.Context, url string, body io.Reader, contentType string, length int64) (*Response, error) {
+	r := presigned("PUT", url)
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	r.Header = &header
+	switch {
+	case length > 0:
+		r.Payload = body
+		r.ContentLength = length
+	case length < 0:
+		r.Payload = body
+		r.ForceChunked = true
+	}
+	return s.SendWithContext(ctx, r)
+}
+
+// GetPresigned streams the body at a presigned URL into dst.  The URL is
+// sent as for PutPresigned.  If the status is not 200, nothing is written
+// and a *StatusError is returned along with the response.
+func (s *Session) GetPresigned(url string, dst io.Writer) (*Response, error) {
+	return s.GetPresignedCtx(context.Background(), url, dst)
+}
+
+// GetPresignedCtx is GetPresigned with a context.
+func (s *Session) GetPresignedCtx(ctx context.Context, url string, dst io.Writer) (*Response, error) {
+	r := presigned("GET", url)
+	r.NotProcessBody = true
+	resp, err := s.SendWithContext(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	body := resp.HttpResponse().Body
+	defer body.Close()
+	if resp.Status() != http.StatusOK {
+		resp.body, _ = ioutil.ReadAll(io.LimitReader(body, 1<<16))
+		return resp, resp.Expect(http.StatusOK)
+	}
+	_, err = io.Copy(dst, body)
+	return resp, err
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const presignedURI = "/bucket/a%2Bb/key.bin?X-Amz-Signature=ff00&X-Amz-Algorithm=AWS4-HMAC-SHA256" +
+	"&X-Amz-Credential=AKID%2F20240101%2Fus-east-1%2Fs3%2Faws4_request&X-Amz-SignedHeaders=host"
+
+func TestPresigned(t *testing.T) {
+	var got *http.Request
+	var uploaded []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = req
+		if req.Method == "PUT" {
+			uploaded, _ = ioutil.ReadAll(req.Body)
+			return
+		}
+		w.Write([]byte("object data"))
+	}))
+	defer srv.Close()
+
+	s := Session{
+		Params:   &url.Values{"session": {"1"}},
+		Header:   &http.Header{"X-Session": {"1"}},
+		Userinfo: url.UserPassword("user", "pass"),
+	}
+	resp, err := s.PutPresigned(srv.URL+presignedURI, strings.NewReader("payload"), "application/octet-stream", 7)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, presignedURI, got.RequestURI)
+	assert.Equal(t, "payload", string(uploaded))
+	assert.Equal(t, int64(7), got.ContentLength)
+	assert.Equal(t, "application/octet-stream", got.Header.Get("Content-Type"))
+	for _, h := range []string{"Authorization", "Accept", "User-Agent", "X-Session"} {
+		assert.Empty(t, got.Header.Get(h), h)
+	}
+
+	var buf bytes.Buffer
+	_, err = s.GetPresigned(srv.URL+presignedURI, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, presignedURI, got.RequestURI)
+	assert.Equal(t, "object data", buf.String())
+	assert.Empty(t, got.Header.Get("Authorization"))
+
+	_, err = s.GetPresigned(presignedURI, &buf)
+	assert.Error(t, err, "relative URL")
+}
+
+func TestGetPresignedStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(403)
+		w.Write([]byte("<Error><Code>SignatureDoesNotMatch</Code></Error>"))
+	}))
+	defer srv.Close()
+	var buf bytes.Buffer
+	resp, err := (&Session{}).GetPresigned(srv.URL+presignedURI, &buf)
+	var se *StatusError
+	if assert.True(t, errors.As(err, &se)) {
+		assert.Equal(t, 403, se.Status)
+		assert.Contains(t, string(se.Body), "SignatureDoesNotMatch")
+	}
+	assert.Equal(t, 403, resp.Status())
+	assert.Zero(t, buf.Len())
+}
+
+func TestPreserveQuery(t *testing.T) {
+	var uri string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		uri = req.RequestURI
+	}))
+	defer srv.Close()
+	s := Session{Params: &url.Values{"session": {"1"}}}
+	_, err := s.Send(&Request{Method: "GET", Url: srv.URL + "/?b=2&a=1", PreserveQuery: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "/?b=2&a=1", uri)
+	_, err = s.Send(&Request{Method: "GET", Url: srv.URL, PreserveQuery: true, Params: &url.Values{}})
+	assert.Error(t, err)
+}
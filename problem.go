@@ -0,0 +1,106 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module decodes error responses in the problem details format of RFC
+7807.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+)
+
+// ProblemDetails is an error response body in the format of RFC 7807,
+// application/problem+json.  Members other than the standard ones are kept
+// in Extensions.
+type ProblemDetails struct {
+	Type       string                 `json:"type,omitempty"`
+	Title      string                 `json:"title,omitempty"`
+	Status     int                    `json:"status,omitempty"`
+	Detail     string                 `json:"detail,omitempty"`
+	Instance   string                 `json:"instance,omitempty"`
+	Extensions map[string]interface{} `json:"-"`
+}
+
+// UnmarshalJSON decodes the standard members of a problem into their
+// fields, and the others into Extensions.
+func (p *ProblemDetails) UnmarshalJSON(data []byte) error {
+	type standard ProblemDetails
+	var std standard
+	if err := json.Unmarshal(data, &std); err != nil {
+		return err
+	}
+	var all map[string]interface{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	for _, k := range []string{"type", "title", "status", "detail", "instance"} {
+		delete(all, k)
+	}
+	*p = ProblemDetails(std)
+	if len(all) > 0 {
+		p.Extensions = all
+	}
+	return nil
+}
+
+// Error returns the title and detail of the problem, so that it can be
+// returned as an error.
+func (p *ProblemDetails) Error() string {
+	var b strings.Builder
+	b.WriteString("napping: problem")
+	if p.Status != 0 {
+		fmt.Fprintf(&b, " %d", p.Status)
+	}
+	if p.Title != "" {
+		b.WriteString(" " + p.Title)
+	}
+	if p.Detail != "" {
+		b.WriteString(": " + p.Detail)
+	}
+	if p.Type != "" && p.Type != "about:blank" {
+		fmt.Fprintf(&b, " (%s)", p.Type)
+	}
+	return b.String()
+}
+
+// isProblemMime reports whether contentType is application/problem+json,
+// or a vendor variant of it such as application/vnd.acme.problem+json.
+func isProblemMime(contentType string) bool {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	return mt == "application/problem+json" ||
+		strings.HasPrefix(mt, "application/") && strings.HasSuffix(mt, ".problem+json")
+}
+
+// decodeProblem decodes the body of an unsuccessful response in problem
+// details format into r.problem.  Malformed problems are ignored.
+func (r *Request) decodeProblem() {
+	r.problem = nil
+	if r.response.StatusCode < 300 || !isProblemMime(r.response.Header.Get("Content-Type")) {
+		return
+	}
+	src := r.bodyReader()
+	if c, ok := src.(io.Closer); ok {
+		defer c.Close()
+	}
+	var p ProblemDetails
+	if err := json.NewDecoder(src).Decode(&p); err == nil {
+		r.problem = &p
+	}
+}
+
+// Problem returns the problem details of an unsuccessful response of type
+// application/problem+json, as defined by RFC 7807.  ok is false for other
+// responses, and if the body is not a JSON object.  Request.Error, if set,
+// is still decoded from the same body.
+func (r *Response) Problem() (p *ProblemDetails, ok bool) {
+	return r.problem, r.problem != nil
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProblemDetails(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/invalid":
+			w.Header().Set("Content-Type", "application/problem+json; charset=utf-8")
+			w.WriteHeader(422)
+			w.Write([]byte(`{
+				"type": "https://example.com/probs/invalid",
+				"title": "Invalid order",
+				"status": 422,
+				"detail": "quantity must be positive",
+				"instance": "/orders/7",
+				"invalid-params": [{"name": "quantity"}]
+			}`))
+		case "/vendor":
+			w.Header().Set("Content-Type", "application/vnd.acme.problem+json")
+			w.WriteHeader(500)
+			w.Write([]byte(`{"title": "Boom"}`))
+		case "/plain":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(422)
+			w.Write([]byte(`{"title": "not a problem"}`))
+		case "/ok":
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.Write([]byte(`{"title": "fine"}`))
+		}
+	}))
+	defer srv.Close()
+
+	var e map[string]interface{}
+	resp, err := Send(&Request{Url: srv.URL + "/invalid", Method: "GET", Error: &e})
+	assert.NoError(t, err)
+	assert.Equal(t, 422, resp.Status())
+	p, ok := resp.Problem()
+	if assert.True(t, ok) {
+		assert.Equal(t, "https://example.com/probs/invalid", p.Type)
+		assert.Equal(t, "Invalid order", p.Title)
+		assert.Equal(t, 422, p.Status)
+		assert.Equal(t, "quantity must be positive", p.Detail)
+		assert.Equal(t, "/orders/7", p.Instance)
+		assert.Equal(t, []interface{}{map[string]interface{}{"name": "quantity"}}, p.Extensions["invalid-params"])
+		assert.Len(t, p.Extensions, 1)
+		assert.EqualError(t, p, "napping: problem 422 Invalid order: quantity must be positive (https://example.com/probs/invalid)")
+	}
+	assert.Equal(t, "Invalid order", e["title"])
+
+	resp, _ = Get(srv.URL+"/vendor", nil)
+	p, ok = resp.Problem()
+	assert.True(t, ok)
+	assert.EqualError(t, p, "napping: problem Boom")
+
+	for _, path := range []string{"/plain", "/ok"} {
+		resp, _ = Get(srv.URL+path, nil)
+		_, ok = resp.Problem()
+		assert.False(t, ok, path)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module encodes and decodes protocol buffer messages, without depending
+on any protobuf package: messages are recognised by their ProtoMessage
+method, as generated by every protobuf compiler plugin for Go.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+)
+
+// ProtoContentType is the content type of protobuf request bodies.
+const ProtoContentType = "application/x-protobuf"
+
+// protoMessage is implemented by all generated protobuf messages.
+type protoMessage interface {
+	ProtoMessage()
+}
+
+// ProtoMarshal and ProtoUnmarshal encode and decode protobuf messages which
+// do not have Marshal and Unmarshal methods of their own, as is the case for
+// messages generated by google.golang.org/protobuf.  Set them to adapters
+// for that package:
+//
+//	napping.ProtoMarshal = func(m interface{}) ([]byte, error) {
+//		return proto.Marshal(m.(proto.Message))
+//	}
+//	napping.ProtoUnmarshal = func(b []byte, m interface{}) error {
+//		return proto.Unmarshal(b, m.(proto.Message))
+//	}
+var (
+	ProtoMarshal   func(m interface{}) ([]byte, error)
+	ProtoUnmarshal func(b []byte, m interface{}) error
+)
+
+var errNoProtoCodec = errors.New("napping: protobuf message has no Marshal/Unmarshal method and ProtoMarshal/ProtoUnmarshal are not set")
+
+// isProto reports whether v is a protobuf message.
+func isProto(v interface{}) bool {
+	_, ok := v.(protoMessage)
+	return ok
+}
+
+// marshalProto encodes protobuf message m.
+func marshalProto(m interface{}) ([]byte, error) {
+	if pm, ok := m.(interface{ Marshal() ([]byte, error) }); ok {
+		return pm.Marshal()
+	}
+	if ProtoMarshal == nil {
+		return nil, errNoProtoCodec
+	}
+	return ProtoMarshal(m)
+}
+
+// unmarshalProto decodes b into protobuf message m.
+func unmarshalProto(b []byte, m interface{}) error {
+	if pm, ok := m.(interface{ Unmarshal([]byte) error }); ok {
+		return pm.Unmarshal(b)
+	}
+	if ProtoUnmarshal == nil {
+		return errNoProtoCodec
+	}
+	if err := ProtoUnmarshal(b, m); err != nil {
+		return fmt.Errorf("napping: decoding protobuf: %w", err)
+	}
+	return nil
+}
+
+// isProtoMime reports whether contentType denotes a protobuf body.
+func isProtoMime(contentType string) bool {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch mt {
+	case "application/x-protobuf", "application/protobuf", "application/vnd.google.protobuf":
+		return true
+	}
+	return false
+}
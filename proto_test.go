@@ -0,0 +1,112 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// greeting is a protobuf message with a single field, `string name = 1`,
+// encoded by hand in the protobuf wire format.
+type greeting struct {
+	Name string `json:"name"`
+}
+
+func (g *greeting) ProtoMessage() {}
+
+func (g *greeting) Marshal() ([]byte, error) {
+	b := make([]byte, 1+binary.MaxVarintLen64, 1+binary.MaxVarintLen64+len(g.Name))
+	b[0] = 0x0a // Field 1, wire type 2
+	n := binary.PutUvarint(b[1:], uint64(len(g.Name)))
+	return append(b[:1+n], g.Name...), nil
+}
+
+func (g *greeting) Unmarshal(b []byte) error {
+	g.Name = ""
+	if len(b) == 0 {
+		return nil
+	}
+	if b[0] != 0x0a {
+		return errors.New("unexpected field")
+	}
+	n, k := binary.Uvarint(b[1:])
+	if k <= 0 || uint64(len(b)-1-k) < n {
+		return errors.New("truncated")
+	}
+	g.Name = string(b[1+k : 1+k+int(n)])
+	return nil
+}
+
+// bareGreeting has no codec methods, like messages generated by
+// google.golang.org/protobuf.  The methods below hide those of the
+// embedded greeting.
+type bareGreeting struct{ greeting }
+
+func (g *bareGreeting) Marshal()   {}
+func (g *bareGreeting) Unmarshal() {}
+
+func protoEcho(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "application/x-protobuf", req.Header.Get("Content-Type"))
+		assert.Equal(t, "application/x-protobuf, application/json;q=0.9", req.Header.Get("Accept"))
+		var in greeting
+		b, _ := ioutil.ReadAll(req.Body)
+		if err := in.Unmarshal(b); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(400)
+			w.Write([]byte(`{"name":"` + err.Error() + `"}`))
+			return
+		}
+		out := greeting{Name: "hello, " + in.Name}
+		b, _ = out.Marshal()
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(b)
+	}))
+}
+
+func TestProtoRoundTrip(t *testing.T) {
+	srv := protoEcho(t)
+	defer srv.Close()
+	var res greeting
+	resp, err := Send(&Request{Method: "POST", Url: srv.URL, Payload: &greeting{Name: "kirk"}, Result: &res})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, "hello, kirk", res.Name)
+
+	// Error responses in JSON are still decoded as JSON.
+	var e greeting
+	resp, err = Send(&Request{Method: "POST", Url: srv.URL, Payload: []byte{0x12}, Header: &http.Header{"Content-Type": {"application/x-protobuf"}}, Result: &res, Error: &e})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 400, resp.Status())
+	assert.Equal(t, "unexpected field", e.Name)
+}
+
+func TestProtoCodecHooks(t *testing.T) {
+	srv := protoEcho(t)
+	defer srv.Close()
+	_, err := Send(&Request{Method: "POST", Url: srv.URL, Payload: &bareGreeting{greeting{Name: "spock"}}})
+	assert.Equal(t, errNoProtoCodec, err)
+
+	ProtoMarshal = func(m interface{}) ([]byte, error) { return m.(*bareGreeting).greeting.Marshal() }
+	ProtoUnmarshal = func(b []byte, m interface{}) error { return m.(*bareGreeting).greeting.Unmarshal(b) }
+	defer func() { ProtoMarshal, ProtoUnmarshal = nil, nil }()
+	var res bareGreeting
+	if _, err = Send(&Request{Method: "POST", Url: srv.URL, Payload: &bareGreeting{greeting{Name: "spock"}}, Result: &res}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "hello, spock", res.Name)
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module configures forward proxies, including proxies which are
+themselves reached over TLS (https:// proxy URLs).
+*/
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// A ProxyError reports that no connection or tunnel could be established
+// through Session.Proxy, as opposed to an error from the origin server.
+type ProxyError struct {
+	Proxy      string // Host of the proxy
+	StatusCode int    // Status of a refused CONNECT, e.g. 407
+	Status     string
+	Err        error // Connection or TLS error, if the proxy was unreachable
+}
+
+func (e *ProxyError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("napping: proxy %s: %v", e.Proxy, e.Err)
+	}
+	return fmt.Sprintf("napping: proxy %s refused CONNECT: %s", e.Proxy, e.Status)
+}
+
+func (e *ProxyError) Unwrap() error {
+	return e.Err
+}
+
+// configureProxy makes t use s.Proxy.  Credentials are sent in the
+// Proxy-Authorization header of both proxied requests and CONNECT.  For an
+// https:// proxy, the TLS connection to the proxy is made here with
+// s.ProxyTLSConfig, so that t.TLSClientConfig applies to origins only.
+func (s *Session) configureProxy(t *http.Transport) {
+	proxy := *s.Proxy
+	if s.ProxyUserinfo != nil {
+		proxy.User = s.ProxyUserinfo
+	}
+	host := proxy.Host
+	t.OnProxyConnectResponse = func(ctx context.Context, _ *url.URL, _ *http.Request, resp *http.Response) error {
+		if resp.StatusCode != http.StatusOK {
+			return &ProxyError{Proxy: host, StatusCode: resp.StatusCode, Status: resp.Status}
+		}
+		return nil
+	}
+	if proxy.Scheme == "https" {
+		addr := proxy.Host
+		if proxy.Port() == "" {
+			addr = net.JoinHostPort(proxy.Hostname(), "443")
+		}
+		cfg := s.ProxyTLSConfig.Clone()
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = proxy.Hostname()
+		}
+		dial := t.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{}).DialContext
+		}
+		t.DialContext = func(ctx context.Context, network, a string) (net.Conn, error) {
+			conn, err := dial(ctx, network, a)
+			if err != nil || a != addr {
+				return conn, err
+			}
+			tc := tls.Client(conn, cfg)
+			if err := tc.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tc, nil
+		}
+		// The transport now sees a plain connection to the proxy.
+		proxy.Scheme = "http"
+		proxy.Host = addr
+	}
+	t.Proxy = http.ProxyURL(&proxy)
+}
+
+// recordProxy wraps the Proxy function of a transport so that the proxy it
+// chooses for a request is recorded on the request's tracer, without
+// credentials.  A Session.Proxy is reported as configured, not as rewritten
+// for https:// proxies.
+func (s *Session) recordProxy(next func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		u, err := next(req)
+		tr := tracerFrom(req.Context())
+		if err != nil || u == nil || tr == nil {
+			return u, err
+		}
+		used := *u
+		if s.Proxy != nil {
+			used = *s.Proxy
+		}
+		used.User = nil
+		tr.mu.Lock()
+		tr.proxy = &used
+		tr.mu.Unlock()
+		return u, err
+	}
+}
+
+// defaultProxy returns the proxy http.DefaultTransport chooses for req, if
+// any, without credentials.
+func defaultProxy(req *http.Request) *url.URL {
+	t, ok := http.DefaultTransport.(*http.Transport)
+	if !ok || t.Proxy == nil {
+		return nil
+	}
+	u, err := t.Proxy(req)
+	if err != nil || u == nil {
+		return nil
+	}
+	used := *u
+	used.User = nil
+	return &used
+}
+
+// UsedProxy returns the proxy the request was routed through, without
+// credentials, whether Session.Proxy or one chosen from the environment.
+// ok is false for direct connections, and when the session's Client or
+// Transport, or a Request.Transport, was supplied, as then the choice is
+// not seen.
+func (r *Response) UsedProxy() (proxy *url.URL, ok bool) {
+	return r.proxy, r.proxy != nil
+}
+
+// proxyError maps a failure to reach the proxy to a *ProxyError.
+func (s *Session) proxyError(err error) error {
+	var pe *ProxyError
+	if s.Proxy == nil || errors.As(err, &pe) {
+		return err
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "proxyconnect" {
+		return &ProxyError{Proxy: s.Proxy.Host, Err: opErr.Err}
+	}
+	return err
+}
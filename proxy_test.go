@@ -0,0 +1,170 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTLSProxy starts a forward proxy reached over TLS, which tunnels CONNECT
+// requests and forwards others, requiring the given credentials.
+func newTLSProxy(t *testing.T, user, pass string) *httptest.Server {
+	want := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Proxy-Authorization") != want {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		if req.Method != "CONNECT" {
+			out := req.Clone(req.Context())
+			out.RequestURI = ""
+			out.Header.Del("Proxy-Authorization")
+			resp, err := http.DefaultTransport.RoundTrip(out)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			defer resp.Body.Close()
+			w.Header().Set("Via", "test-proxy")
+			w.WriteHeader(resp.StatusCode)
+			io.Copy(w, resp.Body)
+			return
+		}
+		upstream, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		go func() {
+			io.Copy(upstream, rw)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+	}))
+}
+
+// trusting returns a TLS configuration trusting the certificate of srv.
+func trusting(srv *httptest.Server) *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	return &tls.Config{RootCAs: pool}
+}
+
+func TestHTTPSProxy(t *testing.T) {
+	proxy := newTLSProxy(t, "spock", "fascinating")
+	defer proxy.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "", req.Header.Get("Proxy-Authorization"))
+		w.Write([]byte("plain origin"))
+	}))
+	defer origin.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("secure origin"))
+	}))
+	defer secure.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	proxyURL.User = url.UserPassword("spock", "fascinating")
+
+	s := Session{Proxy: proxyURL, ProxyTLSConfig: trusting(proxy)}
+	resp, err := s.Get(origin.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "plain origin", resp.RawText())
+	assert.Equal(t, "test-proxy", resp.HttpResponse().Header.Get("Via"))
+
+	// The origin is verified with the transport's own TLS configuration.
+	s = Session{Proxy: proxyURL, ProxyTLSConfig: trusting(proxy)}
+	resp, err = s.Send(&Request{Url: secure.URL, Transport: &http.Transport{TLSClientConfig: trusting(secure)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "secure origin", resp.RawText())
+}
+
+func TestProxyError(t *testing.T) {
+	proxy := newTLSProxy(t, "spock", "fascinating")
+	defer proxy.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer secure.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	// Wrong credentials: the tunnel is refused.
+	s := Session{Proxy: proxyURL, ProxyUserinfo: url.UserPassword("spock", "wrong"), ProxyTLSConfig: trusting(proxy)}
+	_, err := s.Send(&Request{Url: secure.URL, Transport: &http.Transport{TLSClientConfig: trusting(secure)}})
+	var pe *ProxyError
+	if assert.True(t, errors.As(err, &pe), "%v", err) {
+		assert.Equal(t, 407, pe.StatusCode)
+		assert.Equal(t, proxyURL.Host, pe.Proxy)
+		assert.NotContains(t, err.Error(), "wrong")
+	}
+
+	// Untrusted proxy certificate.
+	s = Session{Proxy: proxyURL, ProxyUserinfo: url.UserPassword("spock", "fascinating")}
+	_, err = s.Get(secure.URL, nil)
+	pe = nil
+	if assert.True(t, errors.As(err, &pe), "%v", err) {
+		var certErr *tls.CertificateVerificationError
+		assert.True(t, errors.As(err, &certErr), "%v", err)
+	}
+
+	// Origin errors are not proxy errors.
+	s = Session{Proxy: proxyURL, ProxyUserinfo: url.UserPassword("spock", "fascinating"), ProxyTLSConfig: trusting(proxy)}
+	_, err = s.Get(secure.URL, nil)
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &pe), "%v", err)
+}
+
+func TestUsedProxy(t *testing.T) {
+	proxy := newTLSProxy(t, "spock", "fascinating")
+	defer proxy.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("origin"))
+	}))
+	defer origin.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	proxyURL.User = url.UserPassword("spock", "fascinating")
+
+	s := Session{Proxy: proxyURL, ProxyTLSConfig: trusting(proxy)}
+	resp, err := s.Get(origin.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used, ok := resp.UsedProxy()
+	assert.True(t, ok)
+	if assert.NotNil(t, used) {
+		assert.Equal(t, proxy.URL, used.String())
+		assert.Nil(t, used.User)
+	}
+
+	// A direct request reports no proxy.
+	s = Session{}
+	resp, err = s.Get(origin.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used, ok = resp.UsedProxy()
+	assert.False(t, ok)
+	assert.Nil(t, used)
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module encodes structs into query parameters using `query` field tags.
+*/
+
+import (
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EncodeQuery encodes the exported fields of a struct, or pointer to struct,
+// as query parameters.  The key is given by the field's `query` tag, or the
+// field name if there is none, and a tag of "-" skips the field:
+//
+//	type Filter struct {
+//		Status []string  `query:"status"`         // status=a&status=b
+//		Since  time.Time `query:"since,omitempty"` // RFC 3339
+//		Owner  *string   `query:"owner"`          // omitted if nil
+//	}
+//
+// Slices and arrays produce one value per element.  Nil pointers are always
+// omitted, and the omitempty option also omits zero values.  Values which
+// implement encoding.TextMarshaler, such as time.Time, are encoded with it.
+// Fields of embedded structs are promoted.
+func EncodeQuery(v interface{}) (url.Values, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return url.Values{}, nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("napping: cannot encode %T as query parameters", v)
+	}
+	values := url.Values{}
+	if err := encodeQueryStruct(values, rv); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func encodeQueryStruct(values url.Values, rv reflect.Value) error {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("query")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fv := rv.Field(i)
+		if f.Anonymous && name == "" {
+			for fv.Kind() == reflect.Ptr && !fv.IsNil() {
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct && !fv.Type().Implements(textMarshalerType) {
+				if err := encodeQueryStruct(values, fv); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		omitEmpty := opts == "omitempty"
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		if omitEmpty && fv.IsZero() {
+			continue
+		}
+		if fv.Kind() == reflect.Array || fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+			for j := 0; j < fv.Len(); j++ {
+				s, err := queryValue(fv.Index(j))
+				if err != nil {
+					return fmt.Errorf("napping: query field %s: %w", f.Name, err)
+				}
+				values.Add(name, s)
+			}
+			continue
+		}
+		s, err := queryValue(fv)
+		if err != nil {
+			return fmt.Errorf("napping: query field %s: %w", f.Name, err)
+		}
+		values.Add(name, s)
+	}
+	return nil
+}
+
+// queryValue formats a single query parameter value.
+func queryValue(v reflect.Value) (string, error) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339), nil
+	}
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		b, err := m.MarshalText()
+		return string(b), err
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes()), nil
+		}
+	}
+	return "", fmt.Errorf("unsupported kind %s", v.Kind())
+}
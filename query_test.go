@@ -0,0 +1,79 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type paging struct {
+	Page    int `query:"page,omitempty"`
+	PerPage int `query:"per_page"`
+}
+
+type filter struct {
+	paging
+	Status   []string   `query:"status"`
+	Owner    *string    `query:"owner"`
+	Archived *bool      `query:"archived"`
+	Since    time.Time  `query:"since,omitempty"`
+	Before   *time.Time `query:"before"`
+	Label    string     `query:"label,omitempty"`
+	Score    float64
+	Internal string `query:"-"`
+	secret   string
+}
+
+func TestEncodeQuery(t *testing.T) {
+	no := false
+	before := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	v, err := EncodeQuery(&filter{
+		paging:   paging{PerPage: 50},
+		Status:   []string{"open", "pending"},
+		Archived: &no,
+		Before:   &before,
+		Score:    0.5,
+		Internal: "x",
+		secret:   "y",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Score=0.5&archived=false&before=2015-10-21T07%3A28%3A00Z&per_page=50&status=open&status=pending", v.Encode())
+
+	_, err = EncodeQuery(map[string]string{})
+	assert.Error(t, err)
+	_, err = EncodeQuery(struct {
+		C chan int `query:"c"`
+	}{make(chan int)})
+	assert.Error(t, err)
+}
+
+func TestParamsStruct(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.URL.RawQuery))
+	}))
+	defer srv.Close()
+	owner := "kirk"
+	p := Params{"per_page": "10"}.AsUrlValues()
+	resp, err := Send(&Request{
+		Url:          srv.URL + "/?page=1",
+		Params:       &p,
+		ParamsStruct: filter{paging: paging{Page: 3, PerPage: 20}, Owner: &owner, Status: []string{"open"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Score=0&owner=kirk&page=3&per_page=10&status=open", resp.RawText())
+
+	_, err = Send(&Request{Url: srv.URL, ParamsStruct: 42})
+	assert.Error(t, err)
+}
@@ -0,0 +1,216 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module races a request against several base URLs, e.g. the regions of
+a service, and takes the first successful response.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"time"
+)
+
+// DefaultRaceDelay is the stagger between the attempts of Session.Race if
+// Session.RaceDelay is zero.
+const DefaultRaceDelay = 100 * time.Millisecond
+
+// Race sends r to each of urls, and returns the first successful response,
+// i.e. one without error and with a status below 500.  urls are base URLs
+// below which r.Url, if not empty, is joined as for BaseURL.  Attempts start
+// in order, each RaceDelay after the previous one or as soon as it fails,
+// so that a healthy first URL takes all the load.  Once one succeeds the
+// others are canceled.  Its URL is returned by Response.Endpoint, and its
+// Result, Error and Meta are copied into r.  Each attempt decodes into a
+// zero value of its own, so values already held by r.Result and r.Error
+// are replaced rather than merged into.  If all fail, the error is an
+// *AttemptsError with one error per URL.  Only idempotent methods may race,
+// unless r.AllowUnsafeRace is set, and io.Reader payloads cannot be raced.
+func (s *Session) Race(urls []string, r *Request) (*Response, error) {
+	return s.RaceWithContext(context.Background(), urls, r)
+}
+
+// raceResult is the outcome of one attempt of Race.
+type raceResult struct {
+	idx  int
+	resp *Response
+	err  error
+}
+
+// RaceWithContext is Race with a context.
+func (s *Session) RaceWithContext(ctx context.Context, urls []string, r *Request) (*Response, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("napping: Race needs at least one URL")
+	}
+	method := r.Method
+	if method == "" {
+		method = "GET"
+	}
+	if !isIdempotent(method) && !r.AllowUnsafeRace {
+		return nil, fmt.Errorf("napping: Race of non-idempotent method %s; set AllowUnsafeRace", method)
+	}
+	if _, ok := r.Payload.(io.Reader); ok {
+		return nil, errors.New("napping: Race cannot replay an io.Reader payload")
+	}
+	reqs := make([]*Request, len(urls))
+	for i, raw := range urls {
+		base, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("napping: Race URL: %w", err)
+		}
+		target := base
+		if r.Url != "" {
+			ref, err := url.Parse(r.Url)
+			if err != nil {
+				return nil, fmt.Errorf("napping: invalid request: %w", err)
+			}
+			if target, err = joinURL(base, ref); err != nil {
+				return nil, err
+			}
+		}
+		c := *r
+		c.Url = target.String()
+		c.Result = freshTarget(r.Result)
+		c.Error = freshTarget(r.Error)
+		reqs[i] = &c
+	}
+	s.client(r) // Created here rather than by racing attempts
+
+	delay := s.RaceDelay
+	if delay <= 0 {
+		delay = DefaultRaceDelay
+	}
+	results := make(chan raceResult, len(urls))
+	cancels := make([]context.CancelFunc, len(urls))
+	launched, pending := 0, 0
+	launch := func() {
+		i := launched
+		actx, cancel := context.WithCancel(ctx)
+		cancels[i] = cancel
+		launched++
+		pending++
+		go func() {
+			resp, err := s.SendWithContext(actx, reqs[i])
+			if err == nil && resp.Status() >= 500 {
+				err = fmt.Errorf("napping: %s: status %d", reqs[i].Url, resp.Status())
+				discard(resp)
+				resp = nil
+			}
+			results <- raceResult{i, resp, err}
+		}()
+	}
+
+	launch()
+	errs := make([]error, len(urls))
+	var stagger <-chan struct{}
+	var stopStagger context.CancelFunc = func() {}
+	defer func() { stopStagger() }()
+	for {
+		if stagger == nil && launched < len(urls) {
+			sctx, cancel := context.WithCancel(ctx)
+			stopStagger = cancel
+			ch := make(chan struct{})
+			go func() {
+				if s.clock().Sleep(sctx, delay) == nil {
+					close(ch)
+				}
+			}()
+			stagger = ch
+		}
+		select {
+		case <-stagger:
+			stagger = nil
+			launch()
+			continue
+		case res := <-results:
+			pending--
+			if res.err != nil {
+				errs[res.idx] = res.err
+				if launched < len(urls) {
+					stopStagger()
+					stagger = nil
+					launch()
+				} else if pending == 0 {
+					var all []error
+					for _, err := range errs {
+						if err != nil {
+							all = append(all, err)
+						}
+					}
+					for _, cancel := range cancels {
+						cancel()
+					}
+					return nil, &AttemptsError{Errs: all}
+				}
+				continue
+			}
+			for i, cancel := range cancels {
+				if i != res.idx && cancel != nil {
+					cancel()
+				}
+			}
+			// Losers still running are discarded as they finish.
+			go func(n int) {
+				for ; n > 0; n-- {
+					discard((<-results).resp)
+				}
+			}(pending)
+			if !r.NotProcessBody {
+				cancels[res.idx]()
+			}
+			return s.raceWinner(r, reqs[res.idx], urls[res.idx], res.resp), nil
+		}
+	}
+}
+
+// raceWinner copies the decoded targets and Meta of the winning attempt c
+// into r, and returns its response.
+func (s *Session) raceWinner(r, c *Request, u string, resp *Response) *Response {
+	copyTarget(r.Result, c.Result)
+	copyTarget(r.Error, c.Error)
+	r.Meta = c.Meta
+	resp.Result, resp.Error, resp.Meta = r.Result, r.Error, r.Meta
+	resp.endpoint = u
+	return resp
+}
+
+// freshTarget returns a new pointer to a zero value of the type v points
+// to, so that concurrent attempts do not decode into the same value, nor
+// into maps, slices or pointers it holds.
+func freshTarget(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	if v == nil || rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return v
+	}
+	return reflect.New(rv.Elem().Type()).Interface()
+}
+
+// copyTarget stores the value src points to into dst, as made by
+// freshTarget.
+func copyTarget(dst, src interface{}) {
+	dv := reflect.ValueOf(dst)
+	if dst == nil || dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return
+	}
+	dv.Elem().Set(reflect.ValueOf(src).Elem())
+}
+
+// discard releases the body of a response nobody will read.
+func discard(resp *Response) {
+	if resp == nil {
+		return
+	}
+	if resp.response != nil {
+		resp.response.Body.Close()
+	}
+	resp.Close()
+}
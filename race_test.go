@@ -0,0 +1,139 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// regionServer answers /item with its name after delay, or with status if
+// it is not zero.
+func regionServer(name string, delay time.Duration, status int, hits *int32, canceled chan<- string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(hits, 1)
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			if canceled != nil {
+				canceled <- name
+			}
+			return
+		}
+		if status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"region":"` + name + `","path":"` + req.URL.Path + `"}`))
+	}))
+}
+
+type regionResult struct {
+	Region string
+	Path   string
+}
+
+func TestRace(t *testing.T) {
+	var hitsA, hitsB int32
+	canceled := make(chan string, 2)
+	slow := regionServer("a", 2*time.Second, 0, &hitsA, canceled)
+	defer slow.Close()
+	fast := regionServer("b", 0, 0, &hitsB, nil)
+	defer fast.Close()
+	s := Session{RaceDelay: 20 * time.Millisecond}
+
+	// Warm up the client serially.
+	s.Get(fast.URL, nil)
+	atomic.StoreInt32(&hitsB, 0)
+
+	var res regionResult
+	r := Request{Url: "item", Result: &res}
+	resp, err := s.Race([]string{slow.URL + "/v1", fast.URL + "/v1"}, &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, regionResult{"b", "/v1/item"}, res)
+	assert.Equal(t, fast.URL+"/v1", resp.Endpoint())
+	assert.Equal(t, &res, resp.Result)
+	select {
+	case name := <-canceled:
+		assert.Equal(t, "a", name)
+	case <-time.After(time.Second):
+		t.Error("slow attempt not canceled")
+	}
+
+	// A healthy first URL takes all the load.
+	atomic.StoreInt32(&hitsA, 0)
+	atomic.StoreInt32(&hitsB, 0)
+	s.RaceDelay = time.Second
+	resp, err = s.Race([]string{fast.URL, slow.URL}, &Request{Url: "item"})
+	assert.NoError(t, err)
+	assert.Equal(t, fast.URL, resp.Endpoint())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hitsB))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&hitsA))
+}
+
+func TestRaceFailures(t *testing.T) {
+	var hits int32
+	broken := regionServer("a", 0, 503, &hits, nil)
+	defer broken.Close()
+	ok := regionServer("b", 0, 0, &hits, nil)
+	defer ok.Close()
+	s := Session{RaceDelay: 5 * time.Second}
+	s.Get(ok.URL, nil)
+
+	// A failure starts the next attempt at once.
+	start := time.Now()
+	var res regionResult
+	resp, err := s.Race([]string{broken.URL, ok.URL}, &Request{Url: "/item", Result: &res})
+	assert.NoError(t, err)
+	assert.Equal(t, ok.URL, resp.Endpoint())
+	assert.Equal(t, "b", res.Region)
+	assert.True(t, time.Since(start) < time.Second)
+
+	resp, err = s.Race([]string{broken.URL, broken.URL + "/again"}, &Request{})
+	assert.Nil(t, resp)
+	var ae *AttemptsError
+	if assert.True(t, errors.As(err, &ae), "%v", err) {
+		assert.Len(t, ae.Errs, 2)
+		assert.Contains(t, ae.Error(), "status 503")
+	}
+
+	_, err = s.Race([]string{ok.URL}, &Request{Method: "POST", Payload: map[string]int{"a": 1}})
+	assert.Error(t, err)
+	resp, err = s.Race([]string{ok.URL}, &Request{Method: "POST", Payload: map[string]int{"a": 1}, AllowUnsafeRace: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.Status())
+}
+
+func TestRaceMapResult(t *testing.T) {
+	var hitsA, hitsB int32
+	a := regionServer("a", 0, 0, &hitsA, nil)
+	defer a.Close()
+	b := regionServer("b", 0, 0, &hitsB, nil)
+	defer b.Close()
+	s := Session{RaceDelay: time.Nanosecond}
+	s.Get(a.URL, nil)
+
+	// Attempts decode into maps of their own, not into the caller's.
+	for i := 0; i < 20; i++ {
+		res := map[string]interface{}{"stale": true}
+		resp, err := s.Race([]string{a.URL, b.URL}, &Request{Url: "item", Result: &res})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Contains(t, []string{"a", "b"}, res["region"])
+		assert.NotContains(t, res, "stale")
+		assert.Equal(t, &res, resp.Result)
+	}
+}
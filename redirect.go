@@ -0,0 +1,101 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module controls which redirects are followed, and reports those that
+were.
+*/
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// redirectClient returns client, or a copy of it which stops at the
+// redirects r asks not to follow, and restores s.PreserveHeadersOnRedirect
+// and applies s.HeaderPolicy to the others.
+func (s *Session) redirectClient(client *http.Client, r *Request) *http.Client {
+	if !r.NoRedirect && !r.NoFollowSeeOther && s.HeaderPolicy == nil && len(s.PreserveHeadersOnRedirect) == 0 {
+		return client
+	}
+	c := *client
+	next := client.CheckRedirect
+	policy := s.HeaderPolicy
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if r.NoRedirect || r.NoFollowSeeOther && req.Response != nil && req.Response.StatusCode == http.StatusSeeOther {
+			return http.ErrUseLastResponse
+		}
+		if next != nil {
+			if err := next(req, via); err != nil {
+				return err
+			}
+		} else if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		s.preserveHeaders(req, via[0])
+		_, err := policy.apply(req.URL.Hostname(), req.Header)
+		return err
+	}
+	return &c
+}
+
+// preserveHeaders copies the headers listed in s.PreserveHeadersOnRedirect
+// from the original request to the redirected req, if it goes to the same
+// host without leaving HTTPS, or to any host with
+// PreserveHeadersCrossHost.  net/http strips some headers, such as
+// Authorization, on redirects to other hosts.
+func (s *Session) preserveHeaders(req, orig *http.Request) {
+	if len(s.PreserveHeadersOnRedirect) == 0 {
+		return
+	}
+	downgrade := orig.URL.Scheme == "https" && req.URL.Scheme != "https"
+	sameHost := strings.EqualFold(req.URL.Host, orig.URL.Host) && !downgrade
+	if !sameHost && !s.PreserveHeadersCrossHost {
+		return
+	}
+	for _, name := range s.PreserveHeadersOnRedirect {
+		if v := orig.Header.Values(name); len(v) > 0 {
+			req.Header[http.CanonicalHeaderKey(name)] = append([]string(nil), v...)
+		}
+	}
+}
+
+// Redirects returns the redirect responses which were followed to get the
+// response, oldest first, or nil if there were none.  Their bodies have
+// been closed.
+func (r *Response) Redirects() []*http.Response {
+	if r.response == nil {
+		return nil
+	}
+	var hops []*http.Response
+	for req := r.response.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		hops = append([]*http.Response{req.Response}, hops...)
+	}
+	return hops
+}
+
+// SeeOther reports whether a 303 See Other redirect was followed, so that
+// the response is that of a GET of another resource, typically the status
+// of a POST, rather than of the request as sent.
+func (r *Response) SeeOther() bool {
+	for _, hop := range r.Redirects() {
+		if hop.StatusCode == http.StatusSeeOther {
+			return true
+		}
+	}
+	return false
+}
+
+// OriginalStatus returns the status of the response to the request as sent,
+// before following any redirect; it is Status if there was none.
+func (r *Response) OriginalStatus() int {
+	if hops := r.Redirects(); len(hops) > 0 {
+		return hops[0].StatusCode
+	}
+	return r.status
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeeOther(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/jobs":
+			w.Header().Set("Location", "/moved")
+			w.WriteHeader(http.StatusSeeOther)
+		case "/moved":
+			http.Redirect(w, req, "/jobs/7", http.StatusFound)
+		case "/jobs/7":
+			w.Write([]byte(`{"title": "` + req.Method + `"}`))
+		}
+	}))
+	defer srv.Close()
+
+	s := Session{}
+	var f feed
+	resp, err := s.Post(srv.URL+"/jobs", map[string]int{"n": 1})
+	assert.NoError(t, err)
+	assert.NoError(t, resp.Unmarshal(&f))
+	assert.Equal(t, "GET", f.Title)
+	assert.True(t, resp.SeeOther())
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, 303, resp.OriginalStatus())
+	hops := resp.Redirects()
+	if assert.Len(t, hops, 2) {
+		assert.Equal(t, "POST", hops[0].Request.Method)
+		assert.Equal(t, 303, hops[0].StatusCode)
+		assert.Equal(t, 302, hops[1].StatusCode)
+		assert.Equal(t, "/moved", hops[1].Request.URL.Path)
+	}
+
+	resp, err = s.Send(&Request{Url: srv.URL + "/jobs", Method: "POST", Payload: "x", NoFollowSeeOther: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 303, resp.Status())
+	assert.False(t, resp.SeeOther())
+	assert.Empty(t, resp.Redirects())
+	loc, err := resp.Location()
+	assert.NoError(t, err)
+	assert.Equal(t, srv.URL+"/moved", loc.String())
+
+	// Other redirects are still followed.
+	resp, err = s.Send(&Request{Url: srv.URL + "/moved", Method: "GET", NoFollowSeeOther: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, 302, resp.OriginalStatus())
+	assert.False(t, resp.SeeOther())
+}
+
+func TestPreserveHeadersOnRedirect(t *testing.T) {
+	var got []string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = append(got, req.Header.Get("Authorization")+"|"+req.Header.Get("X-Api-Key"))
+	}))
+	defer target.Close()
+	other := strings.Replace(target.URL, "127.0.0.1", "localhost", 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/same":
+			http.Redirect(w, req, "/done", http.StatusFound)
+		case "/cross":
+			http.Redirect(w, req, other, http.StatusFound)
+		default:
+			got = append(got, req.Header.Get("Authorization")+"|"+req.Header.Get("X-Api-Key"))
+		}
+	}))
+	defer srv.Close()
+
+	h := http.Header{}
+	h.Set("Authorization", "Token abc")
+	h.Set("X-Api-Key", "k")
+	s := Session{Header: &h, PreserveHeadersOnRedirect: []string{"authorization"}}
+	for _, path := range []string{"/same", "/cross"} {
+		_, err := s.Get(srv.URL+path, nil)
+		assert.NoError(t, err)
+	}
+	s.PreserveHeadersCrossHost = true
+	_, err := s.Get(srv.URL+"/cross", nil)
+	assert.NoError(t, err)
+	// net/http drops Authorization on the way to another host, unless
+	// preserved across hosts.
+	assert.Equal(t, []string{"Token abc|k", "|k", "Token abc|k"}, got)
+}
@@ -6,11 +6,25 @@
 package napping
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"reflect"
+	"sort"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // A Params is a map containing URL parameters.
@@ -25,6 +39,88 @@ func (p Params) AsUrlValues() url.Values {
 	return result
 }
 
+// EncodeOptions controls how Params.EncodeWith escapes keys and values.
+type EncodeOptions struct {
+	SpaceAsPlus bool   // Encode spaces as "+" instead of "%20"
+	Unescaped   string // Characters to leave unescaped, e.g. ",:"
+}
+
+// EncodeWith encodes the parameters into a query string sorted by key.
+// Unreserved characters (RFC 3986) are never escaped; everything else is
+// percent-encoded unless allowed by opts.  This is useful for servers and
+// signing schemes which are picky about escaping.
+func (p Params) EncodeWith(opts EncodeOptions) string {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte('&')
+		}
+		escapeWith(&buf, k, opts)
+		buf.WriteByte('=')
+		escapeWith(&buf, p[k], opts)
+	}
+	return buf.String()
+}
+
+func escapeWith(buf *strings.Builder, s string, opts EncodeOptions) {
+	const hex = "0123456789ABCDEF"
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			buf.WriteByte(c)
+		case c == ' ' && opts.SpaceAsPlus:
+			buf.WriteByte('+')
+		case c < utf8.RuneSelf && strings.IndexByte(opts.Unescaped, c) >= 0:
+			buf.WriteByte(c)
+		default:
+			buf.WriteByte('%')
+			buf.WriteByte(hex[c>>4])
+			buf.WriteByte(hex[c&15])
+		}
+	}
+}
+
+// A DecodeTarget selects where Send unmarshals a response body.
+type DecodeTarget int
+
+const (
+	DecodeNone   DecodeTarget = iota // Do not decode the body
+	DecodeResult                     // Decode into Request.Result
+	DecodeError                      // Decode into Request.Error
+	DecodeBoth                       // Decode into both Result and Error
+)
+
+// DefaultDecodeInto decodes 2xx responses into Result and 4xx and 5xx
+// responses into Error.  Informational and redirect responses are not
+// decoded.
+func DefaultDecodeInto(status int) DecodeTarget {
+	switch {
+	case status >= 200 && status < 300:
+		return DecodeResult
+	case status >= 400:
+		return DecodeError
+	}
+	return DecodeNone
+}
+
+func (t DecodeTarget) result() bool { return t == DecodeResult || t == DecodeBoth }
+func (t DecodeTarget) error() bool  { return t == DecodeError || t == DecodeBoth }
+
+// decodeTarget returns where the response body should be decoded.
+func (r *Request) decodeTarget() DecodeTarget {
+	if r.DecodeInto != nil {
+		return r.DecodeInto(r.status)
+	}
+	return DefaultDecodeInto(r.status)
+}
+
 // A Request describes an HTTP request to be executed, data structures into
 // which the result will be unmarshaled, and the server's response. By using
 // a  single object for both the request and the response we allow easy access
@@ -35,36 +131,362 @@ type Request struct {
 	Params  *url.Values // URL query parameters
 	Payload interface{} // Data to JSON-encode and POST
 
+	// Path of a file streamed as the body instead of Payload, with a
+	// Content-Type inferred from its extension and a Content-Length from
+	// its size.  The file is closed once the request is sent.
+	PayloadFile string
+
+	// Optional struct encoded into query parameters with EncodeQuery.
+	// Params take precedence over keys of the same name.
+	ParamsStruct interface{}
+
+	// Send the query of Url exactly as written, e.g. for a signed URL.
+	// Params, ParamsStruct and the session's Params are not merged into it.
+	PreserveQuery bool
+
+	// Decode a JSON body which is a single string holding a JSON object or
+	// array, as sent by some misbehaving servers, by decoding the document
+	// in the string into Result or Error.  Other bodies, including strings
+	// of other content, are decoded as usual.  See Response.Unwrapped.
+	// Bodies spilled to disk are not unwrapped.
+	UnwrapDoubleEncoded bool
+
+	// Do not guess the Content-Type of string and []byte payloads.  By
+	// default a []byte payload which is a valid JSON object or array, and
+	// a string which looks like one, is sent as application/json unless a
+	// Content-Type header is set.
+	NoAutoContentType bool
+
+	// Send a slice or array Payload as newline-delimited JSON, one element
+	// per line each followed by a newline, with Content-Type
+	// application/x-ndjson unless a Content-Type header is set, instead of
+	// as a JSON array.
+	NDJSON bool
+
+	// Overrides Session.APIMediaType for this request.
+	APIMediaType string
+
+	// Values shared between the hooks handling the request, such as
+	// correlation IDs or the tenant whose key signs the request, and
+	// carried into the Response.  Session.BeforeSend and AfterResponse see
+	// it as r.Meta; Authorizer, ResponseTransformers and other code given
+	// the *http.Request or *http.Response get it from the request context
+	// with MetaFromContext.  Send works on a copy, so that hooks storing
+	// into it do not affect other requests sharing the map; the copy
+	// replaces Meta.  Meta is never sent.  Use SetMeta to store into a nil
+	// map.
+	Meta map[string]interface{}
+
+	// Optional pointers into which the response body is unmarshaled,
+	// chosen by DecodeInto from the response status.  CSV and TSV bodies
+	// are decoded into pointers to slices of structs or *[][]string, as by
+	// Response.UnmarshalCSV.
+	Result interface{} // Value to decode a successful response into
+	Error  interface{} // Value to decode an error response into
+
+	// Optional schema which bodies routed to Result must satisfy.  Send
+	// returns a *SchemaValidationError along with the response if they do
+	// not; Result is populated regardless, unless SchemaBeforeDecode is
+	// set.  Bodies are not checked with StreamResult or NotProcessBody.
+	Schema             SchemaValidator
+	SchemaBeforeDecode bool
+
+	// Optional check of the response after it is decoded, e.g. of a
+	// signature header over the body or of a required field.  Send returns
+	// its error along with the response.  It runs for every status, or
+	// only for 2xx responses if ValidateSuccessOnly is set, and not when
+	// Schema has already failed.
+	ValidateResponse    func(*Response) error
+	ValidateSuccessOnly bool
+
+	// Capture response bodies larger than this many bytes in a temporary
+	// file instead of memory; zero means never.  BodyReader, Lines,
+	// Unmarshal and decoding into Result and Error read from the file,
+	// while RawByte and RawText return nothing.  Call Response.Close to
+	// remove the file.  Bodies are not spilled when the session has
+	// ResponseTransformers, and spilled bodies are not checked against
+	// Schema.
+	SpillToDiskOver int64
+
+	// Optional function routing the response body, by status code, to
+	// Result, Error, both or neither.  Defaults to DefaultDecodeInto.
+	DecodeInto func(status int) DecodeTarget
+
+	// Decode Result directly from the response stream instead of buffering
+	// the whole body first, which saves memory for large responses.  The
+	// body is then not captured: RawByte, RawText, BodyReader and
+	// Unmarshal see an empty body.  Responses routed to Error are still
+	// buffered.
+	StreamResult bool
+
+	// Decode numbers into interface{} values as json.Number instead of
+	// float64, so that large integer IDs keep their precision.  This
+	// applies to Result, Error, Unmarshal and Path.  Session.UseNumber sets
+	// it for all requests.
+	UseNumber bool
+
+	// Maximum time to receive the response body, counted from the arrival
+	// of the response headers.  Reading the body fails with ErrBodyTimeout
+	// once it is exceeded.  Defaults to Session.BodyTimeout.  Connecting
+	// and waiting for the headers are limited by Session.ConnectTimeout
+	// and ResponseHeaderTimeout; use a context deadline for an overall
+	// timeout.
+	BodyTimeout time.Duration
+
+	// Maximum time without receiving any bytes of the response body, e.g.
+	// from a peer which died mid-download.  The timer restarts whenever
+	// bytes arrive, so that long transfers are not limited as long as they
+	// progress.  Reading the body then fails with a *BodyStalledError.  It
+	// applies to buffered, streamed and NotProcessBody responses alike; the
+	// latter must be read continuously.
+	BodyIdleTimeout time.Duration
+
+	// Declared length of an io.Reader Payload.  It is sent as the
+	// Content-Length header so that chunked encoding is avoided, and Send
+	// fails with a ContentLengthError if the reader yields a different
+	// number of bytes.
+	ContentLength int64
+
+	// Always send the payload with chunked transfer encoding.
+	ForceChunked bool
+
+	// Files to upload as multipart/form-data, along with the fields in
+	// Form.  Mutually exclusive with Payload.
+	Files             []FormFile
+	Form              *url.Values
+	MultipartBoundary string // Optional; a random boundary is used if empty
+
 	// Not capture response body and unmarshaled
 	NotProcessBody bool
 
+	// Longest line accepted by Response.Lines; default 64 KiB.
+	MaxLineBytes int
+
 	// Optional
 	Userinfo *url.Userinfo
 	Header   *http.Header
 
+	// Send only the params or headers given by the request, without the
+	// session defaults.  Implicit headers such as Accept and User-Agent
+	// are still added.
+	NoSessionParams  bool
+	NoSessionHeaders bool
+
+	// Headers whose keys are sent with their exact spelling, e.g.
+	// "X-AUTH-TOKEN".  The map is used directly, bypassing the key
+	// canonicalization applied by http.Header.Set.
+	RawHeaders map[string][]string
+
+	// Cookies to send with this request only, without a cookie jar.  They
+	// are appended to any Cookie header set in Header.
+	Cookies []*http.Cookie
+
 	// Custom Transport if needed.
 	Transport *http.Transport
 
+	// Return redirect responses as they are instead of following their
+	// Location.
+	NoRedirect bool
+
+	// Return a 303 See Other response as it is, with its Location, instead
+	// of following it with a GET.  Other redirects are still followed.  See
+	// also Response.SeeOther and Redirects.
+	NoFollowSeeOther bool
+
+	// Send the request even if Session.DoubleSubmitGuard would take it for
+	// a duplicate, for legitimate rapid identical calls.  The guard does
+	// not remember it either.
+	AllowDuplicate bool
+
+	// Allow Session.Race to send a non-idempotent method, such as POST, to
+	// several URLs.
+	AllowUnsafeRace bool
+
+	// Send the request on a new connection, closed after the response, for
+	// servers which keep state per connection.  This is guaranteed when
+	// the session's transport is an *http.Transport, as it is unless
+	// Client or Transport is set to something else.
+	DisableConnReuse bool
+
+	// Fail with ErrConnNotReused instead of opening a new connection.
+	// Mostly useful in tests, together with Session.Warmup.
+	RequireReusedConn bool
+
 	// The following fields are populated by Send().
-	timestamp time.Time      // Time when HTTP request was sent
-	status    int            // HTTP status for executed request
-	response  *http.Response // Response object from http package
-	body      []byte         // Body of server's response (JSON or otherwise)
+	timestamp     time.Time       // Time when HTTP request was sent
+	status        int             // HTTP status for executed request
+	response      *http.Response  // Response object from http package
+	body          []byte          // Body of server's response (JSON or otherwise)
+	timings       Timings         // Breakdown of where request time was spent
+	counter       *countingReader // Counts payload bytes when ContentLength is declared
+	endpoint      string          // Session endpoint which served the request
+	auth          authPlan        // Where the credentials came from
+	useNumber     bool            // Session.UseNumber was set
+	remoteAddr    net.Addr        // Address of the server
+	proxy         *url.URL        // Proxy the request was routed through
+	decodeErr     error           // Failure to decode the body into Result or Error
+	attemptErrs   []error         // Failures of earlier attempts
+	bare          bool            // Send no implicit headers or credentials
+	payloadFile   *os.File        // Opened from PayloadFile
+	effectiveType string          // Content-Type by which the body is decoded
+	sniffed       bool            // effectiveType was sniffed from the body
+	spill         *spill          // Body captured on disk
+	policyRemoved []string        // Headers removed by Session.HeaderPolicy
+	unwrapped     bool            // A double-encoded body was unwrapped
+	clk           Clock           // Clock of the session which sent the request
+	problem       *ProblemDetails // RFC 7807 problem of an error response
+}
+
+// SetHeaders sets headers of the request, replacing any values of the same
+// names.  Header is created if it is nil.
+func (r *Request) SetHeaders(headers map[string]string) {
+	if r.Header == nil {
+		r.Header = &http.Header{}
+	}
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+}
+
+// AddHeader adds a value to a header of the request, keeping any existing
+// values.  Header is created if it is nil.
+func (r *Request) AddHeader(key, value string) {
+	if r.Header == nil {
+		r.Header = &http.Header{}
+	}
+	r.Header.Add(key, value)
 }
 
 // A Response is a Request object that has been executed.
 type Response Request
 
+// NewTestResponse returns a Response with the given status, header and
+// body, as if received from a server, for testing code which consumes
+// Responses.  Status, RawText, Unmarshal, IsJsonMime and the header
+// accessors work as usual; the request is a GET.  See also
+// nappingtest.FromRecorder.
+func NewTestResponse(status int, header http.Header, body []byte) *Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &Response{
+		Method:    "GET",
+		status:    status,
+		body:      body,
+		timestamp: time.Now(),
+		response: &http.Response{
+			Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			StatusCode:    status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+		},
+	}
+}
+
 // Timestamp returns the time when HTTP request was sent.
 func (r *Response) Timestamp() time.Time {
 	return r.timestamp
 }
 
+// Timings returns a breakdown of where the time of the request was spent,
+// including whether a pooled connection was reused.
+func (r *Response) Timings() Timings {
+	return r.timings
+}
+
+// ConnectionClosed reports whether the connection the response was received
+// on was closed afterwards rather than kept for reuse, e.g. because the
+// server sent Connection: close.
+func (r *Response) ConnectionClosed() bool {
+	return r.response != nil && r.response.Close
+}
+
+// metaKey is the context key of Request.Meta.
+type metaKey struct{}
+
+// MetaFromContext returns the Meta of the request being sent with ctx, e.g.
+// req.Context() in a Session.Authorizer, or nil.  Values stored into it are
+// seen by later hooks and the Response.
+func MetaFromContext(ctx context.Context) map[string]interface{} {
+	m, _ := ctx.Value(metaKey{}).(map[string]interface{})
+	return m
+}
+
+// copyMeta returns a copy of m, never nil.
+func copyMeta(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// SetMeta stores value under key in r.Meta, creating the map if needed.
+func (r *Request) SetMeta(key string, value interface{}) {
+	if r.Meta == nil {
+		r.Meta = make(map[string]interface{})
+	}
+	r.Meta[key] = value
+}
+
+// DecodeError returns the error, if any, encountered while decoding the body
+// into Result or Error.  Send returns a failure to decode into Result, along
+// with the Response so that the body can be inspected, but only logs a
+// failure to decode into Error; DecodeError reports both.
+func (r *Response) DecodeError() error {
+	return r.decodeErr
+}
+
 // Timestamp returns the time when HTTP request was sent.
 func (r *Response) RawByte() []byte {
 	return r.body
 }
 
+// BodyReader returns a fresh reader over the captured body of the server's
+// response.  It may be called any number of times, including after the body
+// has been unmarshaled.  For a body spilled to disk it is an *os.File, which
+// the caller may close early.
+func (r *Response) BodyReader() io.Reader {
+	return (*Request)(r).bodyReader()
+}
+
+// Lines calls fn with each line of the response body, without the line
+// ending, until EOF or the first error from fn.  With NotProcessBody the
+// live body is scanned as it arrives, e.g. from a log-tailing endpoint, and
+// closed afterwards; reading fails once the request context is canceled.
+// Otherwise the captured body is scanned.  Lines longer than
+// Request.MaxLineBytes fail with bufio.ErrTooLong.
+func (r *Response) Lines(fn func(line string) error) error {
+	src := (*Request)(r).bodyReader()
+	if c, ok := src.(io.Closer); ok {
+		defer c.Close()
+	}
+	if r.NotProcessBody && r.response != nil {
+		defer r.response.Body.Close()
+		src = r.response.Body
+	}
+	sc := bufio.NewScanner(src)
+	max := r.MaxLineBytes
+	if max <= 0 {
+		max = bufio.MaxScanTokenSize
+	}
+	size := 4096
+	if max < size {
+		size = max // The buffer capacity also bounds the line length
+	}
+	sc.Buffer(make([]byte, 0, size), max)
+	for sc.Scan() {
+		if err := fn(sc.Text()); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
 // RawText returns the body of the server's response as raw text.
 func (r *Response) RawText() string {
 	return strings.TrimSpace(string(r.body))
@@ -76,8 +498,44 @@ func (r *Response) Status() int {
 	return r.status
 }
 
+// StatusOk reports whether the HTTP status is a 2xx success code.
 func (r *Response) StatusOk() bool {
-	return r.status <= 300
+	return r.status >= 200 && r.status < 300
+}
+
+// Is reports whether the HTTP status is code.
+func (r *Response) Is(code int) bool {
+	return r.status == code
+}
+
+// Expect returns a *StatusError if the HTTP status is not one of codes.
+func (r *Response) Expect(codes ...int) error {
+	for _, c := range codes {
+		if r.status == c {
+			return nil
+		}
+	}
+	return &StatusError{Status: r.status, Expected: codes, Body: r.body}
+}
+
+// maxErrorBody is the number of body bytes included in StatusError messages.
+const maxErrorBody = 512
+
+// A StatusError reports that a response had an unexpected HTTP status.
+type StatusError struct {
+	Status   int
+	Expected []int
+	Body     []byte
+}
+
+func (e *StatusError) Error() string {
+	body := e.Body
+	more := ""
+	if len(body) > maxErrorBody {
+		body = body[:maxErrorBody]
+		more = fmt.Sprintf("... (%d bytes truncated)", len(e.Body)-maxErrorBody)
+	}
+	return fmt.Sprintf("napping: unexpected status %d (expected %v): %s%s", e.Status, e.Expected, body, more)
 }
 
 func (r *Response) IsJsonMime() bool {
@@ -89,6 +547,15 @@ func (r *Response) IsJsonMime() bool {
 	return isJson
 }
 
+// SetCookies parses and returns the cookies set in the Set-Cookie headers
+// of the server's response.
+func (r *Response) SetCookies() []*http.Cookie {
+	if r.response == nil {
+		return nil
+	}
+	return r.response.Cookies()
+}
+
 // HttpResponse returns the underlying Response object from http package.
 func (r *Response) HttpResponse() *http.Response {
 	return r.response
@@ -97,5 +564,343 @@ func (r *Response) HttpResponse() *http.Response {
 // Unmarshal parses the JSON-encoded data in the server's response, and stores
 // the result in the value pointed to by v.
 func (r *Response) Unmarshal(v interface{}) error {
-	return json.Unmarshal(r.body, v)
+	if r.spill != nil {
+		return (*Request)(r).decodeSpilled(v, "application/json")
+	}
+	return unmarshalJSON(r.body, v, r.UseNumber || r.useNumber)
+}
+
+// MustUnmarshal is like Unmarshal but panics if the body cannot be decoded
+// into v.  It is meant for scripts and tests, where brevity matters more
+// than handling the error.
+func (r *Response) MustUnmarshal(v interface{}) {
+	if err := r.Unmarshal(v); err != nil {
+		panic(err)
+	}
+}
+
+// MustText returns the body of the server's response as text, trimmed like
+// RawText, reading it from disk if it was spilled.  It panics if the body
+// cannot be read.  It is meant for scripts and tests.
+func (r *Response) MustText() string {
+	if r.spill == nil {
+		return r.RawText()
+	}
+	src := (*Request)(r).bodyReader()
+	if c, ok := src.(io.Closer); ok {
+		defer c.Close()
+	}
+	b, err := ioutil.ReadAll(src)
+	if err != nil {
+		panic(err)
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// DecodeStream calls fn with each of the JSON documents of the response
+// body, for servers which send several top-level values back to back
+// without an array around them.  It stops at the first error from fn, or
+// at a malformed document, which is reported with its index and offset.
+func (r *Response) DecodeStream(fn func(doc json.RawMessage) error) error {
+	src := (*Request)(r).bodyReader()
+	if c, ok := src.(io.Closer); ok {
+		defer c.Close()
+	}
+	dec := json.NewDecoder(src)
+	for i := 0; ; i++ {
+		offset := dec.InputOffset()
+		var doc json.RawMessage
+		if err := dec.Decode(&doc); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("napping: JSON document %d at offset %d: %w", i, offset, err)
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+}
+
+// UnmarshalAll decodes each of the JSON documents of the response body, as
+// for DecodeStream, and appends them to the slice pointed to by v.
+func (r *Response) UnmarshalAll(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("napping: UnmarshalAll needs a pointer to a slice, not %T", v)
+	}
+	slice := rv.Elem()
+	i := 0
+	return r.DecodeStream(func(doc json.RawMessage) error {
+		elem := reflect.New(slice.Type().Elem())
+		if err := unmarshalJSON(doc, elem.Interface(), r.UseNumber || r.useNumber); err != nil {
+			return fmt.Errorf("napping: JSON document %d: %w", i, err)
+		}
+		slice.Set(reflect.Append(slice, elem.Elem()))
+		i++
+		return nil
+	})
+}
+
+// unmarshalJSON is json.Unmarshal, optionally decoding numbers into
+// interface{} values as json.Number instead of float64.
+func unmarshalJSON(data []byte, v interface{}, useNumber bool) error {
+	if !useNumber {
+		return json.Unmarshal(data, v)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("napping: invalid data after top-level JSON value")
+	}
+	return nil
+}
+
+// unwrapJSONString returns the JSON object or array encoded in the JSON
+// string data, if data is exactly one such string.
+func unwrapJSONString(data []byte) ([]byte, bool) {
+	data = bytes.TrimSpace(data)
+	if len(data) < 2 || data[0] != '"' {
+		return nil, false
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, false
+	}
+	inner := bytes.TrimSpace([]byte(s))
+	if len(inner) == 0 || inner[0] != '{' && inner[0] != '[' || !json.Valid(inner) {
+		return nil, false
+	}
+	return inner, true
+}
+
+// Unwrapped reports whether the body was a double-encoded JSON document
+// which was unwrapped before decoding; see Request.UnwrapDoubleEncoded.
+func (r *Response) Unwrapped() bool {
+	return r.unwrapped
 }
+
+// NumberToInt64 converts a JSON number to an int64.  Unlike
+// json.Number.Int64, it accepts integral values written with a fraction or
+// exponent, such as "1e3" or "42.0".
+func NumberToInt64(n json.Number) (int64, error) {
+	if i, err := n.Int64(); err == nil {
+		return i, nil
+	}
+	f, _, err := big.ParseFloat(string(n), 10, 256, big.ToNearestEven)
+	if err != nil {
+		return 0, fmt.Errorf("napping: invalid number %q", n)
+	}
+	if !f.IsInt() {
+		return 0, fmt.Errorf("napping: number %s is not an integer", n)
+	}
+	i, acc := f.Int64()
+	if acc != big.Exact {
+		return 0, fmt.Errorf("napping: number %s overflows int64", n)
+	}
+	return i, nil
+}
+
+// Path decodes the JSON body of the server's response and returns the value
+// found at path, e.g. "user.id" or "items.0.name".  Values have the types
+// produced by encoding/json when decoding into an interface{}; numbers are
+// json.Number rather than float64 if UseNumber is set.
+func (r *Response) Path(path string) (interface{}, error) {
+	var v interface{}
+	if err := r.Unmarshal(&v); err != nil {
+		return nil, err
+	}
+	return lookupPath(v, path)
+}
+
+// isToken reports whether s is a token as defined by RFC 7230, section
+// 3.2.6, the syntax of method names.  Extension methods, such as WebDAV's
+// PROPFIND, are tokens too.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks the request for configuration errors without sending it.
+// Send calls Validate before doing any network I/O.  An empty Method is
+// allowed and means GET.
+func (r *Request) Validate() error {
+	if r.Url == "" {
+		return errors.New("napping: invalid request: empty URL")
+	}
+	if _, err := url.Parse(r.Url); err != nil {
+		return fmt.Errorf("napping: invalid request: %w", err)
+	}
+	if r.Method != "" && !isToken(r.Method) {
+		return fmt.Errorf("napping: invalid request: invalid method %q", r.Method)
+	}
+	if r.Payload != nil {
+		switch r.Payload.(type) {
+		case []byte, string, io.Reader:
+		default:
+			if err := checkPayload(r.Payload); err != nil {
+				return err
+			}
+			if r.NDJSON {
+				if err := checkNDJSON(r.Payload); err != nil {
+					return err
+				}
+			}
+		}
+		if len(r.Files) > 0 {
+			return errors.New("napping: Payload and Files are mutually exclusive")
+		}
+	}
+	if r.PayloadFile != "" && (r.Payload != nil || len(r.Files) > 0) {
+		return errors.New("napping: PayloadFile excludes Payload and Files")
+	}
+	if r.ParamsStruct != nil {
+		if _, err := EncodeQuery(r.ParamsStruct); err != nil {
+			return err
+		}
+	}
+	if r.PreserveQuery && (r.Params != nil || r.ParamsStruct != nil) {
+		return errors.New("napping: PreserveQuery excludes Params and ParamsStruct")
+	}
+	if r.DisableConnReuse && r.RequireReusedConn {
+		return errors.New("napping: DisableConnReuse and RequireReusedConn are mutually exclusive")
+	}
+	if r.ContentLength > 0 && r.ForceChunked {
+		return errors.New("napping: ContentLength and ForceChunked are mutually exclusive")
+	}
+	if err := checkTarget("Result", r.Result); err != nil {
+		return err
+	}
+	if err := checkTarget("Error", r.Error); err != nil {
+		return err
+	}
+	return nil
+}
+
+// encodePayload returns a reader for the request body and its content type,
+// if known.  Strings and byte slices are sent as is.  Other values are
+// encoded by the encoder registered for the requested Content-Type, if any,
+// else as protobuf for protobuf messages and as JSON otherwise.  An
+// io.Reader is returned unchanged.
+func (r *Request) encodePayload(requested string) (io.Reader, string, error) {
+	if b, ok := r.Payload.([]byte); ok {
+		// Raw bytes are sent verbatim, without reflection.
+		if len(b) == 0 {
+			return nil, "", nil
+		}
+		contentType := ""
+		if requested == "" && !r.NoAutoContentType && isJSONDocument(b) {
+			contentType = "application/json"
+		}
+		return bytes.NewReader(b), contentType, nil
+	}
+	if rd, ok := r.Payload.(io.Reader); ok {
+		return rd, "", nil
+	}
+	var bydata []byte
+	var err error
+	switch v := r.Payload.(type) {
+	case string:
+		if r.NoAutoContentType {
+			if v == "" {
+				return nil, "", nil
+			}
+			return strings.NewReader(v), "", nil
+		}
+		bydata = []byte(v)
+	default:
+		if r.NDJSON {
+			buf, err := encodeNDJSON(v)
+			if err != nil || buf.Len() == 0 {
+				return nil, "", err
+			}
+			if requested != "" {
+				return buf, requested, nil
+			}
+			return buf, NDJSONContentType, nil
+		}
+		if enc := lookupEncoder(requested); enc != nil {
+			if bydata, err = enc(v); err != nil {
+				return nil, "", err
+			}
+			return bytes.NewBuffer(bydata), requested, nil
+		}
+		if isProto(v) {
+			if bydata, err = marshalProto(v); err != nil {
+				return nil, "", err
+			}
+			return bytes.NewBuffer(bydata), ProtoContentType, nil
+		}
+		bydata, err = json.Marshal(v)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if len(bydata) == 0 {
+		return nil, "", nil
+	}
+	contentType := ""
+	if (bydata[0] == '{' && bydata[len(bydata)-1] == '}') ||
+		(bydata[0] == '[' && bydata[len(bydata)-1] == ']') {
+		contentType = "application/json"
+	}
+	return bytes.NewBuffer(bydata), contentType, nil
+}
+
+// isJSONDocument reports whether b is a valid JSON object or array.
+func isJSONDocument(b []byte) bool {
+	b = bytes.TrimSpace(b)
+	return len(b) > 0 && (b[0] == '{' || b[0] == '[') && json.Valid(b)
+}
+
+// checkTarget reports an error if v is set but cannot be unmarshaled into.
+func checkTarget(name string, v interface{}) error {
+	if v == nil {
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr {
+		return fmt.Errorf("napping: invalid request: %s must be a pointer, not %T", name, v)
+	}
+	if rv.IsNil() {
+		return fmt.Errorf("napping: invalid request: %s is a nil %T", name, v)
+	}
+	return nil
+}
+
+// checkPayload reports an error if payload is of a kind that cannot be
+// encoded as a request body.  Without this check such payloads would fail
+// deep inside encoding/json.
+func checkPayload(payload interface{}) error {
+	t := reflect.TypeOf(payload)
+	if t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) {
+		return nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Complex64, reflect.Complex128,
+		reflect.UnsafePointer, reflect.Invalid:
+		return fmt.Errorf("napping: unsupported payload kind %s", t.Kind())
+	}
+	return nil
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
@@ -0,0 +1,572 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func handleJSON(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}
+}
+
+func TestBodyReader(t *testing.T) {
+	body := `{"Foo": "bar"}`
+	srv := httptest.NewServer(handleJSON(body))
+	defer srv.Close()
+	resp, err := Get(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p payload
+	if err := resp.Unmarshal(&p); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "bar", p.Foo)
+	for i := 0; i < 2; i++ {
+		b, err := ioutil.ReadAll(resp.BodyReader())
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, body, string(b))
+	}
+}
+
+func TestPath(t *testing.T) {
+	srv := httptest.NewServer(handleJSON(`{"user": {"id": 42, "tags": ["a", "b"]}}`))
+	defer srv.Close()
+	resp, err := Get(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := resp.Path("user.tags.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "b", v)
+	_, err = resp.Path("user.name")
+	assert.Error(t, err)
+	_, err = resp.Path("user.tags.9")
+	assert.Error(t, err)
+}
+
+func TestCookies(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c, err := req.Cookie("affinity")
+		if assert.NoError(t, err) {
+			assert.Equal(t, "node 1, rack 2", c.Value)
+		}
+		c, err = req.Cookie("manual")
+		if assert.NoError(t, err) {
+			assert.Equal(t, "yes", c.Value)
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "a b,c", Path: "/"})
+		http.SetCookie(w, &http.Cookie{Name: "other", Value: "x", Expires: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)})
+	}))
+	defer srv.Close()
+	h := http.Header{}
+	h.Set("Cookie", "manual=yes")
+	r := Request{
+		Url:     srv.URL,
+		Method:  "GET",
+		Header:  &h,
+		Cookies: []*http.Cookie{{Name: "affinity", Value: "node 1, rack 2"}},
+	}
+	resp, err := Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := resp.SetCookies()
+	if assert.Len(t, cookies, 2) {
+		assert.Equal(t, "session", cookies[0].Name)
+		assert.Equal(t, "a b,c", cookies[0].Value)
+		assert.Equal(t, "other", cookies[1].Name)
+		assert.Equal(t, 2030, cookies[1].Expires.Year())
+	}
+}
+
+func TestParamsEncodeWith(t *testing.T) {
+	p := Params{"q": "kirk & spock", "fields": "id,name", "x": "a+b/c"}
+	assert.Equal(t, "fields=id%2Cname&q=kirk%20%26%20spock&x=a%2Bb%2Fc", p.EncodeWith(EncodeOptions{}))
+	assert.Equal(t, "fields=id%2Cname&q=kirk+%26+spock&x=a%2Bb%2Fc", p.EncodeWith(EncodeOptions{SpaceAsPlus: true}))
+	assert.Equal(t, "fields=id,name&q=kirk%20%26%20spock&x=a%2Bb/c", p.EncodeWith(EncodeOptions{Unescaped: ",/"}))
+	assert.Equal(t, "k=%C3%A9", Params{"k": "é"}.EncodeWith(EncodeOptions{Unescaped: "é"}))
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		r   Request
+		msg string
+	}{
+		{Request{Method: "GET"}, "empty URL"},
+		{Request{Url: "http://[::1", Method: "GET"}, "missing ']' in host"},
+		{Request{Url: "http://foo", Method: "GE T"}, `invalid method "GE T"`},
+		{Request{Url: "http://foo", Method: "GET\r\n"}, "invalid method"},
+		{Request{Url: "http://foo", Method: "POST", Payload: make(chan int)}, "unsupported payload kind chan"},
+		{Request{Url: "http://foo", Method: "POST", Payload: "x", Files: []FormFile{{}}}, "mutually exclusive"},
+		{Request{Url: "http://foo", Method: "PUT", ContentLength: 1, ForceChunked: true}, "mutually exclusive"},
+	}
+	for _, c := range cases {
+		err := c.r.Validate()
+		if assert.Error(t, err, "%+v", c.r) {
+			assert.Contains(t, err.Error(), c.msg)
+		}
+		_, err = Send(&c.r)
+		assert.Error(t, err)
+	}
+	ok := Request{Url: "/relative", Method: "patch", Payload: payload{"x"}}
+	assert.NoError(t, ok.Validate())
+
+	// Extension methods are sent as they are.
+	var method string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		method = req.Method
+		w.WriteHeader(207)
+	}))
+	defer srv.Close()
+	resp, err := Send(&Request{Url: srv.URL, Method: "PROPFIND"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "PROPFIND", method)
+		assert.Equal(t, 207, resp.Status())
+	}
+	assert.NoError(t, (&Request{Url: "http://foo"}).Validate())
+	empty := Request{Url: "http://foo", Method: "POST", Payload: struct{}{}}
+	assert.NoError(t, empty.Validate())
+}
+
+func handleStatusJSON(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+type apiError struct {
+	Message string
+}
+
+func TestDecodeInto(t *testing.T) {
+	cases := []struct {
+		status     int
+		decodeInto func(int) DecodeTarget
+		result     string
+		message    string
+	}{
+		{200, nil, "ok", ""},
+		{201, nil, "ok", ""},
+		{302, nil, "", ""},
+		{404, nil, "", "ok"},
+		{500, nil, "", "ok"},
+		{202, func(status int) DecodeTarget {
+			if status == 202 {
+				return DecodeError
+			}
+			return DefaultDecodeInto(status)
+		}, "", "ok"},
+		{207, func(int) DecodeTarget { return DecodeBoth }, "ok", "ok"},
+		{200, func(int) DecodeTarget { return DecodeNone }, "", ""},
+	}
+	for _, c := range cases {
+		srv := httptest.NewServer(handleStatusJSON(c.status, `{"Foo": "ok", "Message": "ok"}`))
+		var res payload
+		var e apiError
+		r := Request{
+			Url:        srv.URL,
+			Method:     "GET",
+			Result:     &res,
+			Error:      &e,
+			DecodeInto: c.decodeInto,
+		}
+		_, err := Send(&r)
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, c.result, res.Foo, "status %d", c.status)
+		assert.Equal(t, c.message, e.Message, "status %d", c.status)
+	}
+}
+
+func TestDecodeResultError(t *testing.T) {
+	srv := httptest.NewServer(handleStatusJSON(200, `not json`))
+	defer srv.Close()
+	var res payload
+	_, err := Send(&Request{Url: srv.URL, Method: "GET", Result: &res})
+	assert.Error(t, err)
+
+	// Undecodable error bodies are not fatal.
+	srv2 := httptest.NewServer(handleStatusJSON(502, `<html>Bad Gateway</html>`))
+	defer srv2.Close()
+	var e apiError
+	resp, err := Send(&Request{Url: srv2.URL, Method: "GET", Error: &e})
+	assert.NoError(t, err)
+	assert.Equal(t, 502, resp.Status())
+}
+
+func TestDecodeTargetValidation(t *testing.T) {
+	var res payload
+	var nilPtr *payload
+	assert.Error(t, (&Request{Url: "http://foo", Result: res}).Validate())
+	assert.Error(t, (&Request{Url: "http://foo", Error: nilPtr}).Validate())
+	assert.NoError(t, (&Request{Url: "http://foo", Result: &res}).Validate())
+}
+
+func TestStatusOk(t *testing.T) {
+	for status, ok := range map[int]bool{0: false, 200: true, 204: true, 299: true, 300: false, 404: false} {
+		r := Response{status: status}
+		assert.Equal(t, ok, r.StatusOk(), "status %d", status)
+	}
+}
+
+func TestStreamResult(t *testing.T) {
+	const n = 50000
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		items := make([]payload, n)
+		for i := range items {
+			items[i].Foo = strconv.Itoa(i)
+		}
+		enc.Encode(map[string]interface{}{"items": items})
+	}))
+	defer srv.Close()
+	var res struct {
+		Items []payload
+	}
+	r := Request{Url: srv.URL, Method: "GET", Result: &res, StreamResult: true}
+	resp, err := Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, res.Items, n)
+	assert.Equal(t, strconv.Itoa(n-1), res.Items[n-1].Foo)
+	assert.Empty(t, resp.RawByte())
+
+	// Error responses are buffered as usual.
+	srv2 := httptest.NewServer(handleStatusJSON(400, `{"Message": "nope"}`))
+	defer srv2.Close()
+	var e apiError
+	r = Request{Url: srv2.URL, Method: "GET", Result: &res, Error: &e, StreamResult: true}
+	resp, err = Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "nope", e.Message)
+	assert.NotEmpty(t, resp.RawByte())
+
+	// An empty body, as of a 204, is not an error.
+	srv3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv3.Close()
+	res.Items = nil
+	for _, method := range []string{"GET", "HEAD"} {
+		r = Request{Url: srv3.URL, Method: method, Result: &res, StreamResult: true}
+		resp, err = Send(&r)
+		if err != nil {
+			t.Fatal(method, err)
+		}
+		assert.Equal(t, 204, resp.Status())
+		assert.Nil(t, res.Items)
+		assert.NoError(t, resp.DecodeError())
+	}
+}
+
+func TestExpect(t *testing.T) {
+	r := Response{status: 201, body: []byte(`{"id":1}`)}
+	assert.True(t, r.Is(201))
+	assert.False(t, r.Is(200))
+	assert.NoError(t, r.Expect(200, 201))
+
+	r = Response{status: 409, body: []byte(`{"error":"duplicate"}`)}
+	err := r.Expect(200, 201)
+	assert.EqualError(t, err, `napping: unexpected status 409 (expected [200 201]): {"error":"duplicate"}`)
+	var se *StatusError
+	if assert.True(t, errors.As(err, &se)) {
+		assert.Equal(t, 409, se.Status)
+	}
+
+	r.body = bytes.Repeat([]byte("x"), 600)
+	assert.True(t, strings.HasSuffix(r.Expect(200).Error(), "x... (88 bytes truncated)"))
+}
+
+func TestUseNumber(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": 9007199254740993, "items": [{"id": 9007199254740995}]}`))
+	}))
+	defer srv.Close()
+
+	var lossy map[string]interface{}
+	if _, err := Send(&Request{Url: srv.URL, Result: &lossy}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, float64(9007199254740992), lossy["id"])
+
+	var res map[string]interface{}
+	resp, err := Send(&Request{Url: srv.URL, Result: &res, UseNumber: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := NumberToInt64(res["id"].(json.Number))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(9007199254740993), id)
+	v, err := resp.Path("items.0.id")
+	assert.NoError(t, err)
+	assert.Equal(t, json.Number("9007199254740995"), v)
+
+	s := Session{UseNumber: true}
+	res = nil
+	if _, err := s.Send(&Request{Url: srv.URL, Result: &res, StreamResult: true}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, json.Number("9007199254740993"), res["id"])
+
+	assert.Error(t, unmarshalJSON([]byte(`{} {}`), &res, true))
+}
+
+func TestNumberToInt64(t *testing.T) {
+	for n, want := range map[json.Number]int64{"42": 42, "-7": -7, "1e3": 1000, "42.0": 42, "9223372036854775807": 9223372036854775807} {
+		got, err := NumberToInt64(n)
+		assert.NoError(t, err, string(n))
+		assert.Equal(t, want, got, string(n))
+	}
+	for _, n := range []json.Number{"1.5", "9223372036854775808", "abc"} {
+		_, err := NumberToInt64(n)
+		assert.Error(t, err, string(n))
+	}
+}
+
+func TestUnmarshalAll(t *testing.T) {
+	r := Response{body: []byte("{\"Foo\":\"a\"}{\"Foo\":\"b\"}\n  {\"Foo\":\"c\"}\n")}
+	var all []payload
+	assert.NoError(t, r.UnmarshalAll(&all))
+	assert.Equal(t, []payload{{"a"}, {"b"}, {"c"}}, all)
+
+	var raw []string
+	assert.NoError(t, r.DecodeStream(func(doc json.RawMessage) error {
+		raw = append(raw, string(doc))
+		return nil
+	}))
+	assert.Equal(t, []string{`{"Foo":"a"}`, `{"Foo":"b"}`, `{"Foo":"c"}`}, raw)
+
+	r.body = []byte(`{"Foo":"a"} {"Foo": oops}`)
+	all = nil
+	err := r.UnmarshalAll(&all)
+	assert.EqualError(t, err, "napping: JSON document 1 at offset 11: invalid character 'o' looking for beginning of value")
+	assert.Equal(t, []payload{{"a"}}, all)
+
+	r.body = []byte(`{"Foo":"a"} {"Foo":1}`)
+	assert.Error(t, r.UnmarshalAll(&all))
+	assert.Error(t, r.UnmarshalAll(all))
+
+	stop := errors.New("stop")
+	n := 0
+	err = r.DecodeStream(func(json.RawMessage) error { n++; return stop })
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestMustUnmarshal(t *testing.T) {
+	r := NewTestResponse(200, nil, []byte(" {\"Foo\":\"a\"}\n"))
+	var p payload
+	assert.NotPanics(t, func() { r.MustUnmarshal(&p) })
+	assert.Equal(t, "a", p.Foo)
+	assert.Equal(t, `{"Foo":"a"}`, r.MustText())
+
+	r = NewTestResponse(200, nil, []byte(`{"Foo": oops}`))
+	assert.Panics(t, func() { r.MustUnmarshal(&p) })
+}
+
+func TestLines(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for i := 1; i <= 3; i++ {
+			fmt.Fprintf(w, "line %d\r\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(10 * time.Millisecond)
+		}
+		w.Write([]byte(strings.Repeat("x", 100)))
+	}))
+	defer srv.Close()
+
+	resp, err := Send(&Request{Url: srv.URL, NotProcessBody: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	assert.NoError(t, resp.Lines(func(line string) error {
+		lines = append(lines, line)
+		return nil
+	}))
+	assert.Equal(t, []string{"line 1", "line 2", "line 3", strings.Repeat("x", 100)}, lines)
+
+	// Stopping early.
+	resp, err = Send(&Request{Url: srv.URL, NotProcessBody: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := errors.New("stop")
+	assert.Equal(t, stop, resp.Lines(func(line string) error { return stop }))
+
+	// Buffered bodies and the line limit.
+	resp, err = Send(&Request{Url: srv.URL, MaxLineBytes: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines = nil
+	assert.Equal(t, bufio.ErrTooLong, resp.Lines(func(line string) error {
+		lines = append(lines, line)
+		return nil
+	}))
+	assert.Len(t, lines, 3)
+}
+
+func TestLinesCanceled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for {
+			select {
+			case <-req.Context().Done():
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+			w.Write([]byte("tick\n"))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	resp, err := SendWithContext(ctx, &Request{Url: srv.URL, NotProcessBody: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	err = resp.Lines(func(line string) error {
+		if n++; n == 3 {
+			cancel()
+		}
+		return nil
+	})
+	assert.True(t, errors.Is(err, context.Canceled), "%v", err)
+}
+
+func TestDecodeError(t *testing.T) {
+	srv := httptest.NewServer(handleStatusJSON(502, "<html>Bad Gateway</html>"))
+	defer srv.Close()
+	s := Session{}
+	var e apiError
+	resp, err := s.Send(&Request{Url: srv.URL, Method: "GET", Error: &e})
+	assert.NoError(t, err)
+	assert.Equal(t, 502, resp.Status())
+	assert.Error(t, resp.DecodeError())
+
+	srv = httptest.NewServer(handleJSON(`{"Message": "ok"}`))
+	defer srv.Close()
+	resp, err = s.Send(&Request{Url: srv.URL, Method: "GET", Result: &e})
+	assert.NoError(t, err)
+	assert.NoError(t, resp.DecodeError())
+
+	// A failure to decode into Result is returned with the response.
+	srv = httptest.NewServer(handleStatusJSON(200, "<html>Maintenance</html>"))
+	defer srv.Close()
+	for _, stream := range []bool{false, true} {
+		resp, err = s.Send(&Request{Url: srv.URL, Method: "GET", Result: &e, StreamResult: stream})
+		assert.Error(t, err)
+		if assert.NotNil(t, resp, "stream %v", stream) {
+			assert.Equal(t, err, resp.DecodeError())
+			assert.Equal(t, 200, resp.Status())
+			if !stream {
+				assert.Equal(t, "<html>Maintenance</html>", resp.RawText())
+			}
+		}
+	}
+}
+
+func TestNewTestResponse(t *testing.T) {
+	h := http.Header{"Content-Type": {"application/json"}, "Link": {`<http://api.test/?page=2>; rel="next"`}}
+	resp := NewTestResponse(200, h, []byte(`{"a": {"b": [1, 2]}}`))
+	assert.Equal(t, 200, resp.Status())
+	assert.True(t, resp.StatusOk())
+	assert.True(t, resp.IsJsonMime())
+	assert.Equal(t, `{"a": {"b": [1, 2]}}`, resp.RawText())
+	assert.Equal(t, "200 OK", resp.HttpResponse().Status)
+	v, err := resp.Path("a.b.1")
+	assert.NoError(t, err)
+	assert.Equal(t, float64(2), v)
+	assert.Equal(t, "http://api.test/?page=2", resp.Links()[0].URL)
+	body, _ := ioutil.ReadAll(resp.HttpResponse().Body)
+	assert.Equal(t, resp.RawByte(), body)
+
+	resp = NewTestResponse(204, nil, nil)
+	assert.Equal(t, "", resp.RawText())
+	_, err = resp.Location()
+	assert.Error(t, err)
+}
+
+func TestBytesPayload(t *testing.T) {
+	for _, c := range []struct {
+		payload   interface{}
+		header    string
+		noAuto    bool
+		wantType  string
+		wantEmpty bool
+	}{
+		{payload: []byte(`{"a": 1}`), wantType: "application/json"},
+		{payload: []byte(` [1, 2] `), wantType: "application/json"},
+		{payload: []byte(`{not json}`)},
+		{payload: []byte(`42`)},
+		{payload: []byte(`{"a": 1}`), noAuto: true},
+		{payload: []byte(`{"a": 1}`), header: "application/merge-patch+json"}, // The header applies
+		{payload: []byte{0xff, 0x00, '{'}},
+		{payload: []byte{}, wantEmpty: true},
+		{payload: `{"a": 1}`, noAuto: true},
+		{payload: `{"a": 1}`, wantType: "application/json"},
+	} {
+		r := Request{Payload: c.payload, NoAutoContentType: c.noAuto}
+		rd, ct, err := r.encodePayload(c.header)
+		assert.NoError(t, err)
+		if c.wantEmpty {
+			assert.Nil(t, rd)
+			continue
+		}
+		assert.Equal(t, c.wantType, ct, "%q", c.payload)
+		want, ok := c.payload.([]byte)
+		if !ok {
+			want = []byte(c.payload.(string))
+		}
+		b, _ := ioutil.ReadAll(rd)
+		assert.Equal(t, want, b, "%q", c.payload)
+	}
+	assert.NoError(t, (&Request{Url: "http://x.test", Payload: []byte("x")}).Validate())
+}
+
+func BenchmarkBytesPayload(b *testing.B) {
+	payload := bytes.Repeat([]byte(`{"id": 12345, "name": "benchmark"},`), 1000)
+	payload = append(append([]byte{'['}, payload[:len(payload)-1]...), ']')
+	r := Request{Payload: payload}
+	b.SetBytes(int64(len(payload)))
+	for i := 0; i < b.N; i++ {
+		if _, _, err := r.encodePayload(""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module retries requests which failed in transit or were refused by an
+overloaded server.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// A RetryPolicy makes a Session repeat idempotent requests which failed
+// without a response, except for TLS handshake failures, or got status 502,
+// 503 or 504.  The request context bounds all attempts together.  Requests
+// whose body cannot be replayed are attempted only once.  After a connection
+// reset or unexpected EOF, idle pooled connections are closed so that the
+// next attempt dials afresh.  A Retry-After header asking for a longer wait
+// than Backoff is obeyed.
+type RetryPolicy struct {
+	MaxAttempts int           // Including the first; no retries if below 2
+	Backoff     time.Duration // Wait before the second attempt, doubled for each later one
+
+	// PerAttemptTimeout, if set, abandons an attempt which takes longer,
+	// so that it is retried.  It covers reading the response body too.
+	PerAttemptTimeout time.Duration
+}
+
+// retryable reports whether an attempt with the given outcome should be
+// repeated.  handshake tells whether the attempt failed in a TLS handshake.
+func (p *RetryPolicy) retryable(resp *http.Response, err error, handshake bool) bool {
+	if err != nil {
+		return !isTLSError(err, handshake)
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// doRetry sends req with do, repeating it according to s.Retry.  Failures of
+// all but the last attempt are recorded in r.attemptErrs.
+func (s *Session) doRetry(client *http.Client, req *http.Request, r *Request, do func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	p := s.Retry
+	attempts := p.MaxAttempts
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	if !isIdempotent(req.Method) || !replayable || attempts < 1 {
+		attempts = 1
+	}
+	parent := req.Context()
+	wait := p.Backoff
+	for i := 1; ; i++ {
+		attempt := req
+		if i > 1 {
+			next, err := replay(req, nil)
+			if err != nil {
+				return nil, err
+			}
+			attempt = next
+		}
+		cancel := context.CancelFunc(func() {})
+		if p.PerAttemptTimeout > 0 {
+			var ctx context.Context
+			ctx, cancel = context.WithTimeout(parent, p.PerAttemptTimeout)
+			attempt = attempt.WithContext(ctx)
+		}
+		resp, err := do(attempt)
+		if i >= attempts || parent.Err() != nil || !p.retryable(resp, err, tracerFrom(attempt.Context()).inHandshake()) || !s.retryAllowed(req) {
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			resp.Body = &cancelBody{resp.Body, cancel}
+			return resp, nil
+		}
+		delay := wait
+		if err != nil {
+			r.attemptErrs = append(r.attemptErrs, s.proxyError(err))
+			if isStaleConnError(err) {
+				client.CloseIdleConnections()
+			}
+		} else {
+			if ra, ok := parseRetryAfter(resp.Header, s.now()); ok && ra > delay {
+				delay = ra
+			}
+			r.attemptErrs = append(r.attemptErrs, fmt.Errorf("napping: attempt got status %s", resp.Status))
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+			resp.Body.Close()
+		}
+		cancel()
+		s.logError("Retrying", req.Method, req.URL.Redacted(), "after", r.attemptErrs[len(r.attemptErrs)-1])
+
+		if delay > 0 {
+			if err := s.clock().Sleep(parent, delay); err != nil {
+				return nil, err
+			}
+		}
+		wait *= 2
+	}
+}
+
+// isStaleConnError reports whether err is a connection reset or an
+// unexpected end of the connection, as when the server closed it.
+func isStaleConnError(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// doStaleConnRetry sends req with do, and once more on a fresh connection if
+// it was idempotent and failed with a stale connection error on a reused
+// connection.  net/http retries by itself only when no response byte was
+// read.
+func (s *Session) doStaleConnRetry(client *http.Client, req *http.Request, r *Request, do func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	var reused int32
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+		if info.Reused {
+			atomic.StoreInt32(&reused, 1)
+		}
+	}}
+	resp, err := do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err == nil || atomic.LoadInt32(&reused) == 0 || !isStaleConnError(err) ||
+		!isIdempotent(req.Method) || req.Context().Err() != nil || !s.retryAllowed(req) {
+		return resp, err
+	}
+	retry, rerr := replay(req, nil)
+	if rerr != nil {
+		return nil, err
+	}
+	r.attemptErrs = append(r.attemptErrs, err)
+	s.logError("Retrying", req.Method, req.URL.Redacted(), "on a fresh connection after", err)
+	client.CloseIdleConnections()
+	return do(retry)
+}
+
+// A RetryBudget limits the retries of all requests of a Session together,
+// so that an outage does not multiply the load by the number of attempts.
+// Send deposits for each request, and withdraws before each retry, by
+// RetryPolicy or after a stale connection; no retry happens once the budget
+// is exhausted.  Implementations must be safe for concurrent use.
+type RetryBudget interface {
+	Deposit()       // Credit a request sent
+	Withdraw() bool // Take a retry, reporting false if the budget is exhausted
+}
+
+// A TokenBucket is a RetryBudget allowing retries of about Ratio of the
+// requests sent, e.g. 0.1 for 10%, with bursts of up to Max retries.  It
+// starts full.  The zero value allows no retries.
+type TokenBucket struct {
+	Max   float64 // Capacity in retries
+	Ratio float64 // Retries earned per request sent
+
+	mu      sync.Mutex
+	tokens  float64
+	started bool
+}
+
+// fill starts the bucket full on first use.  The caller holds b.mu.
+func (b *TokenBucket) fill() {
+	if !b.started {
+		b.tokens = b.Max
+		b.started = true
+	}
+}
+
+// Deposit credits Ratio tokens, up to Max.
+func (b *TokenBucket) Deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fill()
+	if b.tokens += b.Ratio; b.tokens > b.Max {
+		b.tokens = b.Max
+	}
+}
+
+// Withdraw takes one token if there is one.
+func (b *TokenBucket) Withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fill()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Tokens returns the number of retries currently available.
+func (b *TokenBucket) Tokens() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fill()
+	return b.tokens
+}
+
+// retryAllowed withdraws a retry of req from the session's RetryBudget.
+func (s *Session) retryAllowed(req *http.Request) bool {
+	if s.RetryBudget == nil || s.RetryBudget.Withdraw() {
+		return true
+	}
+	s.logError("Not retrying", req.Method, req.URL.Redacted(), "as the retry budget is exhausted")
+	return false
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetry(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(503)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	s := Session{Retry: &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}}
+	resp, err := s.Get(srv.URL, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// The last status is returned once attempts run out.
+	atomic.StoreInt32(&calls, 0)
+	s.Retry.MaxAttempts = 2
+	resp, err = s.Get(srv.URL, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 503, resp.Status())
+
+	// POST is not retried.
+	atomic.StoreInt32(&calls, 0)
+	resp, err = s.Post(srv.URL, "x")
+	assert.NoError(t, err)
+	assert.Equal(t, 503, resp.Status())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Contains(t, s.String(), "retry=2-attempts")
+}
+
+func TestRetryPerAttemptTimeout(t *testing.T) {
+	var calls, hangs int32 = 0, 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= atomic.LoadInt32(&hangs) {
+			// Hang until abandoned.
+			<-req.Context().Done()
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	s := Session{Retry: &RetryPolicy{MaxAttempts: 2, PerAttemptTimeout: 100 * time.Millisecond}}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	resp, err := s.GetCtx(ctx, srv.URL, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", resp.RawText())
+	assert.True(t, time.Since(start) < 2*time.Second)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// Each attempt times out.
+	atomic.StoreInt32(&calls, 0)
+	atomic.StoreInt32(&hangs, 2)
+	_, err = s.GetCtx(ctx, srv.URL, nil)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Len(t, AttemptErrors(err), 2)
+}
+
+func TestStaleConnRetry(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 2 {
+			// Break the pooled connection midway through the response,
+			// which net/http does not retry by itself.
+			conn, buf, _ := w.(http.Hijacker).Hijack()
+			buf.WriteString("HTTP/1.1 200 OK\r\n")
+			buf.Flush()
+			conn.(*net.TCPConn).SetLinger(0)
+			conn.Close()
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	s := Session{}
+	_, err := s.Get(srv.URL, nil)
+	assert.NoError(t, err)
+	resp, err := s.Get(srv.URL, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", resp.RawText())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// Not for POST, nor when disabled.
+	atomic.StoreInt32(&calls, 1)
+	_, err = s.Post(srv.URL, "x")
+	assert.Error(t, err)
+	atomic.StoreInt32(&calls, 0)
+	s.NoStaleConnRetry = true
+	s.Get(srv.URL, nil)
+	_, err = s.Get(srv.URL, nil)
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// A RetryPolicy retries them as well.
+	atomic.StoreInt32(&calls, 0)
+	s.Retry = &RetryPolicy{MaxAttempts: 2}
+	s.Get(srv.URL, nil)
+	resp, err = s.Get(srv.URL, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", resp.RawText())
+}
+
+func TestRetryBudget(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(503)
+	}))
+	defer srv.Close()
+	budget := &TokenBucket{Max: 3, Ratio: 0.5}
+	s := Session{Retry: &RetryPolicy{MaxAttempts: 3}, RetryBudget: budget}
+
+	// 3 tokens, plus 0.5 per request: 2 retries, then 1.5 left after the
+	// second request's deposit, so one more retry.
+	resp, err := s.Get(srv.URL, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 503, resp.Status())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	_, err = s.Get(srv.URL, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(5), atomic.LoadInt32(&calls))
+	assert.Equal(t, 0.5, budget.Tokens())
+
+	// Once exhausted, only every second request earns a single retry,
+	// although the policy allows two.
+	atomic.StoreInt32(&calls, 0)
+	for i := 0; i < 4; i++ {
+		_, err = s.Get(srv.URL, nil)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(2+1+2+1), atomic.LoadInt32(&calls))
+}
+
+func TestTokenBucket(t *testing.T) {
+	var zero TokenBucket
+	assert.False(t, zero.Withdraw())
+	b := &TokenBucket{Max: 2, Ratio: 1}
+	assert.True(t, b.Withdraw())
+	assert.True(t, b.Withdraw())
+	assert.False(t, b.Withdraw())
+	for i := 0; i < 5; i++ {
+		b.Deposit()
+	}
+	assert.Equal(t, 2.0, b.Tokens())
+}
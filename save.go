@@ -0,0 +1,112 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module saves Responses to a stream and loads them back, for offline
+replay, record/replay testing and simple caches.
+*/
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// savedMagic starts every saved response, and is followed by a block of
+// metadata headers and the response in HTTP/1.1 wire format.
+const savedMagic = "napping-response/1"
+
+// ErrNotSavedResponse is returned by LoadResponse for input which was not
+// written by Response.Save.
+var ErrNotSavedResponse = errors.New("napping: not a saved response")
+
+// Save writes the status, headers and body of the response to w, along with
+// the method and URL of its request and its timestamp, in a format read by
+// LoadResponse.  The body is written as decoded by the session, i.e. after
+// decompression and transformers; bodies which were not captured, with
+// StreamResult or NotProcessBody, are written empty.
+func (r *Response) Save(w io.Writer) error {
+	size := int64(len(r.body))
+	if r.spill != nil {
+		size = r.spill.size
+	}
+	status := r.status
+	var header http.Header
+	if r.response != nil {
+		header = r.response.Header.Clone()
+		status = r.response.StatusCode
+	}
+	if header == nil {
+		header = http.Header{}
+	}
+	// The body is written in full, whatever framing it arrived with.
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.FormatInt(size, 10))
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s\r\n", savedMagic)
+	meta := http.Header{}
+	meta.Set("Method", r.Method)
+	meta.Set("Url", r.Url)
+	meta.Set("Timestamp", r.timestamp.Format(time.RFC3339Nano))
+	meta.Write(bw)
+	fmt.Fprintf(bw, "\r\nHTTP/1.1 %03d %s\r\n", status, http.StatusText(status))
+	header.Write(bw)
+	bw.WriteString("\r\n")
+
+	body := (*Request)(r).bodyReader()
+	if c, ok := body.(io.Closer); ok {
+		defer c.Close()
+	}
+	if _, err := io.Copy(bw, body); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// LoadResponse reads a response written by Response.Save.  Status, the
+// header accessors, RawText, Unmarshal and the other decode helpers work as
+// on the original.
+func LoadResponse(rd io.Reader) (*Response, error) {
+	br := bufio.NewReader(rd)
+	tp := textproto.NewReader(br)
+	line, err := tp.ReadLine()
+	if err != nil || line != savedMagic {
+		return nil, ErrNotSavedResponse
+	}
+	meta, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("napping: loading response: %w", err)
+	}
+	method := meta.Get("Method")
+	u, err := url.Parse(meta.Get("Url"))
+	if err != nil {
+		return nil, fmt.Errorf("napping: loading response: %w", err)
+	}
+	resp, err := http.ReadResponse(br, &http.Request{Method: method, URL: u})
+	if err != nil {
+		return nil, fmt.Errorf("napping: loading response: %w", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("napping: loading response: %w", err)
+	}
+	r := NewTestResponse(resp.StatusCode, resp.Header, body)
+	r.Method = method
+	r.Url = meta.Get("Url")
+	r.timestamp, _ = time.Parse(time.RFC3339Nano, meta.Get("Timestamp"))
+	r.response.Request = resp.Request
+	return r, nil
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveLoadResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("Link", `<https://example.com/a>; rel="next"`)
+		w.Header().Add("X-Multi", "one")
+		w.Header().Add("X-Multi", "two")
+		w.WriteHeader(201)
+		w.Write([]byte(`{"name":"kirk","rank":"captain"}`))
+	}))
+	defer srv.Close()
+	s := Session{}
+	resp, err := s.Post(srv.URL+"/crew?ship=enterprise", map[string]string{"name": "kirk"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, resp.Save(&buf))
+	loaded, err := LoadResponse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 201, loaded.Status())
+	assert.Equal(t, "POST", loaded.Method)
+	assert.Equal(t, srv.URL+"/crew?ship=enterprise", loaded.Url)
+	assert.True(t, resp.Timestamp().Equal(loaded.Timestamp()))
+	assert.Equal(t, resp.RawText(), loaded.RawText())
+	assert.Equal(t, []string{"one", "two"}, loaded.HttpResponse().Header.Values("X-Multi"))
+	assert.True(t, loaded.IsJsonMime())
+	var crew struct{ Name, Rank string }
+	assert.NoError(t, loaded.Unmarshal(&crew))
+	assert.Equal(t, "captain", crew.Rank)
+
+	// Spilled bodies are saved from their file.
+	resp, err = s.Send(&Request{Method: "GET", Url: srv.URL, SpillToDiskOver: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Close()
+	buf.Reset()
+	assert.NoError(t, resp.Save(&buf))
+	loaded, err = LoadResponse(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"kirk","rank":"captain"}`, loaded.RawText())
+
+	_, err = LoadResponse(strings.NewReader("HTTP/1.1 200 OK\r\n\r\n"))
+	assert.Equal(t, ErrNotSavedResponse, err)
+}
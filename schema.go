@@ -0,0 +1,61 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module validates response bodies against a schema, e.g. for contract
+tests.  The validator itself lives in the jsonschema subpackage, so that
+napping has no dependency on it.
+*/
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A SchemaValidator checks a response body against a schema, returning
+// every violation found.  jsonschema.Compile returns one, which may be
+// shared by concurrent requests.
+type SchemaValidator interface {
+	Validate(body []byte) []SchemaViolation
+}
+
+// A SchemaViolation is a part of a document which does not satisfy the
+// schema.  Pointer is the JSON pointer of the offending value, "" for the
+// whole document.
+type SchemaViolation struct {
+	Pointer string
+	Message string
+}
+
+// A SchemaValidationError lists the violations of a response body against
+// Request.Schema.
+type SchemaValidationError struct {
+	Violations []SchemaViolation
+}
+
+func (e *SchemaValidationError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		p := v.Pointer
+		if p == "" {
+			p = "(root)"
+		}
+		msgs[i] = p + ": " + v.Message
+	}
+	return fmt.Sprintf("napping: response violates schema: %s", strings.Join(msgs, "; "))
+}
+
+// validateSchema checks the body of r against r.Schema.
+func (r *Request) validateSchema() error {
+	if r.Schema == nil || len(r.body) == 0 || !r.decodeTarget().result() {
+		return nil
+	}
+	if v := r.Schema.Validate(r.body); len(v) > 0 {
+		return &SchemaValidationError{Violations: v}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// forbidSchema reports a violation wherever its word appears in the body.
+type forbidSchema string
+
+func (f forbidSchema) Validate(body []byte) []SchemaViolation {
+	if strings.Contains(string(body), string(f)) {
+		return []SchemaViolation{{Pointer: "/name", Message: "must not contain " + string(f)}}
+	}
+	return nil
+}
+
+func TestSchema(t *testing.T) {
+	srv := httptest.NewServer(handleJSON(`{"name": "bad"}`))
+	defer srv.Close()
+	s := Session{}
+
+	var result struct{ Name string }
+	resp, err := s.Send(&Request{Url: srv.URL, Method: "GET", Result: &result, Schema: forbidSchema("bad")})
+	var se *SchemaValidationError
+	if assert.True(t, errors.As(err, &se)) {
+		assert.Equal(t, "/name", se.Violations[0].Pointer)
+		assert.EqualError(t, err, "napping: response violates schema: /name: must not contain bad")
+	}
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, "bad", result.Name)
+
+	result.Name = ""
+	_, err = s.Send(&Request{Url: srv.URL, Method: "GET", Result: &result, Schema: forbidSchema("bad"), SchemaBeforeDecode: true, StreamResult: true})
+	assert.Error(t, err)
+	assert.Equal(t, "", result.Name)
+
+	_, err = s.Send(&Request{Url: srv.URL, Method: "GET", Result: &result, Schema: forbidSchema("good")})
+	assert.NoError(t, err)
+	assert.Equal(t, "bad", result.Name)
+}
@@ -12,14 +12,25 @@ requests (cookies, auth, proxies).
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptrace"
 	"net/url"
-	"reflect"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -30,27 +41,788 @@ type Session struct {
 	// Optional
 	Userinfo *url.Userinfo
 
+	// Optional base URL against which relative request URLs are resolved.
+	// Relative paths are appended to the path of BaseURL, and their queries
+	// to its query, so that "users" below "https://api.example.com/v1" is
+	// ".../v1/users".  A path with a leading slash replaces the path of
+	// BaseURL as by RFC 3986, so "/users" is "https://api.example.com/users".
+	BaseURL string
+
+	// Optional RoundTripper used instead of a transport built from the
+	// session's options, e.g. nappingtest.MockTransport.  Options which
+	// configure the transport, such as Proxy and IPPolicy, then do not
+	// apply.  Ignored when Client is supplied.
+	Transport http.RoundTripper
+
 	// Optional defaults - can be overridden in a Request
 	Header *http.Header
 	Params *url.Values
+
+	// Optional source of bearer tokens for the Authorization header.  It
+	// is consulted only when no credentials of higher precedence are
+	// supplied; see AuthSource.
+	TokenSource TokenSource
+
+	// Optional hook resolving request URLs to concrete endpoints, e.g.
+	// "service://users/v1/..." to a host chosen per environment.  It runs
+	// after BaseURL resolution and before query parameters are merged.
+	URLRewriter func(*url.URL) (*url.URL, error)
+
+	// Optional hook fixing up raw request URLs before they are parsed,
+	// e.g. lowercasing hosts or repairing encoding that url.Parse rejects.
+	NormalizeURL func(raw string) (string, error)
+
+	// Optional ordered list of base URLs for relative request URLs.  When
+	// an endpoint cannot be connected to, the request is retried against
+	// the next one, after running BeforeSend, Authorizer and HeaderPolicy
+	// again for its URL.  EndpointPolicy chooses the order; BaseURL is
+	// ignored.
+	Endpoints      []string
+	EndpointPolicy EndpointPolicy
+
+	// With the Failover policy, keep using the endpoint which last
+	// worked instead of always starting with the primary.  After
+	// StickyCooldown the primary is tried first again; zero means never.
+	Sticky         bool
+	StickyCooldown time.Duration
+
+	// With the RoundRobin policy, the relative share of requests sent to
+	// each endpoint.  Missing or non-positive weights count as 1.
+	EndpointWeights []int
+
+	// Eject an endpoint after EjectAfter consecutive connection failures;
+	// zero disables ejection.  Ejected endpoints are tried last, and are
+	// re-probed in the background every EjectFor (default 10s) until they
+	// accept requests again.
+	EjectAfter int
+	EjectFor   time.Duration
+
+	// Stagger between the attempts of Race; DefaultRaceDelay if zero.
+	RaceDelay time.Duration
+
+	// Maximum number of requests the session sends at once, to all hosts
+	// together; zero means unlimited.  Further requests wait for a slot,
+	// or until their context is done.  A slot is held until Send returns,
+	// across retries, so bodies read later with NotProcessBody do not
+	// count.  The limit is read on first use, and again after Reset.
+	MaxConcurrent int
+
+	// Optional Accept-Encoding header, e.g. "identity" or "br, gzip".  When
+	// set, net/http no longer decompresses responses transparently; napping
+	// then decodes gzip and deflate itself if they were offered, and leaves
+	// other encodings to the caller.
+	AcceptEncoding string
+
+	// Optional media type naming the API version, e.g.
+	// "application/vnd.myapi.v3+json".  It is sent as the Accept header, and
+	// as the Content-Type of payloads napping encodes as JSON, unless those
+	// headers are set.  Raw []byte, string and io.Reader payloads keep
+	// their own Content-Type.
+	// A 406 Not Acceptable response to such a request fails with a
+	// NotAcceptableError.  See Request.APIMediaType and Response.APIVersion.
+	APIMediaType string
+
+	// Optional functions rewriting the encoded request body, e.g. to
+	// encrypt or strip fields, in order.  Each receives the content type
+	// and body produced so far and returns replacements; an error aborts
+	// the request.  They run before Content-Length is computed and before
+	// BeforeSend, so signing hooks see the final body.  io.Reader payloads
+	// are read into memory first.
+	PayloadTransformers []func(contentType string, body []byte) ([]byte, string, error)
+
+	// Optional functions rewriting the response body, e.g. to verify and
+	// unwrap a signed envelope, in order, before it is decoded into Result
+	// or Error.  An error fails the request with a ResponseTransformError,
+	// so Result is never populated with unverified data.  They need the
+	// whole body, so StreamResult is ignored when they are set, and they
+	// do not apply with NotProcessBody.
+	ResponseTransformers []func(resp *http.Response, body []byte) ([]byte, error)
+
+	// Optional streaming alternative to ResponseTransformers: functions
+	// wrapping the response body reader, which apply in every mode,
+	// including StreamResult and NotProcessBody.  They run first.
+	ResponseStreamTransformers []func(resp *http.Response, body io.Reader) (io.Reader, error)
+
+	// Optional hook invoked with the fully prepared request just before it
+	// is sent.  req.Context() is the context passed to SendWithContext, so
+	// its values are visible here.  A non-nil error aborts the request.
+	BeforeSend func(r *Request, req *http.Request) error
+
+	// Optional hook invoked with each response, after its body has been
+	// decoded and before Send returns it.  r is the request as sent, with
+	// the Meta stored by BeforeSend.  A non-nil error is returned by Send
+	// together with the response.
+	AfterResponse func(r *Request, resp *Response) error
+
+	// Fail requests with an AuthConflictError when credentials come from
+	// more than one source, instead of using the one of highest precedence
+	// (see AuthSource).
+	StrictAuth bool
+
+	// Optional hook invoked when HTTP Basic Auth is about to be sent in
+	// cleartext, with the request URL stripped of credentials.  It may
+	// warn, ignore the condition by returning nil, or abort the request by
+	// returning an error, such as ErrInsecureAuth.  If nil, a warning is
+	// logged.
+	OnInsecureAuth func(u *url.URL) error
+
+	// Optional function authorizing each request, e.g. by signing it with
+	// HMAC.  It runs last, after BeforeSend, when all other headers are
+	// set, and may replace an Authorization header set from Userinfo or
+	// TokenSource.  A non-nil error aborts the request.
+	Authorizer func(req *http.Request) error
+
+	// Optional guard against sending an unsafe request twice in quick
+	// succession; see Request.AllowDuplicate.
+	DoubleSubmitGuard *DoubleSubmitGuard
+
+	// Headers of the original request to send again on each redirect,
+	// e.g. a custom authentication header, overriding any value net/http
+	// kept.  They are only sent to the same host, over HTTPS if the
+	// original request was, unless PreserveHeadersCrossHost is set, which
+	// leaks them to whichever host the server redirects to.  HeaderPolicy
+	// still applies.
+	PreserveHeadersOnRedirect []string
+	PreserveHeadersCrossHost  bool
+
+	// Optional restrictions on the headers sent to some hosts, applied
+	// after BeforeSend and Authorizer.
+	HeaderPolicy *HeaderPolicy
+
+	// Optional proxy for all requests, and credentials sent to it in the
+	// Proxy-Authorization header.  ProxyUserinfo overrides any userinfo in
+	// the Proxy URL.  An https:// proxy is connected to over TLS, verified
+	// with ProxyTLSConfig rather than the origin's TLS configuration.
+	// Failures to reach the proxy or open a tunnel are reported as a
+	// *ProxyError.  These are ignored when Client is supplied.
+	Proxy          *url.URL
+	ProxyUserinfo  *url.Userinfo
+	ProxyTLSConfig *tls.Config
+
+	// TLS versions and cipher suites accepted for origins, merged into the
+	// TLS configuration of the transport, e.g. tls.VersionTLS12.  Zero
+	// values keep the crypto/tls defaults, and the cipher suites do not
+	// apply to TLS 1.3.  A failed handshake is reported as a *TLSError.
+	// These are ignored when Client or Transport is supplied.
+	TLSMinVersion   uint16
+	TLSMaxVersion   uint16
+	TLSCipherSuites []uint16
+
+	// Maximum time to establish a connection, applied to dialing and to
+	// the TLS handshake each, and to wait for the response headers once
+	// the request is written.  Exceeding either fails the request with a
+	// *TimeoutError naming the phase.  These are independent of
+	// BodyTimeout and of context deadlines, and are ignored when Client
+	// or Transport is supplied.
+	ConnectTimeout        time.Duration
+	ResponseHeaderTimeout time.Duration
+
+	// Default of Request.BodyTimeout for requests which do not set it.
+	BodyTimeout time.Duration
+
+	// PinnedCertFingerprints, if not nil, lists the SHA-256 fingerprints of
+	// the leaf certificates origins may present, in hex with or without
+	// colons, as returned by Response.PeerCertFingerprint.  List both the
+	// old and new certificate while rotating.  Other certificates fail the
+	// handshake, before any request is written, with a *PinMismatchError.
+	// Ignored when Client or Transport is supplied.
+	PinnedCertFingerprints []string
+
+	// Log every request and response for debugging.  Credentials are
+	// redacted, JSON bodies are pretty-printed, and binary or compressed
+	// bodies are summarized by size and SHA-256 hash.
+	Log bool
+
+	// Optional writer receiving a copy of all bytes sent and received on
+	// the session's connections, for debugging at the wire level.  Bytes
+	// of both directions are interleaved as they pass.  HTTPS traffic is
+	// seen decrypted and forced to HTTP/1.1, except when tunneled through
+	// Proxy.  Tapping has a cost, and is ignored when Client or Transport
+	// is supplied.
+	Tap io.Writer
+
+	// Upper bounds of latency buckets, in ascending order.  After each
+	// response OnLatencyBucket is called with the index of the first bucket
+	// at least as long as Timings.Total, or len(LatencyBuckets) if it is
+	// longer than all of them.  Counting the calls gives approximate
+	// percentiles.
+	LatencyBuckets  []time.Duration
+	OnLatencyBucket func(bucket int)
+
+	// Guess the type of response bodies from their first bytes when the
+	// Content-Type header is missing, unparseable or
+	// application/octet-stream, to choose between JSON and XML decoding.
+	// See Response.EffectiveContentType.
+	SniffContentType bool
+
+	// Also log errors met while sending, including those returned to the
+	// caller, and failures which are not returned, such as of mirrored
+	// requests or of decoding into Error.
+	LogErrors bool
+
+	// Destination of log output; the standard logger if nil.
+	Logger Logger
+
+	// Statuses interpreted by Exists.
+	ExistsOptions ExistsOptions
+
+	// Decode JSON numbers as json.Number for all requests; see
+	// Request.UseNumber.
+	UseNumber bool
+
+	// IP families used to connect to dual-stack hosts, how long to wait
+	// before falling back to the other family, and an optional resolver
+	// for host names, e.g. a stub in tests.  These are ignored when Client
+	// is supplied.
+	IPPolicy      IPPolicy
+	FallbackDelay time.Duration // Default 300ms
+	Resolver      Resolver
+
+	// Optional limit on the size of response headers, guarding against
+	// servers which send enormous ones.  Zero means the net/http default.
+	// It is ignored when Client is supplied.
+	MaxResponseHeaderBytes int64
+
+	// Dial a new connection for every request and close it afterwards,
+	// e.g. to test the distribution of a load balancer.  Otherwise, when a
+	// server closes most connections by itself, Stats.ConnectionsClosed
+	// counts it and a warning is logged once per host.  It is ignored when
+	// Client or Transport is supplied.
+	DisableKeepAlives bool
+
+	// If set, a 401 Unauthorized response makes the session force a token
+	// refresh and retry the request exactly once.
+	RefreshOn401 bool
+
+	// Retry, if set, repeats requests which failed in transit.
+	Retry *RetryPolicy
+
+	// Optional limit on the retries of all requests together, e.g. a
+	// *TokenBucket, to avoid retry storms during outages.
+	RetryBudget RetryBudget
+
+	// Without Retry, an idempotent request which fails because the server
+	// reset or closed a reused connection, typically one killed while idle
+	// in the pool, is sent once more on a fresh connection.  This disables
+	// that retry.
+	NoStaleConnRetry bool
+
+	// Mirror, if set, re-sends a sample of requests to another service.
+	Mirror *Mirror
+
+	st  *sessionState
+	dns *dnsCache // Enabled by EnableDNSCache
+	clk Clock     // Set by SetClock
+}
+
+// sessionState holds mutable state shared by all requests of a Session.
+type sessionState struct {
+	endpoints endpointState
+	stats     Stats // Updated atomically
+	closes    closeState
+	sem       chan struct{} // Slots of MaxConcurrent
+	stop      chan struct{} // Closed by Reset, stopping endpoint probes
+}
+
+// stateMu guards the lazy creation of session state.
+var stateMu sync.Mutex
+
+func (s *Session) state() *sessionState {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if s.st == nil {
+		s.st = &sessionState{stop: make(chan struct{})}
+	}
+	return s.st
+}
+
+// semaphore returns the channel bounding concurrent requests to
+// MaxConcurrent, or nil if they are unlimited.
+func (s *Session) semaphore() chan struct{} {
+	if s.MaxConcurrent <= 0 {
+		return nil
+	}
+	st := s.state()
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if st.sem == nil {
+		st.sem = make(chan struct{}, s.MaxConcurrent)
+	}
+	return st.sem
+}
+
+// A TokenSource supplies bearer tokens, e.g. from an OAuth flow.
+type TokenSource interface {
+	// Token returns a valid token.  When refresh is true the source must
+	// obtain a new token rather than return a cached one.
+	Token(ctx context.Context, refresh bool) (string, error)
 }
 
 // Send constructs and sends an HTTP request.
-func (s *Session) Send(r *Request) (response *Response, err error) {
-	r.Method = strings.ToUpper(r.Method)
+func (s *Session) Send(r *Request) (*Response, error) {
+	return s.SendWithContext(context.Background(), r)
+}
+
+// SendRaw constructs and sends an HTTP request as Send does, merging params,
+// assembling headers, authenticating and encoding the payload, and returns
+// the live response without reading or decoding its body, as with
+// NotProcessBody.  The caller must close the body.  On error any response
+// is closed, and nil is returned.
+func (s *Session) SendRaw(r *Request) (*http.Response, error) {
+	return s.SendRawWithContext(context.Background(), r)
+}
+
+// SendRawWithContext is SendRaw with a context.  The body of the response
+// must be read before ctx is done.
+func (s *Session) SendRawWithContext(ctx context.Context, r *Request) (*http.Response, error) {
+	notProcess := r.NotProcessBody
+	r.NotProcessBody = true
+	defer func() { r.NotProcessBody = notProcess }()
+	resp, err := s.SendWithContext(ctx, r)
+	if err != nil {
+		if resp != nil && resp.response != nil {
+			resp.response.Body.Close()
+		}
+		return nil, err
+	}
+	return resp.response, nil
+}
+
+// SendWithContext constructs and sends an HTTP request.  The request is
+// canceled when ctx is done.  If the request failed after earlier attempts
+// failed, the error is an *AttemptsError.
+func (s *Session) SendWithContext(ctx context.Context, r *Request) (response *Response, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		if cancel != nil {
+			cancel()
+		}
+	}()
+	r.attemptErrs = nil
+	r.Meta = copyMeta(r.Meta)
+	ctx = context.WithValue(ctx, metaKey{}, r.Meta)
+	defer func() {
+		if r.payloadFile != nil {
+			r.payloadFile.Close()
+			r.payloadFile = nil
+		}
+	}()
+	defer func() {
+		if err != nil && len(r.attemptErrs) > 0 {
+			err = &AttemptsError{Errs: append(r.attemptErrs, err)}
+		}
+	}()
+	if sem := s.semaphore(); sem != nil {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	tr := &tracer{now: s.now}
+	var notReused int32
+	var schemaErr, decodeErr error
+	if r.RequireReusedConn {
+		tr.onGotConn = func(info httptrace.GotConnInfo) {
+			if !info.Reused {
+				atomic.StoreInt32(&notReused, 1)
+				cancel()
+			}
+		}
+	}
+	base := s.BaseURL
+	var order []int
+	if len(s.Endpoints) > 0 && !isAbsURL(r.Url) {
+		order = s.endpointOrder()
+		base = s.Endpoints[order[0]]
+	}
+	req, err := s.prepare(tr.trace(ctx), r, base)
+	if err != nil {
+		return
+	}
+
+	var header http.Header
+	if order != nil {
+		header = req.Header.Clone()
+	}
+	if err = s.hooks(r, req); err != nil {
+		return
+	}
+	if s.Log {
+		s.dumpRequest(r, req)
+	}
 
+	r.timestamp = s.now()
+	r.clk = s.clk
+	if s.DoubleSubmitGuard != nil && !r.AllowDuplicate {
+		if sub, dup := s.DoubleSubmitGuard.begin(req, r.timestamp); dup {
+			return s.duplicate(r, req, sub)
+		} else if sub != nil {
+			defer func() { s.DoubleSubmitGuard.finish(sub, response, err) }()
+		}
+	}
+	r.decodeErr = nil
+	r.unwrapped = false
+	r.problem = nil
+	tr.start = r.timestamp
+	client := s.redirectClient(s.client(r), r)
+	if r.DisableConnReuse {
+		client = freshConnClient(client)
+		req.Close = true
+	}
+	stats := &s.state().stats
+	stats.countSent(req)
+	if s.RetryBudget != nil {
+		s.RetryBudget.Deposit()
+	}
+	do := client.Do
+	if order != nil {
+		do = func(req *http.Request) (*http.Response, error) {
+			return s.doEndpoints(client, req, header, r, order)
+		}
+	}
+	var resp *http.Response
+	switch {
+	case s.Retry != nil:
+		resp, err = s.doRetry(client, req, r, do)
+	case !s.NoStaleConnRetry:
+		resp, err = s.doStaleConnRetry(client, req, r, do)
+	default:
+		resp, err = do(req)
+	}
+	if err != nil && r.counter != nil {
+		if n := atomic.LoadInt64(&r.counter.n); n != r.ContentLength {
+			err = &ContentLengthError{Declared: r.ContentLength, Actual: n}
+		}
+	}
+	if err != nil {
+		if atomic.LoadInt32(&notReused) == 1 {
+			err = ErrConnNotReused
+		}
+		err = s.timeoutError(tlsError(s.proxyError(err), tr.inHandshake()), tr)
+		stats.countResult(nil, err)
+		s.logError(err)
+		return
+	}
+	if resp.StatusCode == http.StatusUnauthorized && s.RefreshOn401 && r.auth.source == AuthTokenSource {
+		resp, err = s.refreshAndRetry(client, req, resp)
+		if err != nil {
+			stats.countResult(nil, err)
+			s.logError(err)
+			return
+		}
+	}
+	stats.countResult(resp, nil)
+	s.countClose(req, resp)
+	if resp.TLS == nil {
+		resp.TLS = tr.connState()
+	}
+	r.status = resp.StatusCode
+	r.response = resp
+
+	if s.AcceptEncoding != "" {
+		if err = decompress(resp, req.Header.Get("Accept-Encoding")); err != nil {
+			resp.Body.Close()
+			return
+		}
+	}
+
+	bodyTimeout := r.BodyTimeout
+	if bodyTimeout == 0 {
+		bodyTimeout = s.BodyTimeout
+	}
+	if bodyTimeout > 0 {
+		resp.Body = newTimeoutBody(resp.Body, bodyTimeout, cancel)
+	}
+	if r.BodyIdleTimeout > 0 {
+		resp.Body = newIdleBody(resp.Body, r.BodyIdleTimeout, cancel)
+	}
+
+	if err = s.transformStream(resp); err != nil {
+		resp.Body.Close()
+		return
+	}
+
+	if r.NotProcessBody {
+		// The caller reads the body; release the context when it is closed.
+		resp.Body = &cancelBody{resp.Body, cancel}
+		cancel = nil
+	} else if r.StreamResult && r.Result != nil && r.decodeTarget().result() && len(s.ResponseTransformers) == 0 && !isProto(r.Result) && !isXMLMime(resp.Header.Get("Content-Type")) && r.Schema == nil &&
+		lookupDecoder(resp.Header.Get("Content-Type")) == nil && !isCSVMime(resp.Header.Get("Content-Type")) && !r.UnwrapDoubleEncoded &&
+		!(s.SniffContentType && needsSniff(resp.Header.Get("Content-Type"))) {
+		defer resp.Body.Close()
+
+		// Decode straight from the wire; the body is not captured.
+		r.body = nil
+		dec := json.NewDecoder(resp.Body)
+		if r.UseNumber || r.useNumber {
+			dec.UseNumber()
+		}
+		if err := dec.Decode(r.Result); err != nil && err != io.EOF {
+			// io.EOF is an empty body, as of a 204, which leaves Result as is.
+			r.decodeErr = err
+			s.logError(err)
+			decodeErr = err
+		}
+	} else {
+		defer resp.Body.Close()
+
+		r.spill = nil
+		if r.SpillToDiskOver > 0 && len(s.ResponseTransformers) == 0 {
+			r.body, r.spill, err = readOrSpill(resp.Body, r.SpillToDiskOver)
+		} else {
+			r.body, err = ioutil.ReadAll(resp.Body)
+		}
+		if err != nil {
+			s.logError(err)
+			return
+		}
+		if r.body, err = s.transformResponse(resp, r.body); err != nil {
+			s.logError(err)
+			return
+		}
+		r.setEffectiveType(s.SniffContentType)
+		r.decodeProblem()
+		schemaErr = r.validateSchema()
+		if schemaErr == nil || !r.SchemaBeforeDecode {
+			decodeErr = s.decode(r)
+		}
+	}
+	r.timings = tr.done()
+	r.remoteAddr = tr.remoteAddr()
+	r.proxy = tr.usedProxy()
+	if r.proxy == nil && client.Transport == nil {
+		r.proxy = defaultProxy(req)
+	}
+	if s.OnLatencyBucket != nil {
+		s.OnLatencyBucket(latencyBucket(s.LatencyBuckets, r.timings.Total))
+	}
+	if s.Log {
+		s.dumpResponse(r, resp)
+	}
+
+	rsp := Response(*r)
+	response = &rsp
+	if s.Mirror != nil {
+		s.mirror(client, req, response)
+	}
+	err = decodeErr
+	if err == nil {
+		err = schemaErr
+	}
+	if mt := s.apiMediaType(r); err == nil && mt != "" && resp.StatusCode == http.StatusNotAcceptable {
+		err = notAcceptable(mt, response)
+	}
+	if err == nil && r.ValidateResponse != nil &&
+		(!r.ValidateSuccessOnly || response.Status()/100 == 2) {
+		err = r.ValidateResponse(response)
+	}
+	if s.AfterResponse != nil {
+		if hookErr := s.AfterResponse(r, response); err == nil {
+			err = hookErr
+		}
+	}
+	return
+}
+
+// decode unmarshals the response body into the Result and/or Error of r, as
+// selected by r.DecodeInto.  Protobuf messages are decoded from protobuf
+// responses.  Failure to decode into Result is returned;
+// failure to decode into Error is only logged, since error bodies are often
+// not JSON (e.g. an HTML page from a proxy).  Either failure is recorded for
+// Response.DecodeError.
+func (s *Session) decode(r *Request) error {
+	if len(r.body) == 0 && r.spill == nil {
+		return nil
+	}
+	target := r.decodeTarget()
+	if r.Result != nil && target.result() {
+		if err := r.unmarshalBody(r.Result); err != nil {
+			r.decodeErr = err
+			s.logError(err)
+			return err
+		}
+	}
+	if r.Error != nil && target.error() {
+		if err := r.unmarshalBody(r.Error); err != nil {
+			r.decodeErr = err
+			s.logError(err)
+		}
+	}
+	return nil
+}
+
+// unmarshalBody decodes the response body into v, as protobuf if v is a
+// protobuf message and the response says it is one, as XML if the response
+// says it is XML, and as JSON otherwise.  This lets a request that accepts
+// several formats decode whichever the server chose.
+func (r *Request) unmarshalBody(v interface{}) error {
+	contentType := r.effectiveType
+	if contentType == "" {
+		contentType = r.response.Header.Get("Content-Type")
+	}
+	if r.spill != nil {
+		return r.decodeSpilled(v, contentType)
+	}
+	body := r.decodableBody()
+	if dec := lookupDecoder(contentType); dec != nil {
+		return dec(body, v)
+	}
+	if comma, ok := csvMime(contentType); ok && isCSVTarget(v) {
+		return decodeCSV(bytes.NewReader(body), v, CSVOptions{Comma: comma})
+	}
+	if isProto(v) && isProtoMime(contentType) {
+		return unmarshalProto(body, v)
+	}
+	if isXMLMime(contentType) {
+		return xml.Unmarshal(body, v)
+	}
+	if r.UnwrapDoubleEncoded {
+		if inner, ok := unwrapJSONString(body); ok {
+			r.unwrapped = true
+			body = inner
+		}
+	}
+	return unmarshalJSON(body, v, r.UseNumber || r.useNumber)
+}
+
+// isXMLMime reports whether contentType is an XML media type.
+func isXMLMime(contentType string) bool {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	return mt == "application/xml" || mt == "text/xml" || strings.HasSuffix(mt, "+xml")
+}
+
+// ErrUnsupportedScheme is returned for request URLs whose scheme is not
+// http or https, after BaseURL resolution and URLRewriter.  A relative URL
+// sent without a BaseURL has an empty scheme.
+var ErrUnsupportedScheme = errors.New("napping: unsupported URL scheme")
+
+// ErrInsecureAuth may be returned by Session.OnInsecureAuth to refuse sending
+// HTTP Basic Auth in cleartext.
+var ErrInsecureAuth = errors.New("napping: HTTP Basic Auth in cleartext refused")
+
+// normalizeURL applies the session's NormalizeURL to raw.
+func (s *Session) normalizeURL(raw string) (string, error) {
+	if s.NormalizeURL == nil {
+		return raw, nil
+	}
+	norm, err := s.NormalizeURL(raw)
+	if err != nil {
+		return "", fmt.Errorf("napping: normalizing URL: %w", err)
+	}
+	return norm, nil
+}
+
+// resolveURL parses raw, resolving it against base if it is relative, and
+// applies the session's URLRewriter.
+func (s *Session) resolveURL(raw, base string) (u *url.URL, err error) {
 	// Create a URL object from the raw url string.  This will allow us to compose
 	// query parameters programmatically and be guaranteed of a well-formed URL.
-	u, err := url.Parse(r.Url)
+	u, err = url.Parse(raw)
 	if err != nil {
-		s.log("URL", r.Url)
-		s.log(err)
+		s.logError("URL", raw)
+		s.logError(err)
+		return
+	}
+	if !u.IsAbs() && base != "" {
+		var b *url.URL
+		b, err = url.Parse(base)
+		if err != nil {
+			s.logError("BaseURL", base)
+			s.logError(err)
+			return
+		}
+		if u, err = joinURL(b, u); err != nil {
+			s.logError(err)
+			return
+		}
+	}
+	if s.URLRewriter != nil {
+		orig := u.Redacted()
+		u, err = s.URLRewriter(u)
+		if err != nil {
+			err = fmt.Errorf("napping: rewriting URL %s: %w", orig, err)
+			return
+		}
+	}
+	return
+}
+
+// joinURL appends the path of the relative-path reference ref to the path
+// of base, so that "users?active=true" below
+// "https://api.example.com/v1?key=k" becomes
+// "https://api.example.com/v1/users?key=k&active=true".  Dot segments are
+// resolved, but never above the path of base.  Absolute-path ("/users") and
+// network-path ("//host/path") references are resolved as by RFC 3986, so
+// "/users" replaces the path of base.
+func joinURL(base, ref *url.URL) (*url.URL, error) {
+	if ref.Host != "" || strings.HasPrefix(ref.Path, "/") {
+		return base.ResolveReference(ref), nil
+	}
+	out := *base
+	out.Fragment = ref.Fragment
+	out.RawFragment = ref.RawFragment
+	if ref.Path != "" {
+		// Resolve dot segments of ref on their own first, so that ".."
+		// cannot climb out of the base path.
+		rel, err := url.Parse("/" + ref.EscapedPath())
+		if err != nil {
+			return nil, err
+		}
+		rel = (&url.URL{Path: "/"}).ResolveReference(rel)
+		joined, err := url.Parse(strings.TrimSuffix(base.EscapedPath(), "/") + rel.EscapedPath())
+		if err != nil {
+			return nil, err
+		}
+		out.Path, out.RawPath = joined.Path, joined.RawPath
+	}
+	switch {
+	case ref.RawQuery == "":
+	case out.RawQuery == "":
+		out.RawQuery = ref.RawQuery
+	default:
+		out.RawQuery += "&" + ref.RawQuery
+	}
+	return &out, nil
+}
+
+// prepare merges Session and Request options into an *http.Request ready
+// to be sent.  Relative request URLs are resolved against base.
+func (s *Session) prepare(ctx context.Context, r *Request, base string) (req *http.Request, err error) {
+	raw := r.Url
+	if !r.bare {
+		if raw, err = s.normalizeURL(raw); err != nil {
+			return
+		}
+	}
+	v := *r
+	v.Url = raw
+	if err = v.Validate(); err != nil {
+		return
+	}
+	r.Method = strings.ToUpper(r.Method)
+	r.useNumber = s.UseNumber
+
+	var u *url.URL
+	if r.bare {
+		if u, err = url.Parse(raw); err == nil && !u.IsAbs() {
+			err = fmt.Errorf("napping: URL %s is not absolute", u.Redacted())
+		}
+	} else {
+		u, err = s.resolveURL(raw, base)
+	}
+	if err != nil {
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		err = fmt.Errorf("%w %q in URL %s", ErrUnsupportedScheme, u.Scheme, u.Redacted())
 		return
 	}
 
 	// Default query parameters
 	p := url.Values{}
-	if s.Params != nil {
+	if s.Params != nil && !r.NoSessionParams {
 		for k, v := range *s.Params {
 			p[k] = v
 		}
@@ -63,6 +835,17 @@ func (s *Session) Send(r *Request) (response *Response, err error) {
 		}
 	}
 
+	// Params encoded from a struct
+	if r.ParamsStruct != nil {
+		sp, err := EncodeQuery(r.ParamsStruct)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range sp {
+			p[k] = v
+		}
+	}
+
 	// User-supplied params override default
 	if r.Params != nil {
 		for k, v := range *r.Params {
@@ -71,198 +854,788 @@ func (s *Session) Send(r *Request) (response *Response, err error) {
 	}
 
 	// Encode parameters
-	u.RawQuery = p.Encode()
+	if r.PreserveQuery {
+		p = u.Query()
+	} else {
+		u.RawQuery = p.Encode()
+	}
 
 	// Attach params to response
 	r.Params = &p
 
 	// Create a Request object; if populated, Data field is JSON encoded as request body
 	header := http.Header{}
-	if s.Header != nil {
-		for k := range *s.Header {
-			v := s.Header.Get(k)
-			header.Set(k, v)
-		}
-	}
-
-	if r.Payload != nil {
-		if _, ok := r.Payload.(io.Reader); !ok {
-			var bydata []byte
-			kind := reflect.TypeOf(r.Payload).Kind()
-			switch kind {
-			case reflect.String:
-				r.Payload = []byte(r.Payload.(string))
-				fallthrough
-			case reflect.Slice:
-				var ok bool
-				bydata, ok = r.Payload.([]byte)
-				if !ok {
-					bydata, err = json.Marshal(r.Payload)
-				}
-			default:
-				bydata, err = json.Marshal(r.Payload)
-			}
-			if err != nil {
-				return
-			}
-			if len(bydata) != 0 {
-				r.Payload = bytes.NewBuffer(bydata)
-				if ("{" == string(bydata[0]) && "}" == string(bydata[len(bydata)-1])) ||
-					("[" == string(bydata[0]) && "]" == string(bydata[len(bydata)-1])) {
-					header.Set("Content-Type", "application/json")
-				}
-			}
+	if s.Header != nil && !r.NoSessionHeaders {
+		for k, v := range *s.Header {
+			header[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
 		}
 	}
 
 	var paylodReader io.Reader
-	if r.Payload != nil {
-		paylodReader = r.Payload.(io.Reader)
+	var contentType string
+	if len(r.Files) > 0 {
+		var body *bytes.Buffer
+		body, contentType, err = r.encodeMultipart()
+		if err != nil {
+			return
+		}
+		paylodReader = body
+	} else if r.Payload != nil {
+		requested := header.Get("Content-Type")
+		if r.Header != nil && r.Header.Get("Content-Type") != "" {
+			requested = r.Header.Get("Content-Type")
+		}
+		paylodReader, contentType, err = r.encodePayload(requested)
+		if err != nil {
+			return
+		}
+		if mt := s.apiMediaType(r); mt != "" && requested == "" &&
+			contentType == "application/json" && r.marshalsPayload() {
+			contentType = mt
+		}
+	} else if r.PayloadFile != "" {
+		if r.payloadFile, err = os.Open(r.PayloadFile); err != nil {
+			return
+		}
+		paylodReader = r.payloadFile
+		if contentType = mime.TypeByExtension(filepath.Ext(r.PayloadFile)); contentType == "" {
+			contentType = "application/octet-stream"
+		}
+	}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
 	}
 
-	req, err := http.NewRequest(r.Method, u.String(), paylodReader)
-	if err != nil {
-		s.log(err)
-		return
+	transformed := false
+	if len(s.PayloadTransformers) > 0 && paylodReader != nil {
+		if r.Header != nil && r.Header.Get("Content-Type") != "" {
+			contentType = r.Header.Get("Content-Type")
+		}
+		paylodReader, contentType, err = s.transformPayload(r, paylodReader, contentType)
+		if err != nil {
+			return
+		}
+		transformed = true
+	}
+
+	r.counter = nil
+	if r.ContentLength > 0 && paylodReader != nil && !transformed {
+		r.counter = &countingReader{r: paylodReader}
+		paylodReader = r.counter
 	}
 
-	// Merge Session and Request options
-	var userinfo *url.Userinfo
-	if u.User != nil {
-		userinfo = u.User
+	req, err = http.NewRequestWithContext(ctx, r.Method, u.String(), paylodReader)
+	if err != nil {
+		s.logError(err)
+		return
 	}
-	if s.Userinfo != nil {
-		userinfo = s.Userinfo
+	switch {
+	case r.payloadFile != nil && !transformed:
+		var fi os.FileInfo
+		if fi, err = r.payloadFile.Stat(); err != nil {
+			return
+		}
+		req.ContentLength = fi.Size()
+		if fi.Size() == 0 {
+			req.Body = http.NoBody
+		}
+		// Reopen the file to send it again, e.g. on retry.
+		path := r.PayloadFile
+		req.GetBody = func() (io.ReadCloser, error) { return os.Open(path) }
+	case r.counter != nil:
+		req.ContentLength = r.ContentLength
+	case r.ForceChunked && paylodReader != nil:
+		req.ContentLength = -1
+		req.TransferEncoding = []string{"chunked"}
 	}
-	// Prefer Request's user credentials
-	if r.Userinfo != nil {
-		userinfo = r.Userinfo
+
+	// Decide which credentials to send
+	if !r.bare {
+		r.auth, err = s.resolveAuth(r, u)
+		if err != nil {
+			return
+		}
 	}
 	if r.Header != nil {
+		// Request headers replace session headers of the same name.
 		for k, v := range *r.Header {
-			header.Set(k, v[0]) // Is there always guarnateed to be at least one value for a header?
+			if len(v) > 0 {
+				header[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+			}
 		}
 	}
-	if header.Get("Accept") == "" {
-		header.Add("Accept", "*/*") // Default, can be overridden with Opts
+	if transformed {
+		header.Del("Content-Type")
+		if contentType != "" {
+			header.Set("Content-Type", contentType)
+		}
+	}
+	if r.bare {
+		// An empty User-Agent stops net/http from adding its own.
+		header["User-Agent"] = []string{""}
+	}
+	if header.Get("Accept") == "" && !r.bare {
+		if mt := s.apiMediaType(r); mt != "" {
+			header.Add("Accept", mt)
+		} else if isProto(r.Result) {
+			header.Add("Accept", ProtoContentType+", application/json;q=0.9")
+		} else {
+			header.Add("Accept", "*/*") // Default, can be overridden with Opts
+		}
+	}
+	if s.AcceptEncoding != "" && header.Get("Accept-Encoding") == "" && !r.bare {
+		header.Set("Accept-Encoding", s.AcceptEncoding)
+	}
+	if header.Get("User-Agent") == "" && !r.bare {
+		header.Set("User-Agent", userAgent())
 	}
 	req.Header = header
 
-	// Set HTTP Basic authentication if userinfo is supplied
-	if userinfo != nil {
-		pwd, _ := userinfo.Password()
-		req.SetBasicAuth(userinfo.Username(), pwd)
+	// Structured cookies are appended to any manually set Cookie header.
+	for _, c := range r.Cookies {
+		req.AddCookie(c)
+	}
+
+	// Raw headers bypass canonicalization and replace any header which
+	// differs from them only in case.
+	for k, v := range r.RawHeaders {
+		delete(req.Header, http.CanonicalHeaderKey(k))
+		req.Header[k] = v
+	}
+
+	// Set HTTP Basic authentication or a bearer token, unless an
+	// Authorization header of higher precedence was set already.  URL
+	// userinfo is removed, so that net/http does not apply it regardless.
+	req.URL.User = nil
+	switch r.auth.source {
+	case AuthRequestUserinfo, AuthURLUserinfo, AuthSessionUserinfo:
+		pwd, _ := r.auth.userinfo.Password()
+		req.SetBasicAuth(r.auth.userinfo.Username(), pwd)
 		if u.Scheme != "https" {
-			s.log("WARNING: Using HTTP Basic Auth in cleartext is insecure.")
+			target := *req.URL // The hook must not redirect the request
+			if s.OnInsecureAuth == nil {
+				s.log("WARNING: Using HTTP Basic Auth in cleartext is insecure.")
+			} else if err = s.OnInsecureAuth(&target); err != nil {
+				return
+			}
+		}
+	case AuthTokenSource:
+		var token string
+		token, err = s.TokenSource.Token(ctx, false)
+		if err != nil {
+			return
 		}
+		req.Header.Set("Authorization", "Bearer "+token)
 	}
+	return
+}
 
-	r.timestamp = time.Now()
-	var client *http.Client
-	if s.Client != nil {
-		client = s.Client
-	} else {
-		client = &http.Client{}
-		if r.Transport != nil {
-			client.Transport = r.Transport
+// client returns the session's HTTP client, creating it on first use.
+func (s *Session) client(r *Request) *http.Client {
+	if s.Client == nil {
+		s.Client = &http.Client{}
+		switch {
+		case s.Transport != nil:
+			s.Client.Transport = s.Transport
+		case s.transportConfigured():
+			s.Client.Transport = s.newTransport(r.Transport)
+		case r.Transport != nil:
+			s.Client.Transport = r.Transport
 		}
+	}
+	return s.Client
+}
 
-		s.Client = client
+// transportConfigured reports whether an option of the session needs a
+// transport of its own.  Otherwise the shared http.DefaultTransport, or
+// Request.Transport, is used as is, so that sessions made for a single call
+// do not each keep a pool of idle connections.
+func (s *Session) transportConfigured() bool {
+	return s.TLSMinVersion != 0 || s.TLSMaxVersion != 0 || s.TLSCipherSuites != nil ||
+		s.PinnedCertFingerprints != nil ||
+		s.IPPolicy != Auto || s.Resolver != nil || s.dns != nil ||
+		s.ConnectTimeout > 0 || s.ResponseHeaderTimeout > 0 ||
+		s.MaxResponseHeaderBytes > 0 || s.DisableKeepAlives ||
+		s.Proxy != nil || s.Tap != nil
+}
+
+// freshConnClient returns a copy of client which dials a new connection for
+// each request and does not keep it, if its transport can be copied.  The
+// copy shares nothing with the pool of client.
+func freshConnClient(client *http.Client) *http.Client {
+	t, ok := client.Transport.(*http.Transport)
+	if client.Transport == nil {
+		t, ok = http.DefaultTransport.(*http.Transport)
 	}
-	resp, err := client.Do(req)
-	if err != nil {
-		s.log(err)
-		return
+	if !ok {
+		return client
 	}
-	r.status = resp.StatusCode
-	r.response = resp
+	c := *client
+	fresh := t.Clone()
+	fresh.DisableKeepAlives = true
+	c.Transport = fresh
+	return &c
+}
 
-	if !r.NotProcessBody {
-		defer resp.Body.Close()
+// newTransport returns a copy of base, or of http.DefaultTransport if base is
+// nil, configured from the session's options.  See transportConfigured.
+func (s *Session) newTransport(base *http.Transport) *http.Transport {
+	if base == nil {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	t := base.Clone()
+	s.configureTLS(t)
+	if s.IPPolicy != Auto || s.Resolver != nil || s.dns != nil {
+		t.DialContext = s.policyDialer(t.DialContext)
+	}
+	s.configureTimeouts(t)
+	if s.MaxResponseHeaderBytes > 0 {
+		t.MaxResponseHeaderBytes = s.MaxResponseHeaderBytes
+	}
+	if s.DisableKeepAlives {
+		t.DisableKeepAlives = true
+	}
+	if s.Proxy != nil {
+		s.configureProxy(t)
+	}
+	if t.Proxy != nil {
+		t.Proxy = s.recordProxy(t.Proxy)
+	}
+	if s.Tap != nil {
+		s.configureTap(t)
+	}
+	return t
+}
 
-		r.body, err = ioutil.ReadAll(resp.Body)
-		if err != nil {
-			s.log(err)
+// hooks runs BeforeSend, Authorizer and HeaderPolicy, in that order, on
+// req prepared from r.
+func (s *Session) hooks(r *Request, req *http.Request) (err error) {
+	if s.BeforeSend != nil {
+		if err = s.BeforeSend(r, req); err != nil {
 			return
 		}
 	}
-
-	rsp := Response(*r)
-	response = &rsp
+	if s.Authorizer != nil && !r.bare {
+		before := req.Header.Get("Authorization")
+		if err = s.Authorizer(req); err != nil {
+			return
+		}
+		if req.Header.Get("Authorization") != before {
+			r.auth.source = AuthAuthorizer
+		}
+	}
+	r.policyRemoved, err = s.HeaderPolicy.apply(req.URL.Hostname(), req.Header)
 	return
 }
 
+// refreshAndRetry forces a token refresh after the server answered req with
+// 401 Unauthorized, and sends req once more with the new token.  If the
+// request body cannot be replayed the original response is returned.
+func (s *Session) refreshAndRetry(client *http.Client, req *http.Request, resp *http.Response) (*http.Response, error) {
+	retry, err := replay(req, nil)
+	if err != nil {
+		return resp, nil
+	}
+	token, err := s.TokenSource.Token(req.Context(), true)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	retry.Header.Set("Authorization", "Bearer "+token)
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return client.Do(retry)
+}
+
+// replay returns a copy of req with a fresh body, so that it can be sent
+// again.  If u is not nil it replaces the URL of the copy.  An error is
+// returned if the body cannot be replayed.
+func replay(req *http.Request, u *url.URL) (*http.Request, error) {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return nil, errors.New("napping: request body cannot be replayed")
+	}
+	out := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		out.Body = body
+	}
+	if u != nil {
+		out.URL = u
+		out.Host = u.Host
+	}
+	return out, nil
+}
+
+// Warmup establishes a connection to the host of url, by sending a HEAD
+// request, so that a subsequent request can reuse the pooled connection
+// without paying for DNS lookup, TCP connect and TLS handshake.
+func (s *Session) Warmup(ctx context.Context, url string) error {
+	_, err := s.HeadCtx(ctx, url)
+	return err
+}
+
+// ExistsOptions configures Session.Exists.  The zero value selects the
+// defaults.
+type ExistsOptions struct {
+	Found    []int // Statuses meaning the resource exists; default 200, 204
+	NotFound []int // Statuses meaning it does not; default 404, 410
+
+	// Do not retry with a GET when the server rejects HEAD with 405 or 501.
+	NoGetFallback bool
+}
+
+// Exists reports whether the resource at url exists, using a HEAD request.
+// If the server does not allow HEAD, a GET for its first byte (Range:
+// bytes=0-0) is sent instead, for which 206 and 416 also mean that the
+// resource exists; its body is discarded.  Statuses which are neither in
+// ExistsOptions.Found nor NotFound are returned as a *StatusError.
+func (s *Session) Exists(url string, p *url.Values) (bool, *Response, error) {
+	return s.ExistsCtx(context.Background(), url, p)
+}
+
+// ExistsCtx is Exists, canceled when ctx is done.
+func (s *Session) ExistsCtx(ctx context.Context, url string, p *url.Values) (bool, *Response, error) {
+	found, notFound := s.ExistsOptions.Found, s.ExistsOptions.NotFound
+	if found == nil {
+		found = []int{200, 204}
+	}
+	if notFound == nil {
+		notFound = []int{404, 410}
+	}
+	resp, err := s.SendWithContext(ctx, &Request{Method: "HEAD", Url: url, Params: p})
+	if err != nil {
+		return false, nil, err
+	}
+	if st := resp.Status(); (st == 405 || st == 501) && !s.ExistsOptions.NoGetFallback {
+		h := http.Header{"Range": {"bytes=0-0"}}
+		resp, err = s.SendWithContext(ctx, &Request{Method: "GET", Url: url, Params: p, Header: &h, NotProcessBody: true})
+		if err != nil {
+			return false, nil, err
+		}
+		resp.HttpResponse().Body.Close()
+		found = append(found[:len(found):len(found)], 206, 416)
+	}
+	if resp.Expect(found...) == nil {
+		return true, resp, nil
+	}
+	if resp.Expect(notFound...) == nil {
+		return false, resp, nil
+	}
+	return false, resp, resp.Expect(append(found[:len(found):len(found)], notFound...)...)
+}
+
 // Get sends a GET request.
 func (s *Session) Get(url string, p *url.Values) (*Response, error) {
+	return s.GetCtx(context.Background(), url, p)
+}
+
+// GetCtx sends a GET request, canceled when ctx is done.
+func (s *Session) GetCtx(ctx context.Context, url string, p *url.Values) (*Response, error) {
 	r := Request{
 		Method: "GET",
 		Url:    url,
 		Params: p,
 	}
-	return s.Send(&r)
+	return s.SendWithContext(ctx, &r)
 }
 
 // Options sends an OPTIONS request.
 func (s *Session) Options(url string) (*Response, error) {
+	return s.OptionsCtx(context.Background(), url)
+}
+
+// OptionsCtx sends an OPTIONS request, canceled when ctx is done.
+func (s *Session) OptionsCtx(ctx context.Context, url string) (*Response, error) {
 	r := Request{
 		Method: "OPTIONS",
 		Url:    url,
 	}
-	return s.Send(&r)
+	return s.SendWithContext(ctx, &r)
 }
 
 // Head sends a HEAD request.
 func (s *Session) Head(url string) (*Response, error) {
+	return s.HeadCtx(context.Background(), url)
+}
+
+// HeadCtx sends a HEAD request, canceled when ctx is done.
+func (s *Session) HeadCtx(ctx context.Context, url string) (*Response, error) {
 	r := Request{
 		Method: "HEAD",
 		Url:    url,
 	}
-	return s.Send(&r)
+	return s.SendWithContext(ctx, &r)
 }
 
 // Post sends a POST request.
 func (s *Session) Post(url string, payload interface{}) (*Response, error) {
+	return s.PostCtx(context.Background(), url, payload)
+}
+
+// PostCtx sends a POST request, canceled when ctx is done.
+func (s *Session) PostCtx(ctx context.Context, url string, payload interface{}) (*Response, error) {
 	r := Request{
 		Method:  "POST",
 		Url:     url,
 		Payload: payload,
 	}
-	return s.Send(&r)
+	return s.SendWithContext(ctx, &r)
+}
+
+// PostForm sends data as an application/x-www-form-urlencoded POST request.
+// The response is decoded into result or errMsg, as for Request.Result and
+// Request.Error; either may be nil.
+func (s *Session) PostForm(url string, data url.Values, result, errMsg interface{}) (*Response, error) {
+	return s.PostFormCtx(context.Background(), url, data, result, errMsg)
+}
+
+// PostFormCtx sends a form POST request, canceled when ctx is done.
+func (s *Session) PostFormCtx(ctx context.Context, url string, data url.Values, result, errMsg interface{}) (*Response, error) {
+	h := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+	r := Request{
+		Method:  "POST",
+		Url:     url,
+		Header:  &h,
+		Payload: data.Encode(),
+		Result:  result,
+		Error:   errMsg,
+	}
+	return s.SendWithContext(ctx, &r)
+}
+
+// PostAndFollow POSTs payload to url without following redirects.  If the
+// response is 201, 202 or 303 with a Location header, the resource there is
+// fetched with GET and decoded into result, and that response is returned.
+// Otherwise the POST response is decoded into result or errMsg as usual.
+func (s *Session) PostAndFollow(url string, payload, result, errMsg interface{}) (*Response, error) {
+	return s.PostAndFollowCtx(context.Background(), url, payload, result, errMsg)
+}
+
+// PostAndFollowCtx is PostAndFollow with a context.
+func (s *Session) PostAndFollowCtx(ctx context.Context, url string, payload, result, errMsg interface{}) (*Response, error) {
+	follow := func(status int) bool {
+		return status == http.StatusCreated || status == http.StatusAccepted || status == http.StatusSeeOther
+	}
+	r := Request{
+		Method:     "POST",
+		Url:        url,
+		Payload:    payload,
+		Result:     result,
+		Error:      errMsg,
+		NoRedirect: true,
+		// The created resource is decoded from the GET instead.
+		DecodeInto: func(status int) DecodeTarget {
+			if follow(status) {
+				return DecodeNone
+			}
+			return DefaultDecodeInto(status)
+		},
+	}
+	resp, err := s.SendWithContext(ctx, &r)
+	if err != nil || !follow(resp.Status()) {
+		return resp, err
+	}
+	loc, err := resp.Location()
+	if err == http.ErrNoLocation {
+		if resp.Status() == http.StatusSeeOther {
+			return resp, nil
+		}
+		// A 201 or 202 may carry the representation itself.
+		resp.DecodeInto = nil
+		return resp, s.decode((*Request)(resp))
+	} else if err != nil {
+		return resp, fmt.Errorf("napping: invalid Location: %w", err)
+	}
+	return s.SendWithContext(ctx, &Request{
+		Method: "GET",
+		Url:    loc.String(),
+		Result: result,
+		Error:  errMsg,
+	})
 }
 
 // Put sends a PUT request.
 func (s *Session) Put(url string, payload interface{}) (*Response, error) {
+	return s.PutCtx(context.Background(), url, payload)
+}
+
+// PutCtx sends a PUT request, canceled when ctx is done.
+func (s *Session) PutCtx(ctx context.Context, url string, payload interface{}) (*Response, error) {
 	r := Request{
 		Method:  "PUT",
 		Url:     url,
 		Payload: payload,
 	}
-	return s.Send(&r)
+	return s.SendWithContext(ctx, &r)
 }
 
 // Patch sends a PATCH request.
 func (s *Session) Patch(url string, payload interface{}) (*Response, error) {
+	return s.PatchCtx(context.Background(), url, payload)
+}
+
+// PatchCtx sends a PATCH request, canceled when ctx is done.
+func (s *Session) PatchCtx(ctx context.Context, url string, payload interface{}) (*Response, error) {
 	r := Request{
 		Method:  "PATCH",
 		Url:     url,
 		Payload: payload,
 	}
-	return s.Send(&r)
+	return s.SendWithContext(ctx, &r)
 }
 
 // Delete sends a DELETE request.
 func (s *Session) Delete(url string, p *url.Values) (*Response, error) {
+	return s.DeleteCtx(context.Background(), url, p)
+}
+
+// DeleteCtx sends a DELETE request, canceled when ctx is done.
+func (s *Session) DeleteCtx(ctx context.Context, url string, p *url.Values) (*Response, error) {
 	r := Request{
 		Method: "DELETE",
 		Url:    url,
 		Params: p,
 	}
-	return s.Send(&r)
+	return s.SendWithContext(ctx, &r)
+}
+
+// A ContentLengthError reports that a payload reader yielded a different
+// number of bytes than declared in Request.ContentLength.  The transport
+// refuses to send such a request, so the server never sees a truncated or
+// overlong body.
+type ContentLengthError struct {
+	Declared int64
+	Actual   int64 // Bytes read before the mismatch was detected
+}
+
+func (e *ContentLengthError) Error() string {
+	return fmt.Sprintf("napping: payload declared %d bytes but yielded %d", e.Declared, e.Actual)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+// cancelBody cancels the request context when the body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// ErrBodyTimeout is returned when the response body is not read completely
+// within Request.BodyTimeout or Session.BodyTimeout.
+var ErrBodyTimeout = errors.New("napping: response body not received within BodyTimeout")
+
+// timeoutBody cancels the request if the body is not read completely before
+// its timer fires, and reports the resulting read error as ErrBodyTimeout.
+type timeoutBody struct {
+	io.ReadCloser
+	timer *time.Timer
+	fired int32
+}
+
+func newTimeoutBody(body io.ReadCloser, d time.Duration, cancel context.CancelFunc) *timeoutBody {
+	b := &timeoutBody{ReadCloser: body}
+	b.timer = time.AfterFunc(d, func() {
+		atomic.StoreInt32(&b.fired, 1)
+		cancel()
+	})
+	return b
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.timer.Stop()
+	} else if err != nil && atomic.LoadInt32(&b.fired) == 1 {
+		err = ErrBodyTimeout
+	}
+	return n, err
+}
+
+func (b *timeoutBody) Close() error {
+	b.timer.Stop()
+	return b.ReadCloser.Close()
+}
+
+// A BodyStalledError is returned when no bytes of the response body arrive
+// for Request.BodyIdleTimeout.
+type BodyStalledError struct {
+	Idle     time.Duration // The BodyIdleTimeout exceeded
+	Received int64         // Bytes of the body received before the stall
+}
+
+func (e *BodyStalledError) Error() string {
+	return fmt.Sprintf("napping: response body stalled for %s after %d bytes", e.Idle, e.Received)
+}
+
+// Timeout reports true, as for net.Error.
+func (e *BodyStalledError) Timeout() bool {
+	return true
+}
+
+// idleBody is a watchdog which cancels the request if its timer fires
+// before the next bytes arrive, and reports the resulting read error as a
+// *BodyStalledError.
+type idleBody struct {
+	io.ReadCloser
+	idle     time.Duration
+	timer    *time.Timer
+	fired    int32
+	received int64
+}
+
+func newIdleBody(body io.ReadCloser, d time.Duration, cancel context.CancelFunc) *idleBody {
+	b := &idleBody{ReadCloser: body, idle: d}
+	b.timer = time.AfterFunc(d, func() {
+		atomic.StoreInt32(&b.fired, 1)
+		cancel()
+	})
+	return b
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && atomic.LoadInt32(&b.fired) == 0 {
+		b.received += int64(n)
+		b.timer.Reset(b.idle)
+	}
+	if err == io.EOF {
+		b.timer.Stop()
+	} else if err != nil && atomic.LoadInt32(&b.fired) == 1 {
+		err = &BodyStalledError{Idle: b.idle, Received: b.received}
+	}
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	b.timer.Stop()
+	return b.ReadCloser.Close()
+}
+
+// Reset clears the state a long-lived session accumulates: the cookie jar of
+// the Client, if it has one, is replaced by an empty cookiejar.Jar, idle
+// connections are closed, and endpoint statistics and sticky choices are
+// forgotten.  Configuration such as Header, Params, credentials and the
+// Client itself is kept; see ResetDefaults.  Reset must not be called while
+// requests are in flight.
+func (s *Session) Reset() {
+	if s.Client != nil {
+		if s.Client.Jar != nil {
+			s.Client.Jar, _ = cookiejar.New(nil)
+		}
+		s.Client.CloseIdleConnections()
+	}
+	stateMu.Lock()
+	if s.st != nil {
+		close(s.st.stop)
+	}
+	s.st = nil
+	stateMu.Unlock()
+}
+
+// SetHeaders sets the default headers sent with every request, replacing
+// any values of the same names.  Header is created if it is nil.
+func (s *Session) SetHeaders(headers map[string]string) {
+	if s.Header == nil {
+		s.Header = &http.Header{}
+	}
+	for k, v := range headers {
+		s.Header.Set(k, v)
+	}
+}
+
+// AddHeader adds a value to a default header, keeping any existing values.
+// Header is created if it is nil.
+func (s *Session) AddHeader(key, value string) {
+	if s.Header == nil {
+		s.Header = &http.Header{}
+	}
+	s.Header.Add(key, value)
+}
+
+// ResetDefaults is Reset, but also sets the default Header and Params to nil.
+func (s *Session) ResetDefaults() {
+	s.Reset()
+	s.Header = nil
+	s.Params = nil
+}
+
+// String returns a one-line summary of the session's configuration, suitable
+// for logging at startup.  Credentials are never included.
+func (s *Session) String() string {
+	base := s.BaseURL
+	if base == "" {
+		base = "none"
+	} else if u, err := url.Parse(base); err == nil {
+		base = u.Redacted()
+	}
+	auth := "none"
+	switch {
+	case s.Userinfo != nil:
+		auth = "basic"
+	case s.TokenSource != nil:
+		auth = "bearer"
+	}
+	proxy := "none"
+	if s.Proxy != nil {
+		u := *s.Proxy
+		u.User = nil
+		proxy = u.String()
+		if s.ProxyUserinfo != nil || s.Proxy.User != nil {
+			proxy += " (authenticated)"
+		}
+	}
+	timeout := "none"
+	if s.Client != nil && s.Client.Timeout > 0 {
+		timeout = s.Client.Timeout.String()
+	}
+	var retries []string
+	if s.Retry != nil && s.Retry.MaxAttempts > 1 {
+		retries = append(retries, fmt.Sprintf("%d-attempts", s.Retry.MaxAttempts))
+	}
+	if s.RefreshOn401 {
+		retries = append(retries, "refresh-on-401")
+	}
+	retry := "none"
+	if len(retries) > 0 {
+		retry = strings.Join(retries, ",")
+	}
+	return fmt.Sprintf("napping/%s session base_url=%s auth=%s proxy=%s timeout=%s retry=%s",
+		Version, base, auth, proxy, timeout, retry)
 }
 
 // Debug method for logging
 // Centralizing logging in one method
 // avoids spreading conditionals everywhere
 func (s *Session) log(args ...interface{}) {
-	log.Println(args...)
+	l := s.Logger
+	if l == nil {
+		l = log.Default()
+	}
+	l.Printf("%s", fmt.Sprintln(args...))
+}
+
+// logError logs an error met while sending, if LogErrors is set.
+func (s *Session) logError(args ...interface{}) {
+	if s.LogErrors {
+		s.log(args...)
+	}
 }
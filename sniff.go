@@ -0,0 +1,90 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module guesses the type of response bodies which arrive without a
+usable Content-Type, enabled by Session.SniffContentType.
+*/
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+)
+
+// sniffLen is the length of the body prefix inspected by sniffing.
+const sniffLen = 512
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// needsSniff reports whether contentType is missing, unparseable or too
+// generic to choose a decoder.
+func needsSniff(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	return err != nil || mt == "application/octet-stream"
+}
+
+// sniffContentType guesses the media type of body from its first bytes:
+// JSON objects and arrays and XML documents are recognized, after any
+// UTF-8 byte order mark and leading white space.  Other bodies are typed
+// by http.DetectContentType.
+func sniffContentType(body []byte) string {
+	if len(body) > sniffLen {
+		body = body[:sniffLen]
+	}
+	b := bytes.TrimLeft(bytes.TrimPrefix(body, utf8BOM), " \t\r\n")
+	switch {
+	case len(b) == 0:
+		return ""
+	case b[0] == '{' || b[0] == '[':
+		return "application/json"
+	case bytes.HasPrefix(b, []byte("<?xml")):
+		return "application/xml"
+	}
+	return http.DetectContentType(body)
+}
+
+// setEffectiveType records the Content-Type by which the body of r is
+// decoded: the response header, or the sniffed type if sniff is set and the
+// header is not usable.
+func (r *Request) setEffectiveType(sniff bool) {
+	ct := r.response.Header.Get("Content-Type")
+	r.sniffed = false
+	if sniff && needsSniff(ct) {
+		body := r.body
+		if r.spill != nil {
+			body = r.spill.prefix
+		}
+		if guess := sniffContentType(body); guess != "" {
+			ct = guess
+			r.sniffed = true
+		}
+	}
+	r.effectiveType = ct
+}
+
+// decodableBody returns the body of r to decode, without the byte order
+// mark that sniffing looked past.
+func (r *Request) decodableBody() []byte {
+	if r.sniffed {
+		return bytes.TrimPrefix(r.body, utf8BOM)
+	}
+	return r.body
+}
+
+// EffectiveContentType returns the media type by which the response body
+// was decoded: that of the Content-Type header, or the type sniffed from
+// the body if Session.SniffContentType is set and the header was missing or
+// application/octet-stream.
+func (r *Response) EffectiveContentType() string {
+	ct := r.effectiveType
+	if ct == "" && r.response != nil {
+		ct = r.response.Header.Get("Content-Type")
+	}
+	mt, _, _ := mime.ParseMediaType(ct)
+	return mt
+}
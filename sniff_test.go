@@ -0,0 +1,73 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSniffContentType(t *testing.T) {
+	for body, want := range map[string]string{
+		`{"a": 1}`:                                "application/json",
+		"\xEF\xBB\xBF  [1, 2]":                    "application/json",
+		`<?xml version="1.0"?><a/>`:               "application/xml",
+		"\xEF\xBB\xBF<?xml version=\"1.0\"?><a/>": "application/xml",
+		"plain words":                             "text/plain; charset=utf-8",
+		"":                                        "",
+	} {
+		assert.Equal(t, want, sniffContentType([]byte(body)), body)
+	}
+	assert.True(t, needsSniff(""))
+	assert.True(t, needsSniff("application/octet-stream"))
+	assert.True(t, needsSniff("not a type;;"))
+	assert.False(t, needsSniff("text/plain"))
+}
+
+func TestSniffedDecode(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Suppress the Content-Type net/http would detect.
+		w.Header()["Content-Type"] = nil
+		switch req.URL.Path {
+		case "/xml":
+			w.Write([]byte(`<?xml version="1.0"?><feed><title>sniffed</title></feed>`))
+		case "/bom":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte("\xEF\xBB\xBF{\"title\": \"bom\"}"))
+		case "/explicit":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(`<?xml version="1.0"?><feed><title>x</title></feed>`))
+		}
+	}))
+	defer srv.Close()
+
+	s := Session{SniffContentType: true}
+	var f feed
+	resp, err := s.Send(&Request{Url: srv.URL + "/xml", Method: "GET", Result: &f, StreamResult: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "sniffed", f.Title)
+	assert.Equal(t, "application/xml", resp.EffectiveContentType())
+	assert.Equal(t, "", resp.HttpResponse().Header.Get("Content-Type"))
+
+	resp, err = s.Send(&Request{Url: srv.URL + "/bom", Method: "GET", Result: &f})
+	assert.NoError(t, err)
+	assert.Equal(t, "bom", f.Title)
+	assert.Equal(t, "application/json", resp.EffectiveContentType())
+
+	// An explicit Content-Type is not overridden.
+	_, err = s.Send(&Request{Url: srv.URL + "/explicit", Method: "GET", Result: &f})
+	assert.Error(t, err)
+	resp, err = s.Get(srv.URL+"/explicit", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain", resp.EffectiveContentType())
+
+	// Without sniffing, the XML body is decoded as JSON.
+	_, err = (&Session{}).Send(&Request{Url: srv.URL + "/xml", Method: "GET", Result: &f})
+	assert.Error(t, err)
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module captures response bodies larger than Request.SpillToDiskOver in
+a temporary file instead of memory.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// ErrBodySpilled is returned by methods which need the whole response body
+// in memory when it was spilled to disk; see Request.SpillToDiskOver.
+var ErrBodySpilled = errors.New("napping: response body was spilled to disk; use BodyReader")
+
+// spill is a response body captured in a temporary file.
+type spill struct {
+	path   string
+	size   int64
+	prefix []byte // The first bytes of the body, for sniffing
+
+	mu      sync.Mutex
+	readers []*os.File
+	removed bool
+}
+
+// readOrSpill reads rd into memory if it has at most max bytes, and into a
+// temporary file otherwise.
+func readOrSpill(rd io.Reader, max int64) ([]byte, *spill, error) {
+	buf, err := ioutil.ReadAll(io.LimitReader(rd, max+1))
+	if err != nil || int64(len(buf)) <= max {
+		return buf, nil, err
+	}
+	f, err := ioutil.TempFile("", "napping-body-")
+	if err != nil {
+		return nil, nil, err
+	}
+	n, err := io.Copy(f, io.MultiReader(bytes.NewReader(buf), rd))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, nil, err
+	}
+	if len(buf) > sniffLen {
+		buf = buf[:sniffLen]
+	}
+	sp := &spill{path: f.Name(), size: n, prefix: buf}
+	// Remove the file even if Response.Close is never called.
+	runtime.SetFinalizer(sp, (*spill).remove)
+	return nil, sp, nil
+}
+
+// open returns a new reader over the spilled body, closed by remove if the
+// caller does not close it.
+func (sp *spill) open() (*os.File, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.removed {
+		return nil, os.ErrClosed
+	}
+	f, err := os.Open(sp.path)
+	if err == nil {
+		sp.readers = append(sp.readers, f)
+	}
+	return f, err
+}
+
+// remove closes the readers of the spilled body and deletes its file.
+func (sp *spill) remove() error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.removed {
+		return nil
+	}
+	sp.removed = true
+	for _, f := range sp.readers {
+		f.Close()
+	}
+	sp.readers = nil
+	return os.Remove(sp.path)
+}
+
+// decodeSpilled decodes the spilled body of r into v as contentType,
+// streaming it from the file like unmarshalBody does from memory.
+func (r *Request) decodeSpilled(v interface{}, contentType string) error {
+	f, err := r.spill.open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	if r.sniffed {
+		if b, _ := br.Peek(len(utf8BOM)); bytes.Equal(b, utf8BOM) {
+			br.Discard(len(utf8BOM))
+		}
+	}
+	codec := lookupDecoder(contentType)
+	comma, isCSV := csvMime(contentType)
+	switch {
+	case codec != nil:
+		data, err := ioutil.ReadAll(br)
+		if err != nil {
+			return err
+		}
+		return codec(data, v)
+	case isCSV && isCSVTarget(v):
+		return decodeCSV(br, v, CSVOptions{Comma: comma})
+	case isProto(v) && isProtoMime(contentType):
+		data, err := ioutil.ReadAll(br)
+		if err != nil {
+			return err
+		}
+		return unmarshalProto(data, v)
+	case isXMLMime(contentType):
+		return xml.NewDecoder(br).Decode(v)
+	}
+	dec := json.NewDecoder(br)
+	if r.UseNumber || r.useNumber {
+		dec.UseNumber()
+	}
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("napping: invalid data after top-level JSON value")
+	}
+	return nil
+}
+
+// bodyReader returns a reader over the captured body of r, from memory or
+// from the spill file.
+func (r *Request) bodyReader() io.Reader {
+	if r.spill == nil {
+		return bytes.NewReader(r.body)
+	}
+	f, err := r.spill.open()
+	if err != nil {
+		return &errReader{err}
+	}
+	return f
+}
+
+// errReader fails every read with err.
+type errReader struct{ err error }
+
+func (e *errReader) Read([]byte) (int, error) { return 0, e.err }
+
+// Spilled reports whether the body was spilled to a temporary file, in
+// which case RawByte and RawText return nothing and BodyReader reads from
+// the file.
+func (r *Response) Spilled() bool {
+	return r.spill != nil
+}
+
+// Close removes the temporary file of a body spilled to disk, closing any
+// readers returned by BodyReader.  It does nothing for bodies held in
+// memory.
+func (r *Response) Close() error {
+	if r.spill == nil {
+		return nil
+	}
+	return r.spill.remove()
+}
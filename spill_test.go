@@ -0,0 +1,69 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpillToDisk(t *testing.T) {
+	big := `{"title": "` + strings.Repeat("x", 4096) + `"}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if req.URL.Path == "/small" {
+			w.Write([]byte(`{"title": "small"}`))
+			return
+		}
+		w.Write([]byte(big))
+	}))
+	defer srv.Close()
+
+	s := Session{}
+	var f feed
+	resp, err := s.Send(&Request{Url: srv.URL + "/small", Method: "GET", Result: &f, SpillToDiskOver: 1024})
+	assert.NoError(t, err)
+	assert.False(t, resp.Spilled())
+	assert.Equal(t, "small", f.Title)
+	assert.NoError(t, resp.Close())
+
+	resp, err = s.Send(&Request{Url: srv.URL + "/big", Method: "GET", Result: &f, SpillToDiskOver: 1024})
+	assert.NoError(t, err)
+	assert.True(t, resp.Spilled())
+	assert.Equal(t, 4096, len(f.Title))
+	assert.Nil(t, resp.RawByte())
+	assert.Equal(t, "", resp.RawText())
+	assert.Equal(t, big, resp.MustText())
+	path := resp.spill.path
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+
+	b, err := ioutil.ReadAll(resp.BodyReader())
+	assert.NoError(t, err)
+	assert.Equal(t, big, string(b))
+	var g feed
+	assert.NoError(t, resp.Unmarshal(&g))
+	assert.Equal(t, f.Title, g.Title)
+	_, err = resp.DiffAgainstFile("unused.json", DiffOptions{})
+	assert.Equal(t, ErrBodySpilled, err)
+
+	open := resp.BodyReader()
+	assert.NoError(t, resp.Close())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	_, err = ioutil.ReadAll(open)
+	assert.Error(t, err)
+	_, err = ioutil.ReadAll(resp.BodyReader())
+	assert.Error(t, err)
+	assert.NoError(t, resp.Close())
+	assert.Panics(t, func() { resp.MustText() })
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module counts the requests of a Session, and sorts their latencies
+into buckets, for a quick health snapshot without wiring up full metrics.
+*/
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats counts the requests sent by a Session since it was created or Reset.
+type Stats struct {
+	Requests        int64 // Requests sent
+	Successes       int64 // Responses with a status below 400
+	ClientErrors    int64 // Responses with a 4xx status
+	ServerErrors    int64 // Responses with a 5xx status
+	TransportErrors int64 // Requests which got no response
+	BytesSent       int64 // Request body bytes
+	BytesReceived   int64 // Response body bytes, as received on the wire
+
+	// Responses with which the server closed a connection the client
+	// meant to keep, e.g. by sending Connection: close.
+	ConnectionsClosed int64
+}
+
+// Stats returns a snapshot of the session's counters.  It is safe to call
+// while requests are in flight.
+func (s *Session) Stats() Stats {
+	c := &s.state().stats
+	return Stats{
+		Requests:        atomic.LoadInt64(&c.Requests),
+		Successes:       atomic.LoadInt64(&c.Successes),
+		ClientErrors:    atomic.LoadInt64(&c.ClientErrors),
+		ServerErrors:    atomic.LoadInt64(&c.ServerErrors),
+		TransportErrors: atomic.LoadInt64(&c.TransportErrors),
+		BytesSent:       atomic.LoadInt64(&c.BytesSent),
+		BytesReceived:   atomic.LoadInt64(&c.BytesReceived),
+
+		ConnectionsClosed: atomic.LoadInt64(&c.ConnectionsClosed),
+	}
+}
+
+// countSent counts the body of req as it is sent.
+func (c *Stats) countSent(req *http.Request) {
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &countingBody{req.Body, &c.BytesSent}
+	}
+}
+
+// countResult counts the outcome of a request, and the body of resp as it
+// is read.
+func (c *Stats) countResult(resp *http.Response, err error) {
+	atomic.AddInt64(&c.Requests, 1)
+	switch {
+	case err != nil:
+		atomic.AddInt64(&c.TransportErrors, 1)
+		return
+	case resp.StatusCode >= 500:
+		atomic.AddInt64(&c.ServerErrors, 1)
+	case resp.StatusCode >= 400:
+		atomic.AddInt64(&c.ClientErrors, 1)
+	default:
+		atomic.AddInt64(&c.Successes, 1)
+	}
+	resp.Body = &countingBody{resp.Body, &c.BytesReceived}
+}
+
+// Hosts closing at least half of the connections of closeWarnMin responses
+// are warned about.
+const closeWarnMin = 10
+
+// closeState tracks, per host, how often servers refuse keep-alive.
+type closeState struct {
+	mu    sync.Mutex
+	hosts map[string]*hostCloses
+}
+
+type hostCloses struct {
+	responses, closed int
+	warned            bool
+}
+
+// countClose counts resp if the server closed a connection which the
+// session meant to keep, and logs a warning, once per host, when a host
+// does so for most responses.
+func (s *Session) countClose(req *http.Request, resp *http.Response) {
+	if req.Close || s.DisableKeepAlives {
+		return
+	}
+	st := s.state()
+	if resp.Close {
+		atomic.AddInt64(&st.stats.ConnectionsClosed, 1)
+	}
+	c := &st.closes
+	c.mu.Lock()
+	if c.hosts == nil {
+		c.hosts = make(map[string]*hostCloses)
+	}
+	h := c.hosts[req.URL.Host]
+	if h == nil {
+		h = &hostCloses{}
+		c.hosts[req.URL.Host] = h
+	}
+	h.responses++
+	if resp.Close {
+		h.closed++
+	}
+	warn := !h.warned && h.responses >= closeWarnMin && 2*h.closed >= h.responses
+	if warn {
+		h.warned = true
+	}
+	closed, responses := h.closed, h.responses
+	c.mu.Unlock()
+	if warn {
+		s.log(fmt.Sprintf("WARNING: %s closed the connection after %d of %d responses (Connection: close); connections are not reused.",
+			req.URL.Host, closed, responses))
+	}
+}
+
+// latencyBucket returns the index of the first of buckets which is at least
+// d, or len(buckets) if d is longer than all of them.
+func latencyBucket(buckets []time.Duration, d time.Duration) int {
+	return sort.Search(len(buckets), func(i int) bool { return buckets[i] >= d })
+}
+
+// countingBody adds the bytes read through it to n.
+type countingBody struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(b.n, int64(n))
+	return n, err
+}
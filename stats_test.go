@@ -0,0 +1,141 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/missing":
+			w.WriteHeader(404)
+		case "/broken":
+			w.WriteHeader(503)
+		}
+		w.Write([]byte("0123456789"))
+	}))
+	defer srv.Close()
+	dead := httptest.NewServer(nil)
+	dead.Close()
+
+	s := Session{}
+	// The client is created by the first request.
+	s.Get(srv.URL+"/ok", nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Get(srv.URL+"/ok", nil)
+		}()
+	}
+	wg.Wait()
+	s.Post(srv.URL+"/ok", "abc")
+	s.Get(srv.URL+"/missing", nil)
+	s.Get(srv.URL+"/broken", nil)
+	s.Get(dead.URL, nil)
+
+	st := s.Stats()
+	assert.Equal(t, int64(8), st.Requests)
+	assert.Equal(t, int64(5), st.Successes)
+	assert.Equal(t, int64(1), st.ClientErrors)
+	assert.Equal(t, int64(1), st.ServerErrors)
+	assert.Equal(t, int64(1), st.TransportErrors)
+	assert.Equal(t, int64(3), st.BytesSent)
+	assert.Equal(t, int64(70), st.BytesReceived)
+
+	s.Reset()
+	assert.Equal(t, Stats{}, s.Stats())
+}
+
+func TestLatencyBucket(t *testing.T) {
+	buckets := []time.Duration{10 * time.Millisecond, 100 * time.Millisecond, time.Second}
+	for d, want := range map[time.Duration]int{
+		0:                      0,
+		10 * time.Millisecond:  0,
+		11 * time.Millisecond:  1,
+		100 * time.Millisecond: 1,
+		500 * time.Millisecond: 2,
+		time.Second:            2,
+		time.Minute:            3,
+	} {
+		assert.Equal(t, want, latencyBucket(buckets, d), "%s", d)
+	}
+	assert.Equal(t, 0, latencyBucket(nil, time.Second))
+}
+
+func TestLatencyBuckets(t *testing.T) {
+	c := newStubClock()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			c.Advance(50 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+	var got []int
+	s := Session{
+		LatencyBuckets:  []time.Duration{40 * time.Millisecond, time.Hour},
+		OnLatencyBucket: func(bucket int) { got = append(got, bucket) },
+	}
+	s.SetClock(c)
+	s.Get(srv.URL+"/fast", nil)
+	s.Get(srv.URL+"/slow", nil)
+	assert.Equal(t, []int{0, 1}, got)
+}
+
+func TestConnectionClosed(t *testing.T) {
+	var conns int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/close" {
+			w.Header().Set("Connection", "close")
+		}
+		w.Write([]byte("ok"))
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	var l bufLogger
+	s := Session{Logger: &l}
+	resp, err := s.Get(srv.URL+"/keep", nil)
+	assert.NoError(t, err)
+	assert.False(t, resp.ConnectionClosed())
+	for i := 0; i < 2*closeWarnMin; i++ {
+		resp, err = s.Get(srv.URL+"/close", nil)
+		assert.NoError(t, err)
+		assert.True(t, resp.ConnectionClosed())
+	}
+	assert.Equal(t, int64(2*closeWarnMin), s.Stats().ConnectionsClosed)
+	assert.Equal(t, 1, strings.Count(l.String(), "WARNING"), l.String())
+	assert.Contains(t, l.String(), "Connection: close")
+
+	// Connections closed on purpose are not counted.
+	atomic.StoreInt32(&conns, 0)
+	s = Session{DisableKeepAlives: true, Logger: &l}
+	for i := 0; i < 3; i++ {
+		resp, err = s.Get(srv.URL+"/keep", nil)
+		assert.NoError(t, err)
+		assert.True(t, resp.ConnectionClosed())
+		assert.False(t, resp.Timings().ConnReused)
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&conns))
+	assert.Equal(t, int64(0), s.Stats().ConnectionsClosed)
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module copies the bytes written to and read from connections to
+Session.Tap, for debugging at the wire level.
+*/
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// tapConn is a connection which copies all bytes it reads and writes to w.
+type tapConn struct {
+	net.Conn
+	w  io.Writer
+	mu *sync.Mutex // Serializes writes to w from all connections
+}
+
+func (c *tapConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.tee(b[:n])
+	return n, err
+}
+
+func (c *tapConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.tee(b[:n])
+	return n, err
+}
+
+func (c *tapConn) tee(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	c.mu.Lock()
+	c.w.Write(b)
+	c.mu.Unlock()
+}
+
+// tapTLSConn is a tapped TLS connection, which reports its connection
+// state so that responses received on it carry it, as net/http only reads
+// it from a *tls.Conn.
+type tapTLSConn struct {
+	*tapConn
+	tc *tls.Conn
+}
+
+func (c *tapTLSConn) ConnectionState() tls.ConnectionState {
+	return c.tc.ConnectionState()
+}
+
+// handshakeTimeoutError reports a TLS handshake cut short by the
+// TLSHandshakeTimeout of the transport, as net/http does for its own
+// handshakes.
+type handshakeTimeoutError struct{}
+
+func (handshakeTimeoutError) Error() string   { return "net/http: TLS handshake timeout" }
+func (handshakeTimeoutError) Timeout() bool   { return true }
+func (handshakeTimeoutError) Temporary() bool { return true }
+
+// configureTap makes t copy the bytes of its connections to s.Tap.  TLS
+// connections to origins are made here, so that the tap sees plaintext,
+// within the TLSHandshakeTimeout of t; they speak HTTP/1.1.
+func (s *Session) configureTap(t *http.Transport) {
+	w := s.Tap
+	mu := new(sync.Mutex)
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &tapConn{Conn: conn, w: w, mu: mu}, nil
+	}
+	cfg := t.TLSClientConfig
+	t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		c := cfg.Clone()
+		if c == nil {
+			c = &tls.Config{}
+		}
+		if c.ServerName == "" {
+			c.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		c.NextProtos = nil
+		hctx := ctx
+		if t.TLSHandshakeTimeout > 0 {
+			var cancel context.CancelFunc
+			hctx, cancel = context.WithTimeout(ctx, t.TLSHandshakeTimeout)
+			defer cancel()
+		}
+		tc := tls.Client(conn, c)
+		if err := tc.HandshakeContext(hctx); err != nil {
+			conn.Close()
+			if hctx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+				err = handshakeTimeoutError{}
+			}
+			return nil, err
+		}
+		return &tapTLSConn{&tapConn{Conn: tc, w: w, mu: mu}, tc}, nil
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTap(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Reply", "pong")
+		w.Write([]byte(`{"title": "tapped"}`))
+	})
+	for _, tls := range []bool{false, true} {
+		var srv *httptest.Server
+		if tls {
+			srv = httptest.NewTLSServer(handler)
+		} else {
+			srv = httptest.NewServer(handler)
+		}
+		var buf bytes.Buffer
+		s := Session{Tap: &buf}
+		h := http.Header{}
+		h.Set("X-Ping", "ping")
+		var f feed
+		resp, err := s.Send(&Request{
+			Url:       srv.URL + "/wire",
+			Method:    "POST",
+			Header:    &h,
+			Payload:   map[string]int{"n": 1},
+			Result:    &f,
+			Transport: srv.Client().Transport.(*http.Transport),
+		})
+		cert := srv.Certificate()
+		srv.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, 200, resp.Status())
+		assert.Equal(t, "tapped", f.Title)
+		if tls {
+			// The TLS state survives the tap's own handshake.
+			assert.NotNil(t, resp.TLS())
+			assert.Equal(t, certFingerprint(cert.Raw), resp.PeerCertFingerprint())
+			_, ok := resp.TLSInfo()
+			assert.True(t, ok)
+		} else {
+			assert.Nil(t, resp.TLS())
+		}
+		wire := buf.String()
+		assert.Contains(t, wire, "POST /wire HTTP/1.1\r\n")
+		assert.Contains(t, wire, "X-Ping: ping\r\n")
+		assert.Contains(t, wire, `{"n":1}`)
+		assert.Contains(t, wire, "HTTP/1.1 200 OK\r\n")
+		assert.Contains(t, wire, "X-Reply: pong\r\n")
+		assert.Contains(t, wire, `{"title": "tapped"}`)
+	}
+}
+
+func TestTapHandshakeTimeout(t *testing.T) {
+	// A server which accepts connections but never answers the handshake.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	var buf bytes.Buffer
+	s := Session{Tap: &buf, ConnectTimeout: 50 * time.Millisecond}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = s.GetCtx(ctx, "https://"+ln.Addr().String(), nil)
+	var te *TimeoutError
+	if assert.True(t, errors.As(err, &te), "%v", err) {
+		assert.Equal(t, PhaseConnect, te.Phase)
+		assert.Equal(t, 50*time.Millisecond, te.Limit)
+	}
+	assert.NoError(t, ctx.Err())
+}
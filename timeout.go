@@ -0,0 +1,106 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module bounds connection establishment and the wait for response headers
+with timeouts of their own, independent of the body and overall timeouts.
+*/
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Phases of a request reported by TimeoutError.
+const (
+	PhaseConnect        = "connect"         // Dial and TLS handshake
+	PhaseResponseHeader = "response header" // Waiting for the response headers
+)
+
+// A TimeoutError reports that a request exceeded Session.ConnectTimeout or
+// Session.ResponseHeaderTimeout.  Timings shows where the time went.
+type TimeoutError struct {
+	Phase   string        // PhaseConnect or PhaseResponseHeader
+	Limit   time.Duration // The budget exceeded
+	Timings Timings
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	return "napping: " + e.Phase + " timeout of " + e.Limit.String() + " exceeded: " + e.Err.Error()
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports true, as for net.Error.
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// dialTimeoutError marks a dial cut short by Session.ConnectTimeout.
+type dialTimeoutError struct {
+	err error
+}
+
+func (e *dialTimeoutError) Error() string   { return e.err.Error() }
+func (e *dialTimeoutError) Unwrap() error   { return e.err }
+func (e *dialTimeoutError) Timeout() bool   { return true }
+func (e *dialTimeoutError) Temporary() bool { return true }
+
+// configureTimeouts applies ConnectTimeout to dialing and the TLS handshake
+// of t, and ResponseHeaderTimeout to t.
+func (s *Session) configureTimeouts(t *http.Transport) {
+	if d := s.ConnectTimeout; d > 0 {
+		dial := t.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{}).DialContext
+		}
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			conn, err := dial(dctx, network, addr)
+			if err != nil && dctx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+				err = &dialTimeoutError{err}
+			}
+			return conn, err
+		}
+		t.TLSHandshakeTimeout = d
+	}
+	if s.ResponseHeaderTimeout > 0 {
+		t.ResponseHeaderTimeout = s.ResponseHeaderTimeout
+	}
+}
+
+// timeoutError wraps err in a *TimeoutError if it was caused by
+// ConnectTimeout or ResponseHeaderTimeout.  net/http reports the transport's
+// own timeouts only by message.
+func (s *Session) timeoutError(err error, tr *tracer) error {
+	var (
+		dte  *dialTimeoutError
+		prev *TimeoutError
+	)
+	te := &TimeoutError{Err: err}
+	switch {
+	case errors.As(err, &prev):
+		return err
+	case errors.As(err, &dte),
+		s.ConnectTimeout > 0 && strings.Contains(err.Error(), "TLS handshake timeout"):
+		te.Phase, te.Limit = PhaseConnect, s.ConnectTimeout
+	case s.ResponseHeaderTimeout > 0 && strings.Contains(err.Error(), "timeout awaiting response headers"):
+		te.Phase, te.Limit = PhaseResponseHeader, s.ResponseHeaderTimeout
+	default:
+		return err
+	}
+	te.Timings = tr.done()
+	return te
+}
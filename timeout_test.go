@@ -0,0 +1,108 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectTimeoutDial(t *testing.T) {
+	tr := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, &net.OpError{Op: "dial", Net: network, Err: ctx.Err()}
+		},
+	}
+	s := NewSession(WithConnectTimeout(50 * time.Millisecond))
+	start := time.Now()
+	_, err := s.Send(&Request{Method: "GET", Url: "http://example.invalid/", Transport: tr})
+	var te *TimeoutError
+	if !assert.True(t, errors.As(err, &te), "%v", err) {
+		return
+	}
+	assert.Equal(t, PhaseConnect, te.Phase)
+	assert.Equal(t, 50*time.Millisecond, te.Limit)
+	assert.True(t, time.Since(start) < time.Second)
+	var ne net.Error
+	assert.True(t, errors.As(err, &ne) && ne.Timeout())
+}
+
+func TestConnectTimeoutTLSHandshake(t *testing.T) {
+	// A server which accepts connections but never answers the handshake.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	s := Session{ConnectTimeout: 100 * time.Millisecond}
+	_, err = s.Send(&Request{Method: "GET", Url: "https://" + l.Addr().String() + "/"})
+	var te *TimeoutError
+	if !assert.True(t, errors.As(err, &te), "%v", err) {
+		return
+	}
+	assert.Equal(t, PhaseConnect, te.Phase)
+	assert.True(t, te.Timings.Connect > 0)
+}
+
+func TestResponseHeaderTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow-headers" {
+			time.Sleep(300 * time.Millisecond)
+			return
+		}
+		// Headers at once, then a slow body.
+		w.Write([]byte("["))
+		w.(http.Flusher).Flush()
+		for i := 0; i < 3; i++ {
+			time.Sleep(100 * time.Millisecond)
+			w.Write([]byte("1,"))
+			w.(http.Flusher).Flush()
+		}
+		w.Write([]byte("1]"))
+	}))
+	defer srv.Close()
+	s := NewSession(
+		WithConnectTimeout(100*time.Millisecond),
+		WithResponseHeaderTimeout(100*time.Millisecond),
+	)
+
+	_, err := s.Get(srv.URL+"/slow-headers", nil)
+	var te *TimeoutError
+	if assert.True(t, errors.As(err, &te), "%v", err) {
+		assert.Equal(t, PhaseResponseHeader, te.Phase)
+		assert.Equal(t, 100*time.Millisecond, te.Limit)
+		assert.Contains(t, te.Error(), "response header timeout of 100ms exceeded")
+	}
+
+	// A body taking longer than either budget is not cut short.
+	var nums []int
+	resp, err := s.Send(&Request{Method: "GET", Url: srv.URL, Result: &nums})
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.Status())
+	assert.Len(t, nums, 4)
+
+	// Unless the session has a BodyTimeout.
+	s.BodyTimeout = 150 * time.Millisecond
+	_, err = s.Send(&Request{Method: "GET", Url: srv.URL, Result: &nums})
+	assert.Equal(t, ErrBodyTimeout, err)
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module records per-request timings using net/http/httptrace.
+*/
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ErrConnNotReused is returned by Send when Request.RequireReusedConn is set
+// and the request would have required a new connection.
+var ErrConnNotReused = errors.New("napping: request did not reuse a pooled connection")
+
+// Timings is a breakdown of where the time of a request was spent.  Phases
+// that did not happen, e.g. DNS lookup on a reused connection, are zero.
+type Timings struct {
+	DNS       time.Duration // DNS lookup
+	Connect   time.Duration // TCP connect
+	TLS       time.Duration // TLS handshake
+	FirstByte time.Duration // From sending the request to the first response byte
+	Total     time.Duration // From sending the request to the end of the body
+
+	ConnReused bool // Whether a pooled connection was reused
+	ConnIdle   bool // Whether the reused connection had been idle
+}
+
+// tracer collects Timings from httptrace callbacks, which may be invoked
+// from other goroutines.
+type tracer struct {
+	mu       sync.Mutex
+	t        Timings
+	start    time.Time
+	dnsStart time.Time
+	conStart time.Time
+	tlsStart time.Time
+	wrote    time.Time
+	remote   net.Addr
+	proxy    *url.URL // Chosen by the transport's Proxy function
+
+	// A TLS handshake started and has not succeeded.
+	handshake bool
+
+	// State of a TLS connection net/http does not know as such.
+	tlsState *tls.ConnectionState
+
+	now func() time.Time // Clock of the session
+
+	// onGotConn, if set, is called once a connection has been obtained.
+	onGotConn func(httptrace.GotConnInfo)
+}
+
+// tracerKey is the context key of the tracer of a request.
+type tracerKey struct{}
+
+// tracerFrom returns the tracer of the request with context ctx, or nil.
+func tracerFrom(ctx context.Context) *tracer {
+	tr, _ := ctx.Value(tracerKey{}).(*tracer)
+	return tr
+}
+
+func (tr *tracer) trace(ctx context.Context) context.Context {
+	ctx = context.WithValue(ctx, tracerKey{}, tr)
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			tr.mu.Lock()
+			tr.dnsStart = tr.now()
+			tr.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			tr.mu.Lock()
+			tr.t.DNS = tr.now().Sub(tr.dnsStart)
+			tr.mu.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			tr.mu.Lock()
+			tr.conStart = tr.now()
+			tr.mu.Unlock()
+		},
+		ConnectDone: func(string, string, error) {
+			tr.mu.Lock()
+			tr.t.Connect = tr.now().Sub(tr.conStart)
+			tr.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			tr.mu.Lock()
+			tr.tlsStart = tr.now()
+			tr.handshake = true
+			tr.mu.Unlock()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			tr.mu.Lock()
+			tr.t.TLS = tr.now().Sub(tr.tlsStart)
+			tr.handshake = err != nil
+			tr.mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			tr.mu.Lock()
+			tr.handshake = false
+			tr.tlsState = nil
+			if c, ok := info.Conn.(interface{ ConnectionState() tls.ConnectionState }); ok {
+				cs := c.ConnectionState()
+				tr.tlsState = &cs
+			}
+			tr.t.ConnReused = info.Reused
+			tr.t.ConnIdle = info.WasIdle
+			tr.remote = info.Conn.RemoteAddr()
+			tr.mu.Unlock()
+			if tr.onGotConn != nil {
+				tr.onGotConn(info)
+			}
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			tr.mu.Lock()
+			tr.wrote = tr.now()
+			tr.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			tr.mu.Lock()
+			tr.t.FirstByte = tr.now().Sub(tr.wrote)
+			tr.mu.Unlock()
+		},
+	})
+}
+
+// inHandshake reports whether a TLS handshake started and did not succeed.
+// It is false for a nil tracer.
+func (tr *tracer) inHandshake() bool {
+	if tr == nil {
+		return false
+	}
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return tr.handshake
+}
+
+// connState returns the TLS state of a connection which reported it, for
+// responses net/http gave none.
+func (tr *tracer) connState() *tls.ConnectionState {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return tr.tlsState
+}
+
+// remoteAddr returns the remote address of the connection used.
+func (tr *tracer) remoteAddr() net.Addr {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return tr.remote
+}
+
+// usedProxy returns the proxy recorded by Session.recordProxy.
+func (tr *tracer) usedProxy() *url.URL {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return tr.proxy
+}
+
+// done finalizes and returns the collected timings.
+func (tr *tracer) done() Timings {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.t.Total = tr.now().Sub(tr.start)
+	return tr.t
+}
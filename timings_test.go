@@ -0,0 +1,99 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWarmupReusesConn(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleEmptyOK))
+	defer srv.Close()
+	s := Session{}
+	if err := s.Warmup(context.Background(), srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	r := Request{Url: srv.URL, Method: "GET", RequireReusedConn: true}
+	resp, err := s.Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := resp.Timings()
+	assert.True(t, tm.ConnReused)
+	assert.Zero(t, tm.Connect)
+	assert.True(t, tm.Total > 0)
+}
+
+func TestRequireReusedConnFails(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleEmptyOK))
+	defer srv.Close()
+	s := Session{}
+	r := Request{Url: srv.URL, Method: "GET", RequireReusedConn: true}
+	_, err := s.Send(&r)
+	assert.Equal(t, ErrConnNotReused, err)
+
+	r = Request{Url: srv.URL, Method: "GET"}
+	resp, err := s.Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, resp.Timings().ConnReused)
+	assert.True(t, resp.Timings().Connect > 0)
+}
+
+func TestDisableConnReuse(t *testing.T) {
+	var conns []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conns = append(conns, req.RemoteAddr)
+	}))
+	defer srv.Close()
+	s := Session{}
+	if err := s.Warmup(context.Background(), srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	r := Request{Url: srv.URL, Method: "GET", DisableConnReuse: true}
+	resp, err := s.Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, resp.Timings().ConnReused)
+	assert.True(t, resp.HttpResponse().Request.Close)
+
+	// The pooled connection is still there for other requests.
+	r = Request{Url: srv.URL, Method: "GET", RequireReusedConn: true}
+	_, err = s.Send(&r)
+	assert.NoError(t, err)
+	if assert.Len(t, conns, 3) {
+		assert.NotEqual(t, conns[0], conns[1])
+		assert.Equal(t, conns[0], conns[2])
+	}
+
+	r = Request{Url: srv.URL, DisableConnReuse: true, RequireReusedConn: true}
+	assert.Error(t, r.Validate())
+}
+
+func TestNotProcessBodyReadable(t *testing.T) {
+	srv := httptest.NewServer(handleJSON(`{"Foo": "bar"}`))
+	defer srv.Close()
+	r := Request{Url: srv.URL, Method: "GET", NotProcessBody: true}
+	resp, err := Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := resp.HttpResponse().Body
+	defer body.Close()
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `{"Foo": "bar"}`, string(b))
+}
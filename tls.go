@@ -0,0 +1,212 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module configures the TLS versions and cipher suites a Session accepts,
+and reports which were negotiated.
+*/
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// configureTLS merges the TLS settings of s into t.
+func (s *Session) configureTLS(t *http.Transport) {
+	if s.TLSMinVersion == 0 && s.TLSMaxVersion == 0 && s.TLSCipherSuites == nil &&
+		s.PinnedCertFingerprints == nil {
+		return
+	}
+	cfg := t.TLSClientConfig.Clone()
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	if s.TLSMinVersion != 0 {
+		cfg.MinVersion = s.TLSMinVersion
+	}
+	if s.TLSMaxVersion != 0 {
+		cfg.MaxVersion = s.TLSMaxVersion
+	}
+	if s.TLSCipherSuites != nil {
+		cfg.CipherSuites = s.TLSCipherSuites
+	}
+	if s.PinnedCertFingerprints != nil {
+		cfg.VerifyPeerCertificate = pinVerifier(s.PinnedCertFingerprints, cfg.VerifyPeerCertificate)
+	}
+	t.TLSClientConfig = cfg
+}
+
+// A PinMismatchError reports a server whose leaf certificate matched none
+// of Session.PinnedCertFingerprints.
+type PinMismatchError struct {
+	Fingerprint string // SHA-256 of the leaf certificate presented
+}
+
+func (e *PinMismatchError) Error() string {
+	return "napping: certificate fingerprint " + e.Fingerprint + " matches no pin"
+}
+
+// certFingerprint returns the SHA-256 of a DER certificate in lower case hex.
+func certFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizePin lower cases a hex fingerprint and strips its colons.
+func normalizePin(pin string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(pin), ":", "", -1))
+}
+
+// pinVerifier returns a VerifyPeerCertificate callback rejecting leaf
+// certificates which match none of pins.  It runs after, and as well as, the
+// usual chain verification and next.
+func pinVerifier(pins []string, next func([][]byte, [][]*x509.Certificate) error) func([][]byte, [][]*x509.Certificate) error {
+	set := make(map[string]bool, len(pins))
+	for _, p := range pins {
+		set[normalizePin(p)] = true
+	}
+	return func(raw [][]byte, chains [][]*x509.Certificate) error {
+		if next != nil {
+			if err := next(raw, chains); err != nil {
+				return err
+			}
+		}
+		if len(raw) == 0 {
+			return &PinMismatchError{}
+		}
+		fp := certFingerprint(raw[0])
+		if !set[fp] {
+			return &PinMismatchError{Fingerprint: fp}
+		}
+		return nil
+	}
+}
+
+// TLS returns the state of the TLS connection the response was received
+// on, including the peer certificates and verified chains, or nil for plain
+// HTTP.
+func (r *Response) TLS() *tls.ConnectionState {
+	if r.response == nil {
+		return nil
+	}
+	return r.response.TLS
+}
+
+// PeerCertFingerprint returns the SHA-256 of the server's leaf certificate in
+// lower case hex, the form accepted by Session.PinnedCertFingerprints, or ""
+// for plain HTTP.
+func (r *Response) PeerCertFingerprint() string {
+	cs := r.TLS()
+	if cs == nil || len(cs.PeerCertificates) == 0 {
+		return ""
+	}
+	return certFingerprint(cs.PeerCertificates[0].Raw)
+}
+
+// TLSInfo describes the TLS connection a response was received on.
+type TLSInfo struct {
+	Version            uint16 // e.g. tls.VersionTLS12
+	CipherSuite        uint16 // e.g. tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	ServerName         string // Sent in SNI
+	NegotiatedProtocol string // Chosen by ALPN, e.g. "h2"
+	DidResume          bool   // Whether the session was resumed
+}
+
+// VersionName returns the name of the TLS version, e.g. "TLS 1.2".
+func (i TLSInfo) VersionName() string {
+	switch i.Version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04X", i.Version)
+}
+
+// CipherSuiteName returns the standard name of the cipher suite.
+func (i TLSInfo) CipherSuiteName() string {
+	return tls.CipherSuiteName(i.CipherSuite)
+}
+
+// TLSInfo returns the negotiated parameters of the TLS connection the
+// response was received on.  ok is false for plain HTTP.
+func (r *Response) TLSInfo() (info TLSInfo, ok bool) {
+	cs := r.TLS()
+	if cs == nil {
+		return
+	}
+	return TLSInfo{
+		Version:            cs.Version,
+		CipherSuite:        cs.CipherSuite,
+		ServerName:         cs.ServerName,
+		NegotiatedProtocol: cs.NegotiatedProtocol,
+		DidResume:          cs.DidResume,
+	}, true
+}
+
+// A TLSError reports the failure to establish a TLS connection, e.g. for
+// lack of a common version or cipher suite, an untrusted certificate or a
+// *PinMismatchError.
+// Such failures are not transient, so RetryPolicy does not retry them.
+type TLSError struct {
+	Err error
+}
+
+func (e *TLSError) Error() string {
+	return "napping: TLS handshake failed: " + e.Err.Error()
+}
+
+func (e *TLSError) Unwrap() error {
+	return e.Err
+}
+
+// isTLSError reports whether err is a failure of the TLS handshake.
+// handshake tells whether a handshake was started and did not complete, so
+// that local alerts, which have no type, are told apart from errors on
+// established connections such as "tls: use of closed connection".
+func isTLSError(err error, handshake bool) bool {
+	var (
+		te  *TLSError
+		pme *PinMismatchError
+		rhe tls.RecordHeaderError
+		cve *tls.CertificateVerificationError
+		uae x509.UnknownAuthorityError
+		he  x509.HostnameError
+		cie x509.CertificateInvalidError
+	)
+	switch {
+	case err == nil:
+		return false
+	case errors.As(err, &te), errors.As(err, &pme), errors.As(err, &rhe), errors.As(err, &cve),
+		errors.As(err, &uae), errors.As(err, &he), errors.As(err, &cie):
+		return true
+	}
+	// Alerts, such as "remote error: tls: handshake failure", have no
+	// exported type before Go 1.21.  The server sends them only during the
+	// handshake.
+	msg := err.Error()
+	return strings.Contains(msg, "remote error: tls: ") || handshake && strings.Contains(msg, "tls: ")
+}
+
+// tlsError wraps err in a *TLSError if it is a TLS handshake failure.
+func tlsError(err error, handshake bool) error {
+	var te *TLSError
+	if !isTLSError(err, handshake) || errors.As(err, &te) {
+		return err
+	}
+	return &TLSError{Err: err}
+}
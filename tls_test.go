@@ -0,0 +1,186 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTLSVersionAndCipher(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(200)
+	}))
+	defer srv.Close()
+	suites := []uint16{
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	}
+	s := Session{
+		TLSMinVersion:   tls.VersionTLS12,
+		TLSMaxVersion:   tls.VersionTLS12,
+		TLSCipherSuites: suites,
+	}
+	r := Request{
+		Method:    "GET",
+		Url:       srv.URL,
+		Transport: srv.Client().Transport.(*http.Transport),
+	}
+	resp, err := s.Send(&r)
+	assert.NoError(t, err)
+	info, ok := resp.TLSInfo()
+	assert.True(t, ok)
+	assert.Equal(t, uint16(tls.VersionTLS12), info.Version)
+	assert.Equal(t, "TLS 1.2", info.VersionName())
+	assert.Contains(t, suites, info.CipherSuite)
+	assert.Equal(t, tls.CipherSuiteName(info.CipherSuite), info.CipherSuiteName())
+}
+
+func TestTLSInfoPlainHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(200)
+	}))
+	defer srv.Close()
+	s := Session{}
+	resp, err := s.Get(srv.URL, nil)
+	assert.NoError(t, err)
+	_, ok := resp.TLSInfo()
+	assert.False(t, ok)
+	assert.Nil(t, resp.TLS())
+	assert.Equal(t, "", resp.PeerCertFingerprint())
+}
+
+func TestTLSHandshakeError(t *testing.T) {
+	var conns int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(200)
+	}))
+	srv.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.StartTLS()
+	defer srv.Close()
+	s := Session{
+		TLSMinVersion: tls.VersionTLS13,
+		Retry:         &RetryPolicy{MaxAttempts: 3},
+	}
+	r := Request{
+		Method:    "GET",
+		Url:       srv.URL,
+		Transport: srv.Client().Transport.(*http.Transport),
+	}
+	_, err := s.Send(&r)
+	var te *TLSError
+	assert.True(t, errors.As(err, &te), "%v", err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&conns))
+}
+
+func TestIsTLSError(t *testing.T) {
+	assert.False(t, isTLSError(nil, true))
+	assert.False(t, isTLSError(errors.New("connection refused"), true))
+	assert.True(t, isTLSError(tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, false))
+	assert.True(t, isTLSError(errors.New("remote error: tls: handshake failure"), false))
+	err := tlsError(errors.New("remote error: tls: protocol version not supported"), false)
+	assert.Equal(t, err, tlsError(err, false))
+
+	// Local alerts count only during the handshake.
+	local := errors.New("tls: server selected unsupported protocol version 301")
+	assert.True(t, isTLSError(local, true))
+	assert.False(t, isTLSError(local, false))
+
+	// Errors on an established connection are still retried.
+	closed := errors.New("tls: use of closed connection")
+	assert.False(t, isTLSError(closed, false))
+	p := &RetryPolicy{MaxAttempts: 2}
+	assert.True(t, p.retryable(nil, closed, false))
+	assert.False(t, p.retryable(nil, local, true))
+}
+
+// flakyTLSTransport fails its first round trip with an error of an
+// established TLS connection, and sends later ones with Transport.
+type flakyTLSTransport struct {
+	*http.Transport
+	calls int32
+}
+
+func (f *flakyTLSTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if atomic.AddInt32(&f.calls, 1) == 1 {
+		return nil, errors.New("tls: use of closed connection")
+	}
+	return f.Transport.RoundTrip(req)
+}
+
+func TestRetryAfterTLSConnError(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	tr := &flakyTLSTransport{Transport: srv.Client().Transport.(*http.Transport)}
+	s := Session{Transport: tr, Retry: &RetryPolicy{MaxAttempts: 2}}
+	resp, err := s.Get(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "ok", resp.RawText())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&tr.calls))
+}
+
+func TestPinnedCertFingerprints(t *testing.T) {
+	var requests int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(200)
+	}))
+	defer srv.Close()
+	leaf := srv.Certificate()
+	fp := certFingerprint(leaf.Raw)
+	send := func(pins []string) (*Response, error) {
+		s := Session{PinnedCertFingerprints: pins}
+		r := Request{
+			Method:    "GET",
+			Url:       srv.URL,
+			Transport: srv.Client().Transport.(*http.Transport),
+		}
+		return s.Send(&r)
+	}
+
+	// The current certificate among several pins, as while rotating, in
+	// upper case with colons.
+	colons := ""
+	for i := 0; i < len(fp); i += 2 {
+		if i > 0 {
+			colons += ":"
+		}
+		colons += fp[i : i+2]
+	}
+	old := strings.Repeat("ab", 32)
+	resp, err := send([]string{old, strings.ToUpper(colons)})
+	assert.NoError(t, err)
+	assert.Equal(t, fp, resp.PeerCertFingerprint())
+	assert.NotNil(t, resp.TLS())
+	assert.Equal(t, leaf.Raw, resp.TLS().PeerCertificates[0].Raw)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// No matching pin: rejected before any request is written.
+	_, err = send([]string{old})
+	var pme *PinMismatchError
+	assert.True(t, errors.As(err, &pme), "%v", err)
+	assert.Equal(t, fp, pme.Fingerprint)
+	var te *TLSError
+	assert.True(t, errors.As(err, &te))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module applies the user-supplied transformations of request and response
+bodies.
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// transformPayload passes the encoded body through s.PayloadTransformers
+// and returns the final body and content type.  A declared ContentLength is
+// checked against the body before it is transformed.
+func (s *Session) transformPayload(r *Request, body io.Reader, contentType string) (io.Reader, string, error) {
+	var b []byte
+	if buf, ok := body.(*bytes.Buffer); ok {
+		b = buf.Bytes()
+	} else {
+		var err error
+		if b, err = ioutil.ReadAll(body); err != nil {
+			return nil, "", err
+		}
+		if r.ContentLength > 0 && int64(len(b)) != r.ContentLength {
+			return nil, "", &ContentLengthError{Declared: r.ContentLength, Actual: int64(len(b))}
+		}
+	}
+	for i, t := range s.PayloadTransformers {
+		var err error
+		if b, contentType, err = t(contentType, b); err != nil {
+			return nil, "", fmt.Errorf("napping: payload transformer %d: %w", i, err)
+		}
+	}
+	return bytes.NewReader(b), contentType, nil
+}
+
+// A ResponseTransformError reports that one of Session.ResponseTransformers
+// or Session.ResponseStreamTransformers failed, e.g. because a signature did
+// not verify.
+type ResponseTransformError struct {
+	Index int // Position of the transformer in its list
+	Err   error
+}
+
+func (e *ResponseTransformError) Error() string {
+	return fmt.Sprintf("napping: response transformer %d: %v", e.Index, e.Err)
+}
+
+func (e *ResponseTransformError) Unwrap() error {
+	return e.Err
+}
+
+// transformStream wraps resp.Body with s.ResponseStreamTransformers.
+func (s *Session) transformStream(resp *http.Response) error {
+	for i, t := range s.ResponseStreamTransformers {
+		rd, err := t(resp, resp.Body)
+		if err != nil {
+			return &ResponseTransformError{Index: i, Err: err}
+		}
+		if rd == io.Reader(resp.Body) {
+			continue
+		}
+		rc, ok := rd.(io.ReadCloser)
+		if !ok {
+			rc = ioutil.NopCloser(rd)
+		}
+		resp.Body = &decodedBody{rc, resp.Body}
+	}
+	return nil
+}
+
+// transformResponse passes a buffered body through s.ResponseTransformers.
+func (s *Session) transformResponse(resp *http.Response, body []byte) ([]byte, error) {
+	for i, t := range s.ResponseTransformers {
+		var err error
+		if body, err = t(resp, body); err != nil {
+			return nil, &ResponseTransformError{Index: i, Err: err}
+		}
+	}
+	return body, nil
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stripField returns a payload transformer removing a top-level JSON field.
+func stripField(name string) func(string, []byte) ([]byte, string, error) {
+	return func(contentType string, body []byte) ([]byte, string, error) {
+		if contentType != "application/json" {
+			return body, contentType, nil
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal(body, &m); err != nil {
+			return nil, "", err
+		}
+		delete(m, name)
+		b, err := json.Marshal(m)
+		return b, contentType, err
+	}
+}
+
+// envelope wraps the body in a JWE-like JSON envelope.
+func envelope(contentType string, body []byte) ([]byte, string, error) {
+	b, err := json.Marshal(map[string]string{
+		"cty":        contentType,
+		"ciphertext": base64.RawURLEncoding.EncodeToString(body),
+	})
+	return b, "application/jose+json", err
+}
+
+func TestPayloadTransformers(t *testing.T) {
+	var got struct {
+		contentType string
+		length      string
+		body        string
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		got.contentType = req.Header.Get("Content-Type")
+		got.length = strconv.FormatInt(req.ContentLength, 10)
+		got.body = string(b)
+	}))
+	defer srv.Close()
+	var signed string
+	s := Session{
+		PayloadTransformers: []func(string, []byte) ([]byte, string, error){stripField("ssn"), envelope},
+		BeforeSend: func(r *Request, req *http.Request) error {
+			b, _ := req.GetBody()
+			body, _ := ioutil.ReadAll(b)
+			signed = req.Header.Get("Content-Type") + " " + string(body)
+			return nil
+		},
+	}
+	payload := map[string]string{"name": "kirk", "ssn": "123-45-6789"}
+	if _, err := s.Post(srv.URL, payload); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "application/jose+json", got.contentType)
+	assert.Equal(t, strconv.Itoa(len(got.body)), got.length)
+	assert.Equal(t, "application/jose+json "+got.body, signed)
+	var env map[string]string
+	json.Unmarshal([]byte(got.body), &env)
+	assert.Equal(t, "application/json", env["cty"])
+	inner, _ := base64.RawURLEncoding.DecodeString(env["ciphertext"])
+	assert.Equal(t, `{"name":"kirk"}`, string(inner))
+
+	// Reader payloads are buffered and transformed as well.
+	h := http.Header{"Content-Type": {"application/json"}}
+	_, err := s.Send(&Request{Method: "PUT", Url: srv.URL, Header: &h, Payload: strings.NewReader(`{"ssn":"x","id":2}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal([]byte(got.body), &env)
+	inner, _ = base64.RawURLEncoding.DecodeString(env["ciphertext"])
+	assert.Equal(t, `{"id":2}`, string(inner))
+
+	// Errors abort the request.
+	got.body = "untouched"
+	s.PayloadTransformers = append(s.PayloadTransformers, func(string, []byte) ([]byte, string, error) {
+		return nil, "", errors.New("no key")
+	})
+	_, err = s.Post(srv.URL, payload)
+	assert.EqualError(t, err, "napping: payload transformer 2: no key")
+	assert.Equal(t, "untouched", got.body)
+}
+
+// unwrapSigned verifies and unwraps a {"signature":..., "payload":...}
+// envelope signed with key.
+func unwrapSigned(key []byte) func(*http.Response, []byte) ([]byte, error) {
+	return func(resp *http.Response, body []byte) ([]byte, error) {
+		var env struct {
+			Signature string
+			Payload   string
+		}
+		if err := json.Unmarshal(body, &env); err != nil {
+			return nil, err
+		}
+		payload, err := base64.StdEncoding.DecodeString(env.Payload)
+		if err != nil {
+			return nil, err
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write(payload)
+		if hex.EncodeToString(mac.Sum(nil)) != env.Signature {
+			return nil, errBadSignature
+		}
+		return payload, nil
+	}
+}
+
+var errBadSignature = errors.New("bad signature")
+
+func TestResponseTransformers(t *testing.T) {
+	key := []byte("secret")
+	signingKey := key
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		payload := []byte(`{"Foo":"verified"}`)
+		mac := hmac.New(sha256.New, signingKey)
+		mac.Write(payload)
+		json.NewEncoder(w).Encode(map[string]string{
+			"signature": hex.EncodeToString(mac.Sum(nil)),
+			"payload":   base64.StdEncoding.EncodeToString(payload),
+		})
+	}))
+	defer srv.Close()
+	s := Session{ResponseTransformers: []func(*http.Response, []byte) ([]byte, error){unwrapSigned(key)}}
+
+	var res payload
+	resp, err := s.Send(&Request{Url: srv.URL, Result: &res, StreamResult: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "verified", res.Foo)
+	assert.Equal(t, `{"Foo":"verified"}`, resp.RawText())
+
+	signingKey = []byte("forged")
+	res = payload{}
+	_, err = s.Send(&Request{Url: srv.URL, Result: &res})
+	var te *ResponseTransformError
+	if assert.True(t, errors.As(err, &te)) {
+		assert.Equal(t, 0, te.Index)
+	}
+	assert.True(t, errors.Is(err, errBadSignature))
+	assert.Equal(t, "", res.Foo)
+}
+
+func TestResponseStreamTransformers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(")]}'\n{\"Foo\":\"bar\"}"))
+	}))
+	defer srv.Close()
+	stripXSSI := func(resp *http.Response, body io.Reader) (io.Reader, error) {
+		br := bufio.NewReader(body)
+		prefix, err := br.ReadString('\n')
+		if err != nil || prefix != ")]}'\n" {
+			return nil, errors.New("missing XSSI prefix")
+		}
+		return br, nil
+	}
+	s := Session{ResponseStreamTransformers: []func(*http.Response, io.Reader) (io.Reader, error){stripXSSI}}
+	var res payload
+	if _, err := s.Send(&Request{Url: srv.URL, Result: &res, StreamResult: true}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "bar", res.Foo)
+
+	resp, err := s.Send(&Request{Url: srv.URL, NotProcessBody: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.HttpResponse().Body)
+	resp.HttpResponse().Body.Close()
+	assert.Equal(t, `{"Foo":"bar"}`, string(b))
+}
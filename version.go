@@ -0,0 +1,31 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	_ "embed"
+	"strings"
+)
+
+//go:embed VERSION
+var versionFile string
+
+// Version is the napping release, sent in the default User-Agent.  It is
+// read from the VERSION file, unless set at build time:
+//
+//	go build -ldflags "-X github.com/yinyajiang/napping.Version=v3.2.0-rc1"
+var Version string
+
+func init() {
+	if Version == "" {
+		Version = strings.TrimSpace(versionFile)
+	}
+}
+
+// userAgent returns the User-Agent sent when none is configured.
+func userAgent() string {
+	return "napping/" + Version
+}